@@ -0,0 +1,157 @@
+// Package anglianwater is a client of the API behind the Anglian Water
+// account, which provides the readings of smart meters.
+package anglianwater
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultURL is the base URL of the API used by the Anglian Water app.
+const DefaultURL = "https://my.anglianwater.co.uk/mobile/api"
+
+// Option configures the Client.
+type Option func(*Client)
+
+// WithURL sends the requests to the base URL, instead of the DefaultURL.
+func WithURL(u string) Option {
+	return func(c *Client) {
+		c.url = strings.TrimSuffix(u, "/")
+	}
+}
+
+// WithTransport sends the requests through the transport, instead of the
+// http.DefaultTransport.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) {
+		c.httpClient.Transport = rt
+	}
+}
+
+type Client struct {
+	url        string
+	httpClient *http.Client
+	token      string
+}
+
+func New(opts ...Option) *Client {
+	c := &Client{
+		url:        DefaultURL,
+		httpClient: &http.Client{Timeout: time.Minute},
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+type LoginRequest struct {
+	EmailAddress string `json:"EmailAddress"`
+	Password     string `json:"Password"`
+}
+
+type LoginResponse struct {
+	AccessToken   string `json:"AccessToken"`
+	AccountNumber string `json:"AccountNumber"`
+}
+
+// Login authenticates the client, the access token is used by all further
+// requests.
+func (c *Client) Login(ctx context.Context, email, password string) (*LoginResponse, error) {
+	body, err := json.Marshal(LoginRequest{EmailAddress: email, Password: password})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp LoginResponse
+	if err := c.do(ctx, http.MethodPost, "/Login", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.AccessToken == "" {
+		return nil, fmt.Errorf("no access token in login response")
+	}
+	c.token = resp.AccessToken
+	return &resp, nil
+}
+
+type Meter struct {
+	MeterSerialNumber string `json:"MeterSerialNumber"`
+	// FirstReadDate and LastReadDate are formatted as YYYY-MM-DD.
+	FirstReadDate string `json:"FirstReadDate"`
+	LastReadDate  string `json:"LastReadDate"`
+}
+
+type GetMetersResponse struct {
+	Meters []Meter `json:"Meters"`
+}
+
+func (c *Client) GetMeters(ctx context.Context) (*GetMetersResponse, error) {
+	var resp GetMetersResponse
+	if err := c.do(ctx, http.MethodGet, "/GetMeters", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+type HourlyReading struct {
+	// ReadDateTime is the start of the interval, formatted as
+	// 2006-01-02T15:04:05.
+	ReadDateTime string `json:"ReadDateTime"`
+	// Consumption within the interval in liters.
+	Consumption float64 `json:"Consumption"`
+	// MeterReading is the register of the meter in cubic meters.
+	MeterReading float64 `json:"MeterReading"`
+	IsEstimated  bool    `json:"IsEstimated"`
+}
+
+type GetHourlyUsageResponse struct {
+	Readings []HourlyReading `json:"Readings"`
+}
+
+// GetHourlyUsage returns the hourly readings of the meter on the day.
+func (c *Client) GetHourlyUsage(ctx context.Context, meter string, day time.Time) (*GetHourlyUsageResponse, error) {
+	values := url.Values{}
+	values.Set("meterSerialNumber", meter)
+	values.Set("date", day.Format("2006-01-02"))
+
+	var resp GetHourlyUsageResponse
+	if err := c.do(ctx, http.MethodGet, "/GetHourlyUsage", values, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, values url.Values, body []byte, v interface{}) error {
+	u := c.url + path
+	if len(values) > 0 {
+		u += "?" + values.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
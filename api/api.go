@@ -22,17 +22,32 @@ const (
 
 type additionalHeaders struct {
 	http.Header
+	next http.RoundTripper
 }
 
 func (a *additionalHeaders) RoundTrip(req *http.Request) (*http.Response, error) {
 	for key, val := range a.Header {
 		req.Header[key] = val
 	}
-	return http.DefaultTransport.RoundTrip(req)
+	return a.next.RoundTrip(req)
 }
 
-func New(cookies []*http.Cookie) (*Client, error) {
-	var h = additionalHeaders{make(http.Header)}
+// Option configures the Client.
+type Option func(*additionalHeaders)
+
+// WithTransport sends the requests through the transport, instead of the
+// http.DefaultTransport.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(h *additionalHeaders) {
+		h.next = rt
+	}
+}
+
+func New(cookies []*http.Cookie, opts ...Option) (*Client, error) {
+	var h = additionalHeaders{Header: make(http.Header), next: http.DefaultTransport}
+	for _, o := range opts {
+		o(&h)
+	}
 	h.Set("x-requested-with", "XMLHttpRequest")
 	h.Set("referer", dashboardURL)
 
@@ -120,10 +135,18 @@ func (c *Client) GetMeters(ctx context.Context) (*GetMetersResponse, error) {
 	return &meters, nil
 }
 
+// Granularities of the consumption readings.
+const (
+	GranularityHourly = "H"
+	GranularityDaily  = "D"
+)
+
 type GetSmartWaterMeterConsumptionsRequest struct {
 	Meter     string
 	StartDate time.Time
 	EndDate   time.Time
+	// Granularity of the readings, defaults to GranularityHourly.
+	Granularity string
 }
 
 type SmartWaterMeterReading struct {
@@ -161,7 +184,11 @@ func (c *Client) GetSmartWaterMeterConsumptions(ctx context.Context, req GetSmar
 	values.Set("endDate", fmt.Sprintf("%02d", req.EndDate.Day()))
 	values.Set("endMonth", fmt.Sprintf("%02d", req.EndDate.Month()))
 	values.Set("endYear", fmt.Sprintf("%d", req.EndDate.Year()))
-	values.Set("granularity", "H")
+	granularity := req.Granularity
+	if granularity == "" {
+		granularity = GranularityHourly
+	}
+	values.Set("granularity", granularity)
 	values.Set("premiseId", "")
 	values.Set("isForC4C", "false")
 	u.RawQuery = values.Encode()
@@ -185,9 +212,97 @@ func (c *Client) GetSmartWaterMeterConsumptions(ctx context.Context, req GetSmar
 
 	var readings GetSmartWaterMeterConsumptionsResponse
 
-	if err := json.NewDecoder(resp.Body).Decode(&readings); err != nil {
+	if err := decodeConsumptions(resp.Body, &readings); err != nil {
 		return nil, err
 	}
 
 	return &readings, nil
 }
+
+// decodeConsumptions decodes the response while streaming it, the lines are
+// decoded one at a time, so the response body is never held in memory as a
+// whole.
+func decodeConsumptions(r io.Reader, readings *GetSmartWaterMeterConsumptionsResponse) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("unexpected token %v, expected a key", tok)
+		}
+
+		var v interface{}
+		switch key {
+		case "Lines":
+			if err := decodeConsumptionLines(dec, readings); err != nil {
+				return fmt.Errorf("error decoding lines: %w", err)
+			}
+			continue
+		case "IsError":
+			v = &readings.IsError
+		case "IsDataAvailable":
+			v = &readings.IsDataAvailable
+		case "IsConsumptionAvailable":
+			v = &readings.IsConsumptionAvailable
+		case "AlertsValues":
+			v = &readings.AlertsValues
+		case "TargetUsage":
+			v = &readings.TargetUsage
+		case "AverageUsage":
+			v = &readings.AverageUsage
+		case "ActualUsage":
+			v = &readings.ActualUsage
+		case "MyUsage":
+			v = &readings.MyUsage
+		case "AverageUsagePerPerson":
+			v = &readings.AverageUsagePerPerson
+		default:
+			v = new(json.RawMessage)
+		}
+		if err := dec.Decode(v); err != nil {
+			return fmt.Errorf("error decoding %s: %w", key, err)
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// decodeConsumptionLines decodes the array of lines one line at a time.
+func decodeConsumptionLines(dec *json.Decoder, readings *GetSmartWaterMeterConsumptionsResponse) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		readings.Lines = nil
+		return nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return fmt.Errorf("unexpected token %v, expected [", tok)
+	}
+	readings.Lines = []SmartWaterMeterReading{}
+	for dec.More() {
+		var line SmartWaterMeterReading
+		if err := dec.Decode(&line); err != nil {
+			return err
+		}
+		readings.Lines = append(readings.Lines, line)
+	}
+	return expectDelim(dec, ']')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("unexpected token %v, expected %s", tok, delim)
+	}
+	return nil
+}
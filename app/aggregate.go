@@ -0,0 +1,147 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+const (
+	consumptionMetricName = "water_consumption_liters"
+	// estimatedMetricName marks the consumption samples of estimated readings
+	// with a sample of 1, it has no samples for actual readings.
+	estimatedMetricName = "water_consumption_estimated"
+)
+
+// aggregation describes a low-cardinality series, which sums up the
+// consumption over a calendar period.
+type aggregation struct {
+	metricName string
+	// start returns the start of the period containing t.
+	start func(t time.Time) time.Time
+	// end returns the exclusive end of the period starting at start.
+	end func(start time.Time) time.Time
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func weeklyAggregation() aggregation {
+	return aggregation{
+		metricName: "water_consumption_weekly_liters",
+		start: func(t time.Time) time.Time {
+			t = truncateDay(t)
+			// weeks start on Monday
+			offset := (int(t.Weekday()) + 6) % 7
+			return t.AddDate(0, 0, -offset)
+		},
+		end: func(start time.Time) time.Time {
+			return start.AddDate(0, 0, 7)
+		},
+	}
+}
+
+// billingMonthAggregation aligns the monthly period to the account's billing
+// cycle, which starts on the anchorDay of each month.
+func billingMonthAggregation(anchorDay int) aggregation {
+	return aggregation{
+		metricName: "water_consumption_monthly_liters",
+		start: func(t time.Time) time.Time {
+			t = truncateDay(t)
+			start := time.Date(t.Year(), t.Month(), anchorDay, 0, 0, 0, 0, t.Location())
+			if start.After(t) {
+				start = start.AddDate(0, -1, 0)
+			}
+			return start
+		},
+		end: func(start time.Time) time.Time {
+			return start.AddDate(0, 1, 0)
+		},
+	}
+}
+
+func (a *App) aggregations() []aggregation {
+	if !a.cfg.aggregateSeries {
+		return nil
+	}
+	return []aggregation{
+		weeklyAggregation(),
+		billingMonthAggregation(a.cfg.billingAnchorDay),
+	}
+}
+
+// appendAggregates writes the totals of all periods, which are completed by
+// the given day. Periods starting before firstDay are skipped, as they would
+// only contain partial data.
+func (a *App) appendAggregates(ctx context.Context, db *tsdb.DB, day, firstDay time.Time) error {
+	for _, agg := range a.aggregations() {
+		start := agg.start(day)
+		end := agg.end(start)
+
+		// only aggregate once the last day of the period has been imported
+		if !truncateDay(day).AddDate(0, 0, 1).Equal(end) {
+			continue
+		}
+		if start.Before(truncateDay(firstDay)) {
+			_ = level.Debug(a.logger).Log("msg", "skipped partial aggregation period", "metric", agg.metricName, "start", start.Format("2006-01-02"))
+			continue
+		}
+
+		if err := a.appendAggregate(ctx, db, agg, start, end); err != nil {
+			return fmt.Errorf("error aggregating %s: %w", agg.metricName, err)
+		}
+	}
+	return nil
+}
+
+func (a *App) appendAggregate(ctx context.Context, db *tsdb.DB, agg aggregation, start, end time.Time) error {
+	mint, maxt := timestamp.FromTime(start), timestamp.FromTime(end)-1
+
+	q, err := db.Querier(ctx, mint, maxt)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	type total struct {
+		lbls  labels.Labels
+		value float64
+	}
+	var totals []total
+
+	ss := q.Select(false, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, consumptionMetricName))
+	for ss.Next() {
+		s := ss.At()
+		t := total{lbls: s.Labels()}
+		it := s.Iterator()
+		for it.Next() {
+			_, v := it.At()
+			t.value += v
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+		totals = append(totals, t)
+	}
+	if err := ss.Err(); err != nil {
+		return err
+	}
+
+	appender := a.appender(ctx, db)
+	for _, t := range totals {
+		lbls := labels.NewBuilder(t.lbls)
+		lbls.Set(labels.MetricName, agg.metricName)
+		if _, err := appender.Append(0, lbls.Labels(), maxt, t.value); err != nil {
+			_ = appender.Rollback()
+			return err
+		}
+		_ = level.Debug(a.logger).Log("msg", "appended aggregate", "series", lbls.Labels(), "start", start.Format("2006-01-02"), "value", t.value)
+	}
+	return appender.Commit()
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// Alert is raised by the analysis of the readings imported during a run.
+type Alert struct {
+	// Name identifies the kind of alert, e.g. WaterLeak.
+	Name  string `json:"name"`
+	Meter string `json:"meter"`
+	// Summary describes the alert in a single sentence.
+	Summary string `json:"summary"`
+	// StartsAt and EndsAt delimit the readings, which raised the alert.
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+}
+
+// alertRule analyzes the readings of a run.
+type alertRule interface {
+	name() string
+	evaluate(ctx context.Context, readings []Reading) ([]Alert, error)
+}
+
+// alertRules returns the configured rules.
+func (a *App) alertRules() []alertRule {
+	var rules []alertRule
+	if a.cfg.leakDetection != nil {
+		rules = append(rules, leakRule{cfg: *a.cfg.leakDetection})
+	}
+	for _, r := range a.cfg.thresholdRules {
+		rules = append(rules, thresholdRule{cfg: r})
+	}
+	if a.cfg.anomalyDetection != nil {
+		rules = append(rules, &anomalyRule{
+			cfg:    *a.cfg.anomalyDetection,
+			query:  a.querySamples,
+			scores: a.metrics.anomalyScore,
+		})
+	}
+	return rules
+}
+
+// evaluateAlerts runs all rules against the readings imported by the run.
+// Failing rules are only logged.
+func (a *App) evaluateAlerts(ctx context.Context, readings []Reading) []Alert {
+	var alerts []Alert
+	for _, r := range a.alertRules() {
+		ruleAlerts, err := r.evaluate(ctx, readings)
+		if err != nil {
+			_ = level.Warn(a.logger).Log("msg", "error evaluating alert rule", "rule", r.name(), "err", err)
+			continue
+		}
+		alerts = append(alerts, ruleAlerts...)
+	}
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return alerts[i].StartsAt.Before(alerts[j].StartsAt)
+	})
+	return alerts
+}
+
+// readingsByMeterAndDay groups the readings, which are sorted by time within
+// each group.
+func readingsByMeterAndDay(readings []Reading) map[string]map[time.Time][]Reading {
+	groups := make(map[string]map[time.Time][]Reading)
+	for _, r := range readings {
+		days, ok := groups[r.Meter]
+		if !ok {
+			days = make(map[time.Time][]Reading)
+			groups[r.Meter] = days
+		}
+		day := truncateDay(r.Time)
+		days[day] = append(days[day], r)
+	}
+	for _, days := range groups {
+		for _, rs := range days {
+			sort.Slice(rs, func(i, j int) bool {
+				return rs[i].Time.Before(rs[j].Time)
+			})
+		}
+	}
+	return groups
+}
+
+// sortedDays returns the days of the group, oldest first.
+func sortedDays(days map[time.Time][]Reading) []time.Time {
+	result := make([]time.Time, 0, len(days))
+	for day := range days {
+		result = append(result, day)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Before(result[j])
+	})
+	return result
+}
+
+func formatLiters(v float64) string {
+	return fmt.Sprintf("%.0f l", v)
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+// Alertmanager configures an Alertmanager, which receives the alerts raised by
+// a run.
+type Alertmanager struct {
+	URL string `yaml:"url"`
+	// Labels are added to every alert, e.g. to route them.
+	Labels map[string]string `yaml:"labels"`
+	// ResolveTimeout is how long the alerts stay active, once sent. The
+	// readings are imported with a delay, so there is no way to resolve them
+	// explicitly. By default they are active for 24 hours.
+	ResolveTimeout time.Duration `yaml:"resolve_timeout"`
+	BasicAuth      *BasicAuth    `yaml:"basic_auth"`
+	Timeout        time.Duration `yaml:"timeout"`
+	Retries        int           `yaml:"retries"`
+}
+
+// WithAlertmanager sends the alerts raised by a run to the Alertmanager.
+func WithAlertmanager(am Alertmanager) NewOption {
+	return func(a *App) {
+		a.cfg.alertmanagers = append(a.cfg.alertmanagers, am)
+	}
+}
+
+type alertmanagerNotifier struct {
+	logger log.Logger
+	cfg    Alertmanager
+}
+
+func (n *alertmanagerNotifier) name() string {
+	return "alertmanager " + n.cfg.URL
+}
+
+// alertmanagerAlert is an alert of the Alertmanager v2 API.
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+func (n *alertmanagerNotifier) alerts(alerts []Alert, now time.Time) []alertmanagerAlert {
+	resolveTimeout := n.cfg.ResolveTimeout
+	if resolveTimeout <= 0 {
+		resolveTimeout = 24 * time.Hour
+	}
+
+	result := make([]alertmanagerAlert, 0, len(alerts))
+	for _, alert := range alerts {
+		lbls := map[string]string{
+			"alertname": alert.Name,
+			"meter":     alert.Meter,
+			"severity":  "warning",
+		}
+		for name, value := range n.cfg.Labels {
+			lbls[name] = value
+		}
+		result = append(result, alertmanagerAlert{
+			Labels: lbls,
+			Annotations: map[string]string{
+				"summary":   alert.Summary,
+				"starts_at": alert.StartsAt.Format("2006-01-02 15:04"),
+				"ends_at":   alert.EndsAt.Format("2006-01-02 15:04"),
+			},
+			StartsAt: now,
+			EndsAt:   now.Add(resolveTimeout),
+		})
+	}
+	return result
+}
+
+func (n *alertmanagerNotifier) notify(ctx context.Context, summary RunSummary) error {
+	if len(summary.Alerts) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(n.alerts(summary.Alerts, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	u := strings.TrimSuffix(n.cfg.URL, "/") + "/api/v2/alerts"
+	client := &http.Client{Timeout: n.cfg.Timeout}
+	return doWithRetries(ctx, log.With(n.logger, "url", u), client, n.cfg.Retries, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if n.cfg.BasicAuth != nil {
+			req.SetBasicAuth(n.cfg.BasicAuth.Username, n.cfg.BasicAuth.Password)
+		}
+		return req, nil
+	})
+}
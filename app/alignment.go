@@ -0,0 +1,68 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimestampAlignment decides which point of its interval the timestamp of a
+// reading's sample marks. The providers label the readings with the start of
+// their interval.
+type TimestampAlignment string
+
+const (
+	// TimestampAlignmentStart keeps the start of the interval.
+	TimestampAlignmentStart TimestampAlignment = "start"
+	// TimestampAlignmentEnd moves the timestamp to the end of the interval.
+	// It is the last millisecond of the interval, so the samples of a day stay
+	// within the day's blocks, which the runs resume from.
+	TimestampAlignmentEnd TimestampAlignment = "end"
+	// TimestampAlignmentMiddle moves the timestamp to the middle of the
+	// interval.
+	TimestampAlignmentMiddle TimestampAlignment = "middle"
+)
+
+// ParseTimestampAlignment validates the name of an alignment.
+func ParseTimestampAlignment(s string) (TimestampAlignment, error) {
+	switch t := TimestampAlignment(s); t {
+	case TimestampAlignmentStart, TimestampAlignmentEnd, TimestampAlignmentMiddle:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unknown timestamp alignment '%s', must be one of %s, %s or %s", s, TimestampAlignmentStart, TimestampAlignmentEnd, TimestampAlignmentMiddle)
+	}
+}
+
+// WithTimestampAlignment sets the point of the interval the timestamps of the
+// samples mark, it defaults to TimestampAlignmentStart. Changing it doesn't
+// move the samples already stored.
+func WithTimestampAlignment(t TimestampAlignment) NewOption {
+	return func(a *App) {
+		a.cfg.timestampAlignment = t
+	}
+}
+
+// offset returns the offset of the aligned timestamps from the start
+// of the interval.
+func (t TimestampAlignment) offset(interval time.Duration) time.Duration {
+	switch t {
+	case TimestampAlignmentEnd:
+		return interval - time.Millisecond
+	case TimestampAlignmentMiddle:
+		return interval / 2
+	default:
+		return 0
+	}
+}
+
+// alignReadings moves the timestamps of the readings fetched from the
+// provider to the configured point of their interval.
+func (a *App) alignReadings(readings []Reading) []Reading {
+	offset := a.cfg.timestampAlignment.offset(a.cfg.granularity.Interval())
+	if offset == 0 {
+		return readings
+	}
+	for pos := range readings {
+		readings[pos].Time = readings[pos].Time.Add(offset)
+	}
+	return readings
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/simonswine/thames-water-importer/api/anglianwater"
+)
+
+// AnglianWater configures the login into an Anglian Water account.
+type AnglianWater struct {
+	Email    string
+	Password string
+	// URL of the API, defaults to anglianwater.DefaultURL.
+	URL string
+}
+
+// WithAnglianWater imports the readings from the Anglian Water account,
+// instead of Thames Water.
+func WithAnglianWater(aw AnglianWater) NewOption {
+	return func(a *App) {
+		a.cfg.anglianWater = &aw
+	}
+}
+
+// anglianWaterProvider imports the readings of smart meters from the Anglian
+// Water account. Unlike Thames Water, the login doesn't need a browser.
+type anglianWaterProvider struct {
+	app    *App
+	cfg    AnglianWater
+	client *anglianwater.Client
+}
+
+func (p *anglianWaterProvider) Name() string {
+	return "Anglian Water"
+}
+
+func (p *anglianWaterProvider) Login(ctx context.Context) (string, error) {
+	opts := []anglianwater.Option{
+		anglianwater.WithTransport(&apiRoundTripper{
+			next:     p.app.audit.transport(http.DefaultTransport),
+			duration: p.app.metrics.apiRequestDuration,
+		}),
+	}
+	if p.cfg.URL != "" {
+		opts = append(opts, anglianwater.WithURL(p.cfg.URL))
+	}
+	client := anglianwater.New(opts...)
+
+	_ = level.Info(p.app.logger).Log("msg", "attempting login to anglian water account", "email", p.cfg.Email)
+	resp, err := client.Login(ctx, p.cfg.Email, p.cfg.Password)
+	if err != nil {
+		p.app.metrics.loginAttempts.WithLabelValues("failure").Inc()
+		return "", fmt.Errorf("login failed: %w", err)
+	}
+	p.app.metrics.loginAttempts.WithLabelValues("success").Inc()
+	_ = level.Info(p.app.logger).Log("msg", "successfully logged in", "accountNumber", resp.AccountNumber)
+
+	p.client = client
+	return resp.AccountNumber, nil
+}
+
+func (p *anglianWaterProvider) ListMeters(ctx context.Context) (*MeterList, error) {
+	resp, err := p.client.GetMeters(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &MeterList{}
+	days := make(map[time.Time]struct{})
+	for _, m := range resp.Meters {
+		list.Meters = append(list.Meters, m.MeterSerialNumber)
+
+		first, err := time.Parse("2006-01-02", m.FirstReadDate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid first read date of meter %s: %w", m.MeterSerialNumber, err)
+		}
+		last, err := time.Parse("2006-01-02", m.LastReadDate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid last read date of meter %s: %w", m.MeterSerialNumber, err)
+		}
+		for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+			days[day] = struct{}{}
+		}
+	}
+
+	for day := range days {
+		list.Days = append(list.Days, day)
+	}
+	sort.Slice(list.Days, func(i, j int) bool {
+		return list.Days[i].Before(list.Days[j])
+	})
+	return list, nil
+}
+
+func (p *anglianWaterProvider) GetConsumption(ctx context.Context, meter string, day time.Time) ([]Reading, error) {
+	resp, err := p.client.GetHourlyUsage(ctx, meter, day)
+	if err != nil {
+		return nil, err
+	}
+
+	readings := make([]Reading, len(resp.Readings))
+	for pos, r := range resp.Readings {
+		ts, err := time.Parse("2006-01-02T15:04:05", r.ReadDateTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time of reading: %w", err)
+		}
+		readings[pos] = Reading{
+			Time:      ts,
+			Meter:     meter,
+			Usage:     r.Consumption,
+			Read:      r.Consumption,
+			Estimated: r.IsEstimated,
+		}
+	}
+	return readings, nil
+}
@@ -0,0 +1,172 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// AnomalyDetection configures the comparison of each imported day's hourly
+// consumption with a rolling per-hour-of-day baseline of the previous days.
+type AnomalyDetection struct {
+	// Days is the length of the baseline window.
+	Days int
+	// MinDays is the number of days with data, which the baseline needs
+	// before days are evaluated.
+	MinDays int
+	// Threshold of the day's score, the root mean square of the z-scores of
+	// its hours, above which an alert is raised.
+	Threshold float64
+}
+
+// WithAnomalyDetection raises a UsageAnomaly alert for every imported day,
+// whose consumption profile deviates significantly from the baseline.
+func WithAnomalyDetection(d AnomalyDetection) NewOption {
+	return func(a *App) {
+		a.cfg.anomalyDetection = &d
+	}
+}
+
+// anomalyMinStdDev is the lowest standard deviation used, so hours without
+// any consumption in the baseline don't turn a single liter into an anomaly.
+const anomalyMinStdDev = 5
+
+type anomalyRule struct {
+	cfg    AnomalyDetection
+	query  func(ctx context.Context, from, to time.Time, matchers []*labels.Matcher, f func(lbls labels.Labels, t time.Time, v float64)) error
+	scores *prometheus.GaugeVec
+}
+
+func (r *anomalyRule) name() string {
+	return "anomaly detection"
+}
+
+// hourlyProfile is the consumption per hour of the day.
+type hourlyProfile [24]float64
+
+func (r *anomalyRule) evaluate(ctx context.Context, readings []Reading) ([]Alert, error) {
+	groups := readingsByMeterAndDay(readings)
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	// read the history of all meters, covering the baseline of every day
+	var first, last time.Time
+	for _, days := range groups {
+		for day := range days {
+			if first.IsZero() || day.Before(first) {
+				first = day
+			}
+			if day.After(last) {
+				last = day
+			}
+		}
+	}
+	history := make(map[string]map[time.Time]*hourlyProfile)
+	if err := r.query(ctx, first.AddDate(0, 0, -r.cfg.Days), last, []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, consumptionMetricName),
+	}, func(lbls labels.Labels, t time.Time, v float64) {
+		meter := lbls.Get("meter")
+		days, ok := history[meter]
+		if !ok {
+			days = make(map[time.Time]*hourlyProfile)
+			history[meter] = days
+		}
+		day := truncateDay(t)
+		p, ok := days[day]
+		if !ok {
+			p = &hourlyProfile{}
+			days[day] = p
+		}
+		p[t.Hour()] += v
+	}); err != nil {
+		return nil, fmt.Errorf("error reading baseline: %w", err)
+	}
+
+	var alerts []Alert
+	for meter, days := range groups {
+		sorted := sortedDays(days)
+		newest := sorted[len(sorted)-1]
+		for _, day := range sorted {
+			var (
+				profile hourlyProfile
+				covered [24]bool
+			)
+			// use the value stored in the TSDB, to be comparable with the
+			// baseline
+			for _, reading := range days[day] {
+				profile[reading.Time.Hour()] += reading.Read
+				covered[reading.Time.Hour()] = true
+			}
+
+			var baseline []*hourlyProfile
+			for d := day.AddDate(0, 0, -r.cfg.Days); d.Before(day); d = d.AddDate(0, 0, 1) {
+				if p, ok := history[meter][d]; ok {
+					baseline = append(baseline, p)
+				}
+			}
+			if len(baseline) < r.cfg.MinDays {
+				continue
+			}
+
+			score, worstHour, worstMean := anomalyScore(profile, covered, baseline)
+			if r.scores != nil && day.Equal(newest) {
+				r.scores.WithLabelValues(meter).Set(score)
+			}
+			if score <= r.cfg.Threshold {
+				continue
+			}
+
+			alerts = append(alerts, Alert{
+				Name:  "UsageAnomaly",
+				Meter: meter,
+				Summary: fmt.Sprintf(
+					"The consumption of meter %s on %s deviates from the baseline of %d previous days (score %.1f), most at %02d:00 with %s instead of typically %s.",
+					meter, day.Format("2006-01-02"), len(baseline), score, worstHour, formatLiters(profile[worstHour]), formatLiters(worstMean),
+				),
+				StartsAt: day,
+				EndsAt:   day.AddDate(0, 0, 1),
+			})
+		}
+	}
+	return alerts, nil
+}
+
+// anomalyScore returns the root mean square of the z-scores of the covered
+// hours, together with the hour deviating most and its baseline mean.
+func anomalyScore(profile hourlyProfile, covered [24]bool, baseline []*hourlyProfile) (score float64, worstHour int, worstMean float64) {
+	var (
+		sum   float64
+		hours int
+		worst float64
+	)
+	for h := 0; h < 24; h++ {
+		if !covered[h] {
+			continue
+		}
+		var mean, variance float64
+		for _, p := range baseline {
+			mean += p[h]
+		}
+		mean /= float64(len(baseline))
+		for _, p := range baseline {
+			variance += (p[h] - mean) * (p[h] - mean)
+		}
+		stdDev := math.Max(math.Sqrt(variance/float64(len(baseline))), anomalyMinStdDev)
+
+		z := (profile[h] - mean) / stdDev
+		sum += z * z
+		hours++
+		if math.Abs(z) > worst {
+			worst, worstHour, worstMean = math.Abs(z), h, mean
+		}
+	}
+	if hours == 0 {
+		return 0, 0, 0
+	}
+	return math.Sqrt(sum / float64(hours)), worstHour, worstMean
+}
@@ -4,42 +4,49 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"sort"
-	"strconv"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	retry "github.com/avast/retry-go/v4"
 	"github.com/chromedp/cdproto/emulation"
 	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/chromedp"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
-	"github.com/grafana/dskit/runutil"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/model/labels"
-	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/objstore/client"
-	"github.com/thanos-io/thanos/pkg/shipper"
-
-	"github.com/simonswine/thames-water-importer/api"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
 const (
 	loginURL = "https://myaccount.thameswater.co.uk/login"
+
+	accountInfoMetricName = "water_account_info"
 )
 
+// logLevelOverride logs the informational messages of a dependency, like the
+// TSDB, at the given level. Warnings and errors keep their level, so they are
+// not hidden by the log level filter.
 type logLevelOverride struct {
 	next  log.Logger
 	level interface{}
 }
 
 func (l *logLevelOverride) Log(keyvals ...interface{}) error {
-	for i := 0; i < len(keyvals); i += 2 {
-		if n, ok := keyvals[0].(string); ok && n == "level" {
-			keyvals[i+1] = l.level
+	for i := 0; i < len(keyvals)-1; i += 2 {
+		if keyvals[i] == level.Key() {
+			if keyvals[i+1] != level.WarnValue() && keyvals[i+1] != level.ErrorValue() {
+				keyvals[i+1] = l.level
+			}
 			return l.next.Log(keyvals...)
 		}
 	}
@@ -50,19 +57,107 @@ func (l *logLevelOverride) Log(keyvals ...interface{}) error {
 }
 
 type config struct {
+	provider     Provider
+	anglianWater *AnglianWater
+	plugin       *Plugin
+
 	thamesWaterEmail        string
 	thamesWaterPassword     string
 	thamesWaterLoginTimeout time.Duration
 
-	chromeHeadless bool
-	chromeSandbox  bool
-
-	tsdbPath          string
-	tsdbBlockDuration time.Duration
-
-	externalLabels func() labels.Labels
-
-	thanosBucketObj []byte
+	chromeHeadless     bool
+	chromeSandbox      bool
+	chromeRemoteURL    string
+	loginScreenshotDir string
+
+	tsdbPath                      string
+	tsdbBlockDuration             time.Duration
+	tsdbRetention                 time.Duration
+	tsdbMaxBytes                  int64
+	tsdbStripeSize                int
+	tsdbHeadChunksWriteBufferSize int
+	tsdbWALCompression            bool
+	duplicateSamplePolicy         DuplicateSamplePolicy
+	sampleFilter                  *SampleFilter
+	timestampAlignment            TimestampAlignment
+	granularity                   Granularity
+	intervalGapHandling           IntervalGapHandling
+	memoryBudget                  int64
+	meterStreams                  bool
+
+	externalLabels     func() labels.Labels
+	externalLabelsSet  bool
+	externalLabelsFile string
+	meterLabels        map[string]labels.Labels
+	jobLabel           string
+	sanitizeLabels     bool
+	relabelConfigs     []*relabel.Config
+	relabelConfigFile  string
+
+	accountLabel      bool
+	accountInfoSeries bool
+
+	aggregateSeries  bool
+	billingAnchorDay int
+
+	thanosBucketObj          []byte
+	thanosBucketConfigFile   string
+	thanosBucket             *client.BucketConfig
+	thanosBucketPrefix       string
+	mirrorBucketConfigFiles  []string
+	noUpload                 bool
+	verifyBlocksBeforeUpload bool
+	deleteUploadedAfter      time.Duration
+	deleteVerifiedUploads    bool
+	retryPolicies            map[string]RetryPolicy
+	uploadConcurrency        int
+	uploadCompacted          bool
+	allowOutOfOrderUploads   bool
+	blockSource              metadata.SourceType
+	blockHashFunc            metadata.HashFunc
+
+	sinks                  []Sink
+	webhooks               []Webhook
+	slacks                 []Slack
+	telegrams              []Telegram
+	discords               []Discord
+	ntfys                  []Ntfy
+	emails                 []Email
+	healthcheckPingURL     string
+	sentry                 *Sentry
+	alertmanagers          []Alertmanager
+	leakDetection          *LeakDetection
+	thresholdRules         []ThresholdRule
+	tracing                *Tracing
+	anomalyDetection       *AnomalyDetection
+	tariffs                []Tariff
+	tariffFile             string
+	emissionFactors        []EmissionFactor
+	emissionFactorFile     string
+	notificationConfigFile string
+	remoteWrites           []RemoteWrite
+	remoteWriteConfigFile  string
+	victoriaMetrics        []VictoriaMetrics
+	influxDBs              []InfluxDB
+	postgres               []Postgres
+	sqliteArchive          string
+	clickHouses            []ClickHouse
+	questDBs               []QuestDB
+	bigQueries             []BigQuery
+	timestreams            []Timestream
+	mqtts                  []MQTT
+	homeAssistants         []HomeAssistant
+	natsServers            []NATS
+	graphites              []Graphite
+
+	auditLogPath   string
+	runHistoryPath string
+	// noSystemdNotify stops reporting to systemd, e.g. when embedded
+	noSystemdNotify bool
+
+	metricsTextfile string
+	pushgatewayURL  string
+	pushgatewayJob  string
 }
 
 func defaultConfig() *config {
@@ -70,19 +165,77 @@ func defaultConfig() *config {
 		externalLabels: func() labels.Labels {
 			return labels.FromStrings("cluster", "thames-water-importer")
 		},
+		meterLabels: make(map[string]labels.Labels),
+		jobLabel:    "thames-water-importer",
 
 		chromeSandbox:  true,
 		chromeHeadless: true,
 
-		tsdbPath:          "./tsdb",
-		tsdbBlockDuration: 2 * time.Hour,
+		tsdbPath:                      "./tsdb",
+		tsdbBlockDuration:             2 * time.Hour,
+		tsdbRetention:                 90 * 24 * time.Hour,
+		tsdbStripeSize:                tsdb.DefaultStripeSize,
+		tsdbHeadChunksWriteBufferSize: chunks.DefaultWriteBufferSize,
+		duplicateSamplePolicy:         DuplicateSamplesIgnoreIdentical,
+		timestampAlignment:            TimestampAlignmentStart,
+		granularity:                   GranularityHour,
+		intervalGapHandling:           IntervalGapsWarn,
+
+		billingAnchorDay: 1,
+
+		uploadConcurrency: 1,
+		retryPolicies:     defaultRetryPolicies(),
+
+		uploadCompacted:        true,
+		allowOutOfOrderUploads: true,
+		blockSource:            defaultBlockSource,
+		blockHashFunc:          metadata.SHA256Func,
 	}
 }
 
 type App struct {
-	logger log.Logger
-	reg    *prometheus.Registry
-	cfg    *config
+	logger  log.Logger
+	reg     *prometheus.Registry
+	metrics *metrics
+	cfg     *config
+
+	// sinks of the current run
+	sinks []Sink
+	// stats of the current run
+	stats      *runStats
+	recentLogs *recentLogs
+
+	tracer         trace.Tracer
+	tracerProvider *sdktrace.TracerProvider
+
+	// time the last successful run finished
+	lastSuccessMtx sync.Mutex
+	lastSuccessAt  time.Time
+
+	// recent runs of the daemon
+	runs *runRegistry
+
+	// circuit breakers of the phases, kept across runs
+	circuitsMtx sync.Mutex
+	circuits    map[string]*circuitBreaker
+
+	// requestLimiter throttles the requests to the provider, e.g. during a
+	// backfill
+	requestLimiter *rate.Limiter
+	// backfillHistory imports the days before the oldest block as well and
+	// suspends the retention, while a backfill is running
+	backfillHistory bool
+
+	// whether the replica waits to be elected leader
+	standbyMtx   sync.Mutex
+	standbyState bool
+
+	// configuration resolved by validateConfig
+	resolved *resolvedConfig
+
+	audit *auditLog
+
+	progressFunc ProgressFunc
 }
 
 type NewOption func(*App)
@@ -117,6 +270,14 @@ func WithChromeSandbox(b bool) NewOption {
 	}
 }
 
+// WithChromeRemoteURL logs in with the remote browser behind the DevTools
+// endpoint, e.g. browserless, instead of starting Chrome locally.
+func WithChromeRemoteURL(url string) NewOption {
+	return func(a *App) {
+		a.cfg.chromeRemoteURL = url
+	}
+}
+
 func WithTSDBPath(s string) NewOption {
 	return func(a *App) {
 		a.cfg.tsdbPath = s
@@ -129,11 +290,126 @@ func WithTSDBBlockDuration(d time.Duration) NewOption {
 	}
 }
 
+// WithTSDBRetention deletes the blocks of the local TSDB, once they are older
+// than the duration, zero disables the retention. It defaults to 90 days and
+// only applies, if the blocks are uploaded, so the local TSDB keeps all data
+// without uploads.
+func WithTSDBRetention(d time.Duration) NewOption {
+	return func(a *App) {
+		a.cfg.tsdbRetention = d
+	}
+}
+
+// WithTSDBMaxBytes limits the size of the persisted blocks, zero disables the
+// limit.
+func WithTSDBMaxBytes(n int64) NewOption {
+	return func(a *App) {
+		a.cfg.tsdbMaxBytes = n
+	}
+}
+
+// WithTSDBStripeSize configures the size of the head's series hash map, which
+// needs to be a power of two. Smaller values reduce the memory footprint.
+func WithTSDBStripeSize(n int) NewOption {
+	return func(a *App) {
+		a.cfg.tsdbStripeSize = n
+	}
+}
+
+// WithTSDBHeadChunksWriteBufferSize configures the write buffer of the head
+// chunks mapped to disk.
+func WithTSDBHeadChunksWriteBufferSize(n int) NewOption {
+	return func(a *App) {
+		a.cfg.tsdbHeadChunksWriteBufferSize = n
+	}
+}
+
+func WithTSDBWALCompression(b bool) NewOption {
+	return func(a *App) {
+		a.cfg.tsdbWALCompression = b
+	}
+}
+
 func WithExternalLabels(strs ...string) NewOption {
 	return func(a *App) {
 		a.cfg.externalLabels = func() labels.Labels {
 			return labels.FromStrings(strs...)
 		}
+		a.cfg.externalLabelsSet = true
+	}
+}
+
+// WithExternalLabelsFile loads additional external labels from a YAML or
+// properties file. Labels set by WithExternalLabels take precedence over the
+// file, the file's take precedence over the default labels.
+func WithExternalLabelsFile(path string) NewOption {
+	return func(a *App) {
+		a.cfg.externalLabelsFile = path
+	}
+}
+
+// WithMeterLabels adds extra labels to all series of the meter with the given
+// serial number.
+func WithMeterLabels(meter string, strs ...string) NewOption {
+	return func(a *App) {
+		lbls := labels.NewBuilder(a.cfg.meterLabels[meter])
+		for i := 0; i+1 < len(strs); i += 2 {
+			lbls.Set(strs[i], strs[i+1])
+		}
+		a.cfg.meterLabels[meter] = lbls.Labels()
+	}
+}
+
+// WithSanitizeLabels rewrites invalid external and meter label names instead
+// of rejecting them.
+func WithSanitizeLabels(b bool) NewOption {
+	return func(a *App) {
+		a.cfg.sanitizeLabels = b
+	}
+}
+
+// WithJobLabel configures the value of the job label. An empty value omits the
+// label entirely.
+func WithJobLabel(s string) NewOption {
+	return func(a *App) {
+		a.cfg.jobLabel = s
+	}
+}
+
+// WithMeterStreams imports each meter into its own TSDB below the TSDB path,
+// which is shipped with the meter as additional external label. Without it,
+// only the first meter of the account is imported.
+func WithMeterStreams(b bool) NewOption {
+	return func(a *App) {
+		a.cfg.meterStreams = b
+	}
+}
+
+// WithAccountLabel adds the account number scraped at login as account label
+// to all series.
+func WithAccountLabel(b bool) NewOption {
+	return func(a *App) {
+		a.cfg.accountLabel = b
+	}
+}
+
+// WithAccountInfoSeries emits a separate info series, which maps each meter to
+// the account number scraped at login.
+func WithAccountInfoSeries(b bool) NewOption {
+	return func(a *App) {
+		a.cfg.accountInfoSeries = b
+	}
+}
+
+func WithAggregateSeries(b bool) NewOption {
+	return func(a *App) {
+		a.cfg.aggregateSeries = b
+	}
+}
+
+func WithBillingAnchorDay(d int) NewOption {
+	return func(a *App) {
+		a.cfg.billingAnchorDay = d
 	}
 }
 
@@ -143,83 +419,176 @@ func WithThanosBucketObj(str string) NewOption {
 	}
 }
 
-func New(opts ...NewOption) *App {
-	a := &App{
-		reg:    prometheus.NewRegistry(),
-		logger: log.NewNopLogger(),
-		cfg:    defaultConfig(),
+// WithThanosBucketConfigFile reads the objstore configuration from the file,
+// instead of taking it inline.
+func WithThanosBucketConfigFile(path string) NewOption {
+	return func(a *App) {
+		a.cfg.thanosBucketConfigFile = path
 	}
+}
 
-	for _, o := range opts {
-		o(a)
+// WithMirrorBucketConfigFile uploads the blocks additionally to the bucket
+// configured in the file. The upload state of each mirror is tracked
+// separately, named after the file.
+func WithMirrorBucketConfigFile(path string) NewOption {
+	return func(a *App) {
+		a.cfg.mirrorBucketConfigFiles = append(a.cfg.mirrorBucketConfigFiles, path)
 	}
+}
 
-	return a
+// WithThanosBucketPrefix stores all uploaded objects below the prefix.
+func WithThanosBucketPrefix(prefix string) NewOption {
+	return func(a *App) {
+		a.cfg.thanosBucketPrefix = prefix
+	}
 }
 
-// uploadLocalTSDB uploads the local TSDB blocks generated using a thanos shipper component
-func (a *App) uploadLocalTSDB(ctx context.Context) error {
-	source := metadata.SourceType("importer")
+// WithUploadRetries retries a failed upload of a stream's blocks up to n
+// times. The delay between attempts starts at d and increases exponentially.
+func WithUploadRetries(n int, d time.Duration) NewOption {
+	return func(a *App) {
+		p := a.cfg.retryPolicies[PhaseUpload]
+		p.Retries = n
+		p.Delay = d
+		a.cfg.retryPolicies[PhaseUpload] = p
+	}
+}
 
-	bkt, err := client.NewBucket(a.logger, a.cfg.thanosBucketObj, a.reg, string(source))
-	if err != nil {
-		return err
+// WithUploadConcurrency uploads up to n blocks of a stream concurrently.
+func WithUploadConcurrency(n int) NewOption {
+	return func(a *App) {
+		a.cfg.uploadConcurrency = n
 	}
-	// Ensure we close up everything properly.
-	defer func() {
-		if err != nil {
-			runutil.CloseWithLogOnErr(a.logger, bkt, "bucket client")
-		}
-	}()
-
-	// upload new blocks
-	s := shipper.New(
-		a.logger,
-		a.reg,
-		a.cfg.tsdbPath,
-		bkt,
-		a.cfg.externalLabels,
-		source,
-		true,
-		true,
-		metadata.SHA256Func,
-	)
+}
 
-	n, err := s.Sync(ctx)
-	if err != nil {
-		return err
+// WithUploadCompacted ships blocks with a compaction level above one. Thanos
+// expects only a single source to upload compacted blocks.
+func WithUploadCompacted(b bool) NewOption {
+	return func(a *App) {
+		a.cfg.uploadCompacted = b
 	}
+}
 
-	_ = level.Info(a.logger).Log("msg", fmt.Sprintf("successfully uploaded %d blocks", n))
-	return nil
+// WithAllowOutOfOrderUploads continues to upload newer blocks, when an older
+// block fails to upload or overlaps with blocks in the bucket.
+func WithAllowOutOfOrderUploads(b bool) NewOption {
+	return func(a *App) {
+		a.cfg.allowOutOfOrderUploads = b
+	}
 }
 
-func (a *App) getLoginCookies(ctx context.Context) ([]*http.Cookie, error) {
-	opts := chromedp.DefaultExecAllocatorOptions[:]
+// WithBlockSource configures the source recorded in the Thanos meta of the
+// uploaded blocks.
+func WithBlockSource(s string) NewOption {
+	return func(a *App) {
+		a.cfg.blockSource = metadata.SourceType(s)
+	}
+}
 
-	if !a.cfg.chromeSandbox {
-		opts = append(opts, chromedp.NoSandbox)
+// WithBlockHashFunc configures the hash function used to record the checksums
+// of the uploaded files. metadata.NoneFunc disables the checksums.
+func WithBlockHashFunc(f metadata.HashFunc) NewOption {
+	return func(a *App) {
+		a.cfg.blockHashFunc = f
 	}
+}
 
-	if !a.cfg.chromeHeadless {
-		opts = append(opts, chromedp.Flag("headless", false))
+// WithNoUpload keeps the blocks in the local TSDB, without uploading them to a
+// bucket.
+func WithNoUpload(b bool) NewOption {
+	return func(a *App) {
+		a.cfg.noUpload = b
 	}
+}
 
-	allocCtx, _ := chromedp.NewExecAllocator(ctx, opts...)
+// WithVerifyBlocksBeforeUpload verifies the integrity of all blocks, before
+// they are uploaded.
+func WithVerifyBlocksBeforeUpload(b bool) NewOption {
+	return func(a *App) {
+		a.cfg.verifyBlocksBeforeUpload = b
+	}
+}
+
+// WithDeleteUploadedAfter removes local blocks, once they have been uploaded
+// and the grace period since their creation has passed. Zero disables the
+// deletion.
+func WithDeleteUploadedAfter(d time.Duration) NewOption {
+	return func(a *App) {
+		a.cfg.deleteUploadedAfter = d
+	}
+}
+
+// WithDeleteVerifiedUploads removes local blocks, once they are listed in the
+// bucket and pass the upload verification. If a grace period is configured,
+// it needs to have passed as well.
+func WithDeleteVerifiedUploads(b bool) NewOption {
+	return func(a *App) {
+		a.cfg.deleteVerifiedUploads = b
+	}
+}
+
+// WithMetricsTextfile writes the importer's own metrics to the given path at
+// the end of each run, to be picked up by the node_exporter textfile collector.
+func WithMetricsTextfile(path string) NewOption {
+	return func(a *App) {
+		a.cfg.metricsTextfile = path
+	}
+}
+
+func New(opts ...NewOption) *App {
+	reg := prometheus.NewRegistry()
+	a := &App{
+		reg:     reg,
+		metrics: newMetrics(reg),
+		logger:  log.NewNopLogger(),
+		cfg:     defaultConfig(),
+	}
+
+	for _, o := range opts {
+		o(a)
+	}
+
+	a.tracer = trace.NewNoopTracerProvider().Tracer("")
+	a.recentLogs = newRecentLogs(20)
+	a.runs = newRunRegistry(100)
+	a.logger = teeLogger{Logger: a.logger, recent: a.recentLogs}
+	if a.cfg.auditLogPath != "" {
+		a.audit = &auditLog{path: a.cfg.auditLogPath, logger: a.logger}
+	}
+
+	return a
+}
+
+// getLoginCookies logs into the Thames Water account and returns the session
+// cookies and the account number.
+func (a *App) getLoginCookies(ctx context.Context) ([]*http.Cookie, string, error) {
+	allocCtx, cancelAlloc := a.chromeAllocator(ctx)
+	defer cancelAlloc()
 
 	// create context
 	chromeCtx, cancel := chromedp.NewContext(
 		allocCtx,
 	)
 	defer cancel()
+	a.audit.listenBrowser(chromeCtx)
+
+	// start the browser, before the login can time out, so it is still
+	// around for a screenshot
+	if err := chromedp.Run(chromeCtx); err != nil {
+		return nil, "", err
+	}
+	loginCtx, cancelLogin := context.WithTimeout(chromeCtx, a.cfg.thamesWaterLoginTimeout)
+	defer cancelLogin()
 
 	var accountNumber, accountAddress string
 	var twCookies []*http.Cookie
+	phases := &loginPhases{duration: a.metrics.loginPhaseDuration}
 
 	// login to thames water
 	_ = level.Info(a.logger).Log("msg", "attempting login to thames water account", "email", a.cfg.thamesWaterEmail)
-	if err := chromedp.Run(chromeCtx,
-		loginThamesWater(a.logger, a.cfg.thamesWaterEmail, a.cfg.thamesWaterPassword, &accountNumber, &accountAddress),
+	a.sdStatus("logging in")
+	if err := chromedp.Run(loginCtx,
+		loginThamesWater(a.logger, a.cfg.thamesWaterEmail, a.cfg.thamesWaterPassword, &accountNumber, &accountAddress, phases),
 		chromedp.ActionFunc(func(ctx context.Context) error {
 			cookies, err := network.GetAllCookies().Do(ctx)
 			if err != nil {
@@ -259,164 +628,109 @@ func (a *App) getLoginCookies(ctx context.Context) ([]*http.Cookie, error) {
 			return nil
 		}),
 	); err != nil {
-		return nil, err
+		a.metrics.loginAttempts.WithLabelValues("failure").Inc()
+		a.saveLoginScreenshot(chromeCtx)
+		if a.stats != nil {
+			a.stats.loginPhase = phases.current
+		}
+		return nil, "", fmt.Errorf("login failed while %s: %w", phases.current, err)
 	}
+	phases.set("")
+	a.metrics.loginAttempts.WithLabelValues("success").Inc()
 	_ = level.Info(a.logger).Log("msg", "successfully logged in", "accountNumber", accountNumber, "accountAddress", accountAddress)
 
-	return twCookies, nil
+	return twCookies, strings.TrimSpace(accountNumber), nil
 }
 
-func (a *App) importConsumptionIntoLocalTSDB(ctx context.Context) error {
-	// open tsdb
-	options := tsdb.DefaultOptions()
-	options.RetentionDuration = 90 * 24 * time.Hour.Milliseconds()
+// chromeAllocator returns the allocator of the browser, which either connects
+// to the remote browser or starts Chrome locally.
+func (a *App) chromeAllocator(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.cfg.chromeRemoteURL != "" {
+		return chromedp.NewRemoteAllocator(ctx, a.cfg.chromeRemoteURL)
+	}
 
-	// set retention
-	options.MinBlockDuration = a.cfg.tsdbBlockDuration.Milliseconds()
-	options.MaxBlockDuration = a.cfg.tsdbBlockDuration.Milliseconds()
+	opts := chromedp.DefaultExecAllocatorOptions[:]
 
-	db, err := tsdb.Open(a.cfg.tsdbPath, &logLevelOverride{next: a.logger, level: level.DebugValue()}, a.reg, options, nil)
-	if err != nil {
-		return err
+	if !a.cfg.chromeSandbox {
+		opts = append(opts, chromedp.NoSandbox)
 	}
-	defer db.Close()
 
-	var (
-		minTime, maxTime time.Time
-	)
-	if mT, init := db.Head().AppendableMinValidTime(); init {
-		minTime = timestamp.Time(mT)
-		maxTime = timestamp.Time(db.Head().MaxTime())
-		_ = level.Debug(a.logger).Log("msg", "opened TSDB",
-			"min_time", minTime,
-			"max_time", maxTime,
-		)
+	if !a.cfg.chromeHeadless {
+		opts = append(opts, chromedp.Flag("headless", false))
 	}
 
-	var twCookies []*http.Cookie
-
-	if err := retry.Do(
-		func() error {
-			ctx, cancel := context.WithTimeout(ctx, a.cfg.thamesWaterLoginTimeout)
-			defer cancel()
-
-			var err error
-			twCookies, err = a.getLoginCookies(ctx)
+	return chromedp.NewExecAllocator(ctx, opts...)
+}
 
-			return err
-		},
-		retry.Context(ctx),
-		retry.OnRetry(func(n uint, err error) {
-			_ = a.logger.Log("msg", "login failed", "err", err, "try", n+1)
-		}),
-	); err != nil {
-		return err
+// saveLoginScreenshot saves a screenshot of the page, on which the login
+// failed, if a screenshot directory is configured.
+func (a *App) saveLoginScreenshot(chromeCtx context.Context) {
+	if a.cfg.loginScreenshotDir == "" {
+		return
 	}
 
-	twClient, err := api.New(twCookies)
-	if err != nil {
-		return err
+	ctx, cancel := context.WithTimeout(chromeCtx, 10*time.Second)
+	defer cancel()
+	var buf []byte
+	if err := chromedp.Run(ctx, chromedp.FullScreenshot(&buf, 90)); err != nil {
+		_ = level.Warn(a.logger).Log("msg", "error taking screenshot of failed login", "err", err)
+		return
 	}
 
-	resp, err := twClient.GetMeters(ctx)
-	if err != nil {
-		return err
+	path := filepath.Join(a.cfg.loginScreenshotDir, fmt.Sprintf("login-failure-%s.jpg", time.Now().UTC().Format("20060102T150405Z")))
+	if err := os.MkdirAll(a.cfg.loginScreenshotDir, 0o755); err != nil {
+		_ = level.Warn(a.logger).Log("msg", "error creating screenshot directory", "err", err)
+		return
 	}
-
-	if len(resp.Meters) == 0 {
-		return fmt.Errorf("no meters found")
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		_ = level.Warn(a.logger).Log("msg", "error saving screenshot of failed login", "err", err)
+		return
 	}
+	_ = level.Info(a.logger).Log("msg", "saved screenshot of failed login", "path", path)
+	if a.stats != nil {
+		a.stats.screenshot = path
+	}
+}
 
-	_ = level.Info(a.logger).Log("msg", "found meters", "meters", strings.Join(resp.Meters, ", "))
-
-	meter := resp.Meters[0]
-
-	var readingRequests = make([]api.GetSmartWaterMeterConsumptionsRequest, len(resp.Daily))
-	for pos := range resp.Daily {
-		readingRequests[pos].Meter = meter
-
-		ts, err := time.Parse("02-01-2006", resp.Daily[pos].Value)
-		if err != nil {
-			return err
-		}
-		readingRequests[pos].StartDate = ts
-		readingRequests[pos].EndDate = ts
-	}
-	sort.Slice(readingRequests, func(i, j int) bool {
-		return readingRequests[i].StartDate.Before(readingRequests[j].StartDate)
-	})
-
-	// prepare labels
-	lbls := labels.NewBuilder(a.cfg.externalLabels())
-	lbls.Set("job", "thames-water-importer")
-	lbls.Set(labels.MetricName, "water_consumption_liters")
-
-	for _, reqData := range readingRequests {
-		if !minTime.Before(reqData.StartDate) {
-			_ = level.Debug(a.logger).Log("msg", "skipped daily reading, as TSDB already contains data", "meter", reqData.Meter, "date", reqData.StartDate.Format("2006-01-02"))
-			continue
-		}
-		_ = level.Debug(a.logger).Log("msg", "daily reading", "meter", reqData.Meter, "date", reqData.StartDate.Format("2006-01-02"))
-
-		resp, err := twClient.GetSmartWaterMeterConsumptions(ctx, reqData)
-		if err != nil {
-			return err
-		}
-
-		// get new appender to TSDB
-		a := db.Appender(ctx)
-
-		for pos := range resp.Lines {
-			timeParts := strings.Split(resp.Lines[pos].Label, ":")
-			if len(timeParts) != 2 {
-				return fmt.Errorf("unexpected label split count: %d", len(timeParts))
-			}
-			hours, err := strconv.ParseInt(timeParts[0], 10, 32)
-			if err != nil {
-				return err
-			}
-			minutes, err := strconv.ParseInt(timeParts[1], 10, 32)
-			if err != nil {
-				return err
-			}
+// WithLoginScreenshotDir saves a screenshot into the directory, when the login
+// fails.
+func WithLoginScreenshotDir(dir string) NewOption {
+	return func(a *App) {
+		a.cfg.loginScreenshotDir = dir
+	}
+}
 
-			ts := time.Date(
-				reqData.StartDate.Year(),
-				reqData.StartDate.Month(),
-				reqData.StartDate.Day(),
-				int(hours),
-				int(minutes),
-				0,
-				0,
-				time.UTC,
-			)
-			lbls.Set("meter", resp.Lines[pos].MeterSerialNumberHis)
-			if _, err := a.Append(
-				0,
-				lbls.Labels(),
-				timestamp.FromTime(ts),
-				resp.Lines[pos].Read,
-			); err != nil {
-				return err
-			}
-		}
+// loginPhases keeps track of the login step in progress, to tell where a
+// failed login got stuck, and observes the duration of the completed steps.
+type loginPhases struct {
+	current  string
+	started  time.Time
+	duration *prometheus.HistogramVec
+}
 
-		if err := a.Commit(); err != nil {
-			return err
-		}
+// set completes the current phase and starts the next one. An empty phase
+// completes the login.
+func (p *loginPhases) set(phase string) {
+	now := time.Now()
+	if p.current != "" {
+		p.duration.WithLabelValues(p.current).Observe(now.Sub(p.started).Seconds())
 	}
+	p.current, p.started = phase, now
+}
 
-	if err := db.Compact(); err != nil {
-		return fmt.Errorf("error during compaction: %w", err)
+// loginThamesWater returns the tasks to login. The phases are updated with
+// the step in progress.
+func loginThamesWater(logger log.Logger, email, password string, accountNumber, accountAddress *string, phases *loginPhases) chromedp.Tasks {
+	setPhase := func(p string) chromedp.Action {
+		return chromedp.ActionFunc(func(context.Context) error {
+			phases.set(p)
+			return nil
+		})
 	}
-	_ = level.Debug(a.logger).Log("msg", "ran TSDB compaction")
-
-	return nil
-}
 
-func loginThamesWater(logger log.Logger, email, password string, accountNumber, accountAddress *string) chromedp.Tasks {
 	return chromedp.Tasks{
 		// open url
+		setPhase("opening the login page"),
 		chromedp.Navigate(loginURL),
 
 		chromedp.ActionFunc(func(ctx context.Context) error {
@@ -430,6 +744,7 @@ func loginThamesWater(logger log.Logger, email, password string, accountNumber,
 		}),
 
 		// accept cookie
+		setPhase("accepting the cookie consent"),
 		chromedp.ActionFunc(func(context.Context) error {
 			return level.Debug(logger).Log("msg", "waiting for cookie consent", "url", loginURL)
 		}),
@@ -440,12 +755,14 @@ func loginThamesWater(logger log.Logger, email, password string, accountNumber,
 		chromedp.WaitNotVisible(`button#onetrust-accept-btn-handler`),
 
 		// enter email
+		setPhase("entering the email"),
 		chromedp.ActionFunc(func(context.Context) error {
 			return level.Debug(logger).Log("msg", "enter email", "email", email)
 		}),
 		chromedp.SendKeys(`//input[@type="email" and @id="email"]`, email),
 
 		// enter password
+		setPhase("entering the password"),
 		chromedp.ActionFunc(func(context.Context) error {
 			return level.Debug(logger).Log("msg", "enter password", "password", strings.Repeat("*", len(password)))
 		}),
@@ -453,25 +770,133 @@ func loginThamesWater(logger log.Logger, email, password string, accountNumber,
 		chromedp.Click(`button#next`, chromedp.NodeVisible),
 
 		// wait for account details to be shown (otherwise cookie is not authorized)
+		setPhase("waiting for the account details"),
 		chromedp.ActionFunc(func(context.Context) error {
 			return level.Debug(logger).Log("msg", "wait for account details to be shown")
 		}),
 		chromedp.WaitReady(`div.details-panel`),
 
 		// extract account number / address
+		setPhase("extracting the account details"),
 		chromedp.Text(`div.details-panel span.detail-value.txt-actnumber`, accountNumber),
 		chromedp.Text(`div.details-panel span.detail-value.txt-adr`, accountAddress),
 	}
 }
 
 func (a *App) Run(ctx context.Context) error {
-	if err := a.importConsumptionIntoLocalTSDB(ctx); err != nil {
+	_, err := a.runWithSummary(ctx)
+	return err
+}
+
+// runWithSummary runs like Run and returns the summary of the run. The
+// summary is empty, if the run didn't start, e.g. as another run holds the
+// lock.
+func (a *App) runWithSummary(ctx context.Context) (RunSummary, error) {
+	l, err := a.lock()
+	if err != nil {
+		return RunSummary{}, err
+	}
+	defer l.Release()
+
+	id, trigger := a.runFromContext(ctx)
+	start := time.Now()
+	// the configuration only changes between runs
+	if err := a.validateConfig(); err != nil {
+		a.recordRun(ctx, id, trigger, newRunStats().summary(start, err))
+		return RunSummary{}, err
+	}
+
+	if err := a.initTracing(ctx); err != nil {
+		a.recordRun(ctx, id, trigger, newRunStats().summary(start, err))
+		return RunSummary{}, err
+	}
+
+	a.pingHealthcheck(ctx, "/start", "")
+	a.stats = newRunStats()
+	a.reportProgress(Progress{Type: ProgressRunStarted})
+	a.recentLogs.reset()
+	runCtx, span := a.startSpan(ctx, "run")
+	err = a.run(runCtx)
+	endSpan(span, err)
+	summary := a.stats.summary(start, err)
+	if err != nil {
+		summary.LastLogLines = a.recentLogs.get()
+	}
+	summary.Alerts = a.evaluateAlerts(ctx, a.stats.readings)
+	for _, alert := range summary.Alerts {
+		_ = level.Warn(a.logger).Log("msg", "alert raised", "alert", alert.Name, "meter", alert.Meter, "summary", alert.Summary)
+	}
+	a.metrics.observeRun(summary)
+	a.recordRun(ctx, id, trigger, summary)
+	a.reportProgress(Progress{Type: ProgressRunFinished, Summary: &summary})
+	if err == nil {
+		a.setLastSuccess(time.Now())
+	}
+	a.notify(ctx, summary)
+	a.pingHealthcheckResult(ctx, summary)
+	a.reportError(err, summary)
+
+	if err := a.writeMetricsTextfile(); err != nil {
+		_ = level.Warn(a.logger).Log("msg", "error writing metrics textfile", "err", err)
+	}
+	if err := a.pushMetrics(); err != nil {
+		_ = level.Warn(a.logger).Log("msg", "error pushing metrics to Pushgateway", "err", err)
+	}
+	if err := a.flushTracing(ctx); err != nil {
+		_ = level.Warn(a.logger).Log("msg", "error exporting spans", "err", err)
+	}
+
+	return summary, err
+}
+
+func (a *App) setLastSuccess(t time.Time) {
+	a.lastSuccessMtx.Lock()
+	defer a.lastSuccessMtx.Unlock()
+	a.lastSuccessAt = t
+}
+
+func (a *App) lastSuccess() time.Time {
+	a.lastSuccessMtx.Lock()
+	defer a.lastSuccessMtx.Unlock()
+	return a.lastSuccessAt
+}
+
+// run imports the new readings into all sinks.
+func (a *App) run(ctx context.Context) error {
+	importErr := a.importConsumption(ctx)
+	// update the freshness even after a failed import, so stalls can be alerted on
+	if err := a.updateLatestSampleTimestamps(ctx); err != nil {
+		_ = level.Warn(a.logger).Log("msg", "error determining latest samples", "err", err)
+	}
+	return importErr
+}
+
+// Upload ships the blocks of the existing local TSDB, without importing new
+// data first.
+func (a *App) Upload(ctx context.Context) error {
+	if err := a.validateConfig(); err != nil {
+		return err
+	}
+
+	l, err := a.lock()
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	return a.upload(ctx)
+}
+
+func (a *App) upload(ctx context.Context) error {
+	dests, err := a.newDestinations()
+	if err != nil {
 		return err
 	}
+	defer a.closeDestinations(dests)
 
-	if err := a.uploadLocalTSDB(ctx); err != nil {
+	if err := a.uploadLocalTSDB(ctx, dests); err != nil {
 		return err
 	}
 
-	return nil
+	return a.deleteUploadedBlocks(ctx, dests)
 }
@@ -0,0 +1,246 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+const (
+	auditSourceAPI     = "api"
+	auditSourceBrowser = "browser"
+)
+
+// WithAuditLog appends an entry per HTTP request to Thames Water to the file,
+// as JSON lines. This covers the requests of the API client and those of the
+// browser during the login, including the pages' third party requests.
+// Secrets in the URLs are redacted, headers and bodies are not logged.
+func WithAuditLog(path string) NewOption {
+	return func(a *App) {
+		a.cfg.auditLogPath = path
+	}
+}
+
+// auditEntry is a line of the audit log.
+type auditEntry struct {
+	Time            time.Time `json:"time"`
+	Source          string    `json:"source"`
+	Method          string    `json:"method"`
+	URL             string    `json:"url"`
+	Status          int       `json:"status,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	RequestBytes    int64     `json:"request_bytes"`
+	ResponseBytes   int64     `json:"response_bytes"`
+}
+
+// auditLog appends the entries to the file at path. A nil auditLog discards
+// them.
+type auditLog struct {
+	path   string
+	logger log.Logger
+
+	mtx sync.Mutex
+}
+
+func (l *auditLog) write(e auditEntry) {
+	if l == nil {
+		return
+	}
+	e.URL = redactURL(e.URL)
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err == nil {
+		enc := json.NewEncoder(f)
+		enc.SetEscapeHTML(false)
+		err = enc.Encode(e)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		_ = level.Warn(l.logger).Log("msg", "error writing audit log", "path", l.path, "err", err)
+	}
+}
+
+// redactedQueryKeys are the substrings of query parameters, which carry
+// secrets, like the tokens of the OAuth flow of the login.
+var redactedQueryKeys = []string{"auth", "code", "key", "nonce", "password", "secret", "session", "sig", "state", "token"}
+
+// redactURL removes the user info and fragment and redacts the values of
+// query parameters, which might carry secrets.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "REDACTED"
+	}
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	values := u.Query()
+	for key := range values {
+		lower := strings.ToLower(key)
+		for _, k := range redactedQueryKeys {
+			if strings.Contains(lower, k) {
+				values[key] = []string{"REDACTED"}
+				break
+			}
+		}
+	}
+	if len(values) > 0 {
+		u.RawQuery = values.Encode()
+	}
+	return u.String()
+}
+
+// transport wraps the transport, to audit its requests.
+func (l *auditLog) transport(next http.RoundTripper) http.RoundTripper {
+	if l == nil {
+		return next
+	}
+	return &auditRoundTripper{next: next, log: l}
+}
+
+type auditRoundTripper struct {
+	next http.RoundTripper
+	log  *auditLog
+}
+
+func (rt *auditRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	e := auditEntry{
+		Time:   time.Now().UTC(),
+		Source: auditSourceAPI,
+		Method: req.Method,
+		URL:    req.URL.String(),
+	}
+	if req.ContentLength > 0 {
+		e.RequestBytes = req.ContentLength
+	}
+
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil {
+		e.Error = err.Error()
+		e.DurationSeconds = time.Since(e.Time).Seconds()
+		rt.log.write(e)
+		return nil, err
+	}
+
+	// the entry is written once the body has been read, to count its bytes
+	e.Status = resp.StatusCode
+	resp.Body = &auditBody{ReadCloser: resp.Body, entry: e, log: rt.log}
+	return resp, nil
+}
+
+// auditBody counts the bytes read and writes the entry on EOF or close.
+type auditBody struct {
+	io.ReadCloser
+	entry auditEntry
+	log   *auditLog
+	once  sync.Once
+}
+
+func (b *auditBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.entry.ResponseBytes += int64(n)
+	if err != nil {
+		if err != io.EOF {
+			b.entry.Error = err.Error()
+		}
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *auditBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *auditBody) finish() {
+	b.once.Do(func() {
+		b.entry.DurationSeconds = time.Since(b.entry.Time).Seconds()
+		b.log.write(b.entry)
+	})
+}
+
+// listenBrowser audits the requests of the browser, from the network events
+// of the target in the context.
+func (l *auditLog) listenBrowser(ctx context.Context) {
+	if l == nil {
+		return
+	}
+
+	pending := make(map[network.RequestID]*auditEntry)
+	finish := func(id network.RequestID, f func(*auditEntry)) {
+		e, ok := pending[id]
+		if !ok {
+			return
+		}
+		delete(pending, id)
+		f(e)
+		e.DurationSeconds = time.Since(e.Time).Seconds()
+		l.write(*e)
+	}
+
+	// events are handled one at a time, so pending needs no lock
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			// redirects reuse the ID of the request
+			if ev.RedirectResponse != nil {
+				finish(ev.RequestID, func(e *auditEntry) {
+					e.Status = int(ev.RedirectResponse.Status)
+					e.ResponseBytes = int64(ev.RedirectResponse.EncodedDataLength)
+				})
+			}
+			e := &auditEntry{
+				Time:   time.Now().UTC(),
+				Source: auditSourceBrowser,
+				Method: ev.Request.Method,
+				URL:    ev.Request.URL,
+			}
+			// the entries are base64 encoded, the post data might be
+			// omitted, if too long
+			for _, entry := range ev.Request.PostDataEntries {
+				if b, err := base64.StdEncoding.DecodeString(entry.Bytes); err == nil {
+					e.RequestBytes += int64(len(b))
+				}
+			}
+			if e.RequestBytes == 0 {
+				e.RequestBytes = int64(len(ev.Request.PostData))
+			}
+			pending[ev.RequestID] = e
+		case *network.EventResponseReceived:
+			if e, ok := pending[ev.RequestID]; ok {
+				e.Status = int(ev.Response.Status)
+			}
+		case *network.EventLoadingFinished:
+			finish(ev.RequestID, func(e *auditEntry) {
+				e.ResponseBytes = int64(ev.EncodedDataLength)
+			})
+		case *network.EventLoadingFailed:
+			finish(ev.RequestID, func(e *auditEntry) {
+				e.Error = ev.ErrorText
+				if ev.Canceled {
+					e.Error = "canceled"
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,233 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"golang.org/x/time/rate"
+)
+
+// DefaultBackfillCheckpointDays is the number of days imported by a run of a
+// backfill, unless configured otherwise.
+const DefaultBackfillCheckpointDays = 7
+
+// BackfillConfig configures a backfill.
+type BackfillConfig struct {
+	// Start and End limit the backfill to the days in between, both
+	// inclusive. The zero time backfills all available days.
+	Start time.Time
+	End   time.Time
+	// RequestsPerSecond throttles the requests to the provider, 0 doesn't.
+	RequestsPerSecond float64
+	// CheckpointDays is the number of days imported by a single run, whose
+	// blocks are committed to the local TSDB, before the next days are
+	// fetched.
+	CheckpointDays int
+}
+
+// waitRequest blocks until the request rate allows another request to the
+// provider.
+func (a *App) waitRequest(ctx context.Context) error {
+	if a.requestLimiter == nil {
+		return nil
+	}
+	return a.requestLimiter.Wait(ctx)
+}
+
+// Backfill imports the available history, at the configured request rate,
+// which can take days for a long history. The days before the oldest block
+// of the local TSDB are imported into blocks of their own, newest first, so
+// the imported history stays contiguous with the stored data. The missing
+// days after the newest block follow, oldest first. The days are imported by
+// a run per checkpoint, so an interrupted backfill only loses the days of its
+// current run and resumes with the days not stored yet, once it is started
+// again. Days, which become available while the backfill is running, are
+// imported as well.
+//
+// The retention is suspended during the backfill, so the history is kept,
+// until it has been uploaded. Once the local blocks are deleted by the
+// retention, another backfill fetches their days again.
+func (a *App) Backfill(ctx context.Context, cfg BackfillConfig) error {
+	if cfg.CheckpointDays <= 0 {
+		cfg.CheckpointDays = DefaultBackfillCheckpointDays
+	}
+	if cfg.RequestsPerSecond > 0 {
+		a.requestLimiter = rate.NewLimiter(rate.Limit(cfg.RequestsPerSecond), 1)
+		defer func() { a.requestLimiter = nil }()
+	}
+	a.backfillHistory = true
+	defer func() { a.backfillHistory = false }()
+	r := DayRange{Start: cfg.Start, End: cfg.End}
+
+	var (
+		last        time.Time
+		daysDone    int
+		daysLeft    int
+		historyDone bool
+	)
+	checkpoint := func(days []time.Time) error {
+		checkpoint := DayRange{Start: days[0], End: days[len(days)-1]}
+		if err := a.Run(withDayRange(ctx, &checkpoint)); err != nil {
+			return fmt.Errorf("error backfilling %s to %s, start the backfill again to resume: %w",
+				checkpoint.Start.Format("2006-01-02"), checkpoint.End.Format("2006-01-02"), err)
+		}
+		daysDone += len(days)
+		daysLeft -= len(days)
+		_ = level.Info(a.logger).Log("msg", "backfill checkpoint committed",
+			"from", checkpoint.Start.Format("2006-01-02"),
+			"to", checkpoint.End.Format("2006-01-02"),
+			"days_done", daysDone,
+			"days_left", daysLeft,
+		)
+		return nil
+	}
+
+	for {
+		plan, err := a.Plan(withDayRange(ctx, &r))
+		if err != nil {
+			return fmt.Errorf("error planning backfill: %w", err)
+		}
+
+		history, missing := backfillDays(plan, last)
+		// days without readings don't extend the history, so it is only
+		// backfilled once
+		if historyDone {
+			history = nil
+		}
+		historyDone = true
+		daysLeft = len(history) + len(missing)
+		if daysLeft == 0 {
+			_ = level.Info(a.logger).Log("msg", "backfill complete", "days", daysDone)
+			return nil
+		}
+
+		msg := []interface{}{"msg", "backfilling days", "history_days", len(history), "missing_days", len(missing)}
+		if cfg.RequestsPerSecond > 0 {
+			requests := float64(daysLeft * len(plan.Meters))
+			msg = append(msg, "estimated_duration", time.Duration(requests/cfg.RequestsPerSecond*float64(time.Second)).Round(time.Minute))
+		}
+		_ = level.Info(a.logger).Log(msg...)
+
+		for end := len(history); end > 0; end -= cfg.CheckpointDays {
+			start := end - cfg.CheckpointDays
+			if start < 0 {
+				start = 0
+			}
+			if err := checkpoint(history[start:end]); err != nil {
+				return err
+			}
+		}
+		for start := 0; start < len(missing); start += cfg.CheckpointDays {
+			end := start + cfg.CheckpointDays
+			if end > len(missing) {
+				end = len(missing)
+			}
+			if err := checkpoint(missing[start:end]); err != nil {
+				return err
+			}
+			last = missing[end-1]
+		}
+	}
+}
+
+// backfillDays returns the days of the plan before the oldest blocks and the
+// missing days after the day, both oldest first. Days, which are history of
+// any stream, are imported with the history.
+func backfillDays(plan *Plan, after time.Time) (history, missing []time.Time) {
+	historySeen := make(map[time.Time]struct{})
+	for _, d := range plan.Days {
+		if d.Reason != PlanReasonHistory {
+			continue
+		}
+		if _, ok := historySeen[d.Day]; ok {
+			continue
+		}
+		historySeen[d.Day] = struct{}{}
+		history = append(history, d.Day)
+	}
+
+	missingSeen := make(map[time.Time]struct{})
+	for _, d := range plan.Days {
+		if !d.Fetch || d.Reason == PlanReasonHistory || !d.Day.After(after) {
+			continue
+		}
+		if _, ok := historySeen[d.Day]; ok {
+			continue
+		}
+		if _, ok := missingSeen[d.Day]; ok {
+			continue
+		}
+		missingSeen[d.Day] = struct{}{}
+		missing = append(missing, d.Day)
+	}
+
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Before(history[j])
+	})
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i].Before(missing[j])
+	})
+	return history, missing
+}
+
+// historyDirname is the directory inside a workspace, in which a backfill
+// builds the blocks of the days before the oldest block.
+const historyDirname = ".history"
+
+// historyDays returns the days ending before the oldest block of the stream.
+func historyDays(s stream, days []time.Time) ([]time.Time, error) {
+	oldest, err := storedOldestTime(s)
+	if err != nil {
+		return nil, err
+	}
+	var history []time.Time
+	for _, day := range days {
+		if beforeOldest(day, oldest) {
+			history = append(history, day)
+		}
+	}
+	return history, nil
+}
+
+// importHistory imports the days before the oldest block of the stream into
+// a TSDB of their own, as the stream's TSDB only appends after its blocks, and
+// moves the resulting blocks next to the stream's blocks.
+func (a *App) importHistory(ctx context.Context, provider Provider, s stream, days []time.Time, accountNumber string) error {
+	hs := s
+	hs.path = filepath.Join(s.path, historyDirname)
+	// remove leftovers of a failed run
+	if err := os.RemoveAll(hs.path); err != nil {
+		return err
+	}
+	defer os.RemoveAll(hs.path)
+
+	_ = level.Info(a.logger).Log("msg", "importing days before the oldest block", "path", s.path, "from", days[0].Format("2006-01-02"), "to", days[len(days)-1].Format("2006-01-02"))
+	db, err := a.openTSDB(hs)
+	if err != nil {
+		return err
+	}
+	if err := a.importDays(ctx, provider, db, hs, days, time.Time{}, accountNumber); err != nil {
+		_ = db.Close()
+		return err
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	metas, err := listBlocks(hs.path)
+	if err != nil {
+		return err
+	}
+	for _, m := range metas {
+		id := m.ULID.String()
+		if err := os.Rename(filepath.Join(hs.path, id), filepath.Join(s.path, id)); err != nil {
+			return fmt.Errorf("error moving block %s of the history: %w", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	bigquery "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// BigQuery configures a BigQuery table, which receives the imported readings
+// through streaming inserts. The table is created if missing, partitioned by
+// day. Credentials are found through the Application Default Credentials.
+type BigQuery struct {
+	Project string
+	Dataset string
+	Table   string
+	// BatchSize is the maximum number of rows inserted per request.
+	BatchSize int
+}
+
+// WithBigQuery inserts the imported readings into BigQuery.
+func WithBigQuery(bq BigQuery) NewOption {
+	return func(a *App) {
+		a.cfg.bigQueries = append(a.cfg.bigQueries, bq)
+	}
+}
+
+var bigQuerySchema = &bigquery.TableSchema{
+	Fields: []*bigquery.TableFieldSchema{
+		{Name: "time", Type: "TIMESTAMP", Mode: "REQUIRED"},
+		{Name: "meter", Type: "STRING", Mode: "REQUIRED"},
+		{Name: "usage", Type: "FLOAT", Mode: "NULLABLE", Description: "Consumption within the interval in liters"},
+		{Name: "read", Type: "FLOAT", Mode: "REQUIRED", Description: "Meter reading in liters"},
+		{Name: "estimated", Type: "BOOLEAN", Mode: "REQUIRED"},
+	},
+}
+
+type bigQuerySink struct {
+	logger log.Logger
+	cfg    BigQuery
+
+	rows []*bigquery.TableDataInsertAllRequestRows
+}
+
+func newBigQuerySink(logger log.Logger, cfg BigQuery) *bigQuerySink {
+	if cfg.Table == "" {
+		cfg.Table = "water_readings"
+	}
+	// streaming inserts are limited to 50,000 rows per request
+	if cfg.BatchSize <= 0 || cfg.BatchSize > 50000 {
+		cfg.BatchSize = 10000
+	}
+	return &bigQuerySink{
+		logger: logger,
+		cfg:    cfg,
+	}
+}
+
+func (s *bigQuerySink) Name() string {
+	return fmt.Sprintf("BigQuery table %s.%s.%s", s.cfg.Project, s.cfg.Dataset, s.cfg.Table)
+}
+
+func (s *bigQuerySink) Close() error {
+	return nil
+}
+
+// AppendSample ignores the samples, the readings are received by
+// AppendReadings.
+func (s *bigQuerySink) AppendSample(labels.Labels, int64, float64) {}
+
+func (s *bigQuerySink) AppendReadings(readings []Reading) {
+	for _, r := range readings {
+		row := map[string]bigquery.JsonValue{
+			"time":      r.Time.UTC().Format(time.RFC3339),
+			"meter":     r.Meter,
+			"read":      r.Read,
+			"estimated": r.Estimated,
+		}
+		if !math.IsNaN(r.Usage) {
+			row["usage"] = r.Usage
+		}
+		s.rows = append(s.rows, &bigquery.TableDataInsertAllRequestRows{
+			// lets BigQuery drop rows, which are imported again
+			InsertId: r.Meter + "/" + r.Time.UTC().Format(time.RFC3339),
+			Json:     row,
+		})
+	}
+}
+
+// ensureTable creates the table, if it doesn't exist yet.
+func (s *bigQuerySink) ensureTable(ctx context.Context, svc *bigquery.Service) error {
+	_, err := svc.Tables.Get(s.cfg.Project, s.cfg.Dataset, s.cfg.Table).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+		return err
+	}
+
+	_, err = svc.Tables.Insert(s.cfg.Project, s.cfg.Dataset, &bigquery.Table{
+		TableReference: &bigquery.TableReference{
+			ProjectId: s.cfg.Project,
+			DatasetId: s.cfg.Dataset,
+			TableId:   s.cfg.Table,
+		},
+		Schema: bigQuerySchema,
+		TimePartitioning: &bigquery.TimePartitioning{
+			Type:  "DAY",
+			Field: "time",
+		},
+	}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error creating table: %w", err)
+	}
+	_ = level.Info(s.logger).Log("msg", "created BigQuery table", "table", s.Name())
+	return nil
+}
+
+func (s *bigQuerySink) Flush(ctx context.Context) error {
+	if len(s.rows) == 0 {
+		return nil
+	}
+
+	svc, err := bigquery.NewService(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.ensureTable(ctx, svc); err != nil {
+		return err
+	}
+
+	for pos := 0; pos < len(s.rows); pos += s.cfg.BatchSize {
+		end := pos + s.cfg.BatchSize
+		if end > len(s.rows) {
+			end = len(s.rows)
+		}
+
+		resp, err := svc.Tabledata.InsertAll(s.cfg.Project, s.cfg.Dataset, s.cfg.Table, &bigquery.TableDataInsertAllRequest{
+			Rows: s.rows[pos:end],
+		}).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("error inserting rows: %w", err)
+		}
+		if len(resp.InsertErrors) > 0 {
+			first := resp.InsertErrors[0]
+			var msg string
+			if len(first.Errors) > 0 {
+				msg = first.Errors[0].Message
+			}
+			return fmt.Errorf("error inserting %d rows, first at row %d: %s", len(resp.InsertErrors), int64(pos)+first.Index, msg)
+		}
+	}
+
+	_ = level.Info(s.logger).Log("msg", "inserted readings into BigQuery", "table", s.Name(), "readings", len(s.rows))
+	s.rows = nil
+	return nil
+}
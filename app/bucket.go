@@ -0,0 +1,131 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/objstore/client"
+	"gopkg.in/yaml.v2"
+)
+
+// S3Bucket configures an S3 compatible bucket without writing an objstore
+// configuration. Empty credentials fall back to the AWS credential chain.
+type S3Bucket struct {
+	Bucket    string
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Insecure  bool
+}
+
+// GCSBucket configures a Google Cloud Storage bucket, which uses the
+// application default credentials.
+type GCSBucket struct {
+	Bucket string
+}
+
+// AzureContainer configures an Azure Blob Storage container.
+type AzureContainer struct {
+	StorageAccount    string
+	StorageAccountKey string
+	Container         string
+}
+
+// WithS3Bucket generates the objstore configuration for an S3 bucket.
+func WithS3Bucket(b S3Bucket) NewOption {
+	return func(a *App) {
+		a.cfg.thanosBucket = &client.BucketConfig{
+			Type: client.S3,
+			Config: map[string]interface{}{
+				"bucket":     b.Bucket,
+				"endpoint":   b.Endpoint,
+				"region":     b.Region,
+				"access_key": b.AccessKey,
+				"secret_key": b.SecretKey,
+				"insecure":   b.Insecure,
+			},
+		}
+	}
+}
+
+// WithGCSBucket generates the objstore configuration for a GCS bucket.
+func WithGCSBucket(b GCSBucket) NewOption {
+	return func(a *App) {
+		a.cfg.thanosBucket = &client.BucketConfig{
+			Type: client.GCS,
+			Config: map[string]interface{}{
+				"bucket": b.Bucket,
+			},
+		}
+	}
+}
+
+// WithAzureContainer generates the objstore configuration for an Azure
+// container.
+func WithAzureContainer(c AzureContainer) NewOption {
+	return func(a *App) {
+		a.cfg.thanosBucket = &client.BucketConfig{
+			Type: client.AZURE,
+			Config: map[string]interface{}{
+				"storage_account":     c.StorageAccount,
+				"storage_account_key": c.StorageAccountKey,
+				"container":           c.Container,
+			},
+		}
+	}
+}
+
+// defaultBlockSource is recorded as source in the meta of the uploaded
+// blocks, unless configured otherwise.
+const defaultBlockSource = metadata.SourceType("importer")
+
+// newBucket creates the client of a bucket. Its metrics are distinguished by
+// the destination label.
+func (a *App) newBucket(d destination, bktConfig []byte) (objstore.Bucket, error) {
+	reg := reregisterer{prometheus.WrapRegistererWith(prometheus.Labels{"destination": d.String()}, a.reg)}
+	bkt, err := client.NewBucket(a.logger, bktConfig, reg, string(defaultBlockSource))
+	if err != nil {
+		return nil, err
+	}
+	return newPrefixedBucket(bkt, a.cfg.thanosBucketPrefix), nil
+}
+
+// bucketBlocks lists the blocks present in the bucket.
+func bucketBlocks(ctx context.Context, bkt objstore.Bucket) (map[ulid.ULID]struct{}, error) {
+	blocks := make(map[ulid.ULID]struct{})
+	if err := bkt.Iter(ctx, "", func(name string) error {
+		if id, ok := block.IsBlockDir(name); ok {
+			blocks[id] = struct{}{}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// bucketConfig returns the objstore configuration of the Thanos bucket. The
+// config file is read on every call, so changes are picked up without a
+// restart.
+func (a *App) bucketConfig() ([]byte, error) {
+	if a.cfg.thanosBucket != nil {
+		return yaml.Marshal(a.cfg.thanosBucket)
+	}
+
+	if a.cfg.thanosBucketConfigFile == "" {
+		return a.cfg.thanosBucketObj, nil
+	}
+
+	data, err := os.ReadFile(a.cfg.thanosBucketConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bucket config file: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"gopkg.in/yaml.v2"
+)
+
+const carbonMetricName = "water_carbon_kg_co2e"
+
+// EmissionFactor is the carbon footprint of the water from its effective date
+// until the next factor takes effect.
+type EmissionFactor struct {
+	// From is the date the factor takes effect, in the form 2006-01-02. An
+	// empty date applies the factor to all readings before the next factor.
+	From string `yaml:"from"`
+	// KgCO2ePerM3 is the emitted kg CO2e per m³ of water, covering supply
+	// and wastewater treatment.
+	KgCO2ePerM3 float64 `yaml:"kg_co2e_per_m3"`
+
+	from time.Time
+}
+
+// WithEmissionFactor computes the water_carbon_kg_co2e series using the
+// factor.
+func WithEmissionFactor(f EmissionFactor) NewOption {
+	return func(a *App) {
+		a.cfg.emissionFactors = append(a.cfg.emissionFactors, f)
+	}
+}
+
+// WithEmissionFactorFile reads a YAML list of emission factors from the file,
+// which is read again on every run.
+func WithEmissionFactorFile(path string) NewOption {
+	return func(a *App) {
+		a.cfg.emissionFactorFile = path
+	}
+}
+
+func loadEmissionFactorFile(path string) ([]EmissionFactor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var factors []EmissionFactor
+	if err := yaml.UnmarshalStrict(data, &factors); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return factors, nil
+}
+
+// emissionSchedule are the emission factors sorted by their effective date.
+type emissionSchedule []EmissionFactor
+
+func newEmissionSchedule(factors []EmissionFactor) (emissionSchedule, error) {
+	s := make(emissionSchedule, len(factors))
+	for i, f := range factors {
+		if f.From != "" {
+			from, err := time.Parse("2006-01-02", f.From)
+			if err != nil {
+				return nil, fmt.Errorf("invalid effective date of emission factor %d: %w", i, err)
+			}
+			f.from = from
+		}
+		if f.KgCO2ePerM3 < 0 {
+			return nil, fmt.Errorf("invalid emission factor %g, must not be negative", f.KgCO2ePerM3)
+		}
+		s[i] = f
+	}
+	sort.SliceStable(s, func(i, j int) bool {
+		return s[i].from.Before(s[j].from)
+	})
+	for i := 1; i < len(s); i++ {
+		if s[i].from.Equal(s[i-1].from) {
+			return nil, fmt.Errorf("multiple emission factors take effect on %s", s[i].from.Format("2006-01-02"))
+		}
+	}
+	return s, nil
+}
+
+// at returns the emission factor in effect at t, nil if there is none.
+func (s emissionSchedule) at(t time.Time) *EmissionFactor {
+	i := sort.Search(len(s), func(i int) bool {
+		return s[i].from.After(t)
+	})
+	if i == 0 {
+		return nil
+	}
+	return &s[i-1]
+}
+
+// appendEmissions appends the water_carbon_kg_co2e sample for the reading.
+func (s emissionSchedule) appendEmissions(app storage.Appender, lbls labels.Labels, r Reading) error {
+	f := s.at(r.Time)
+	if f == nil {
+		return nil
+	}
+
+	b := labels.NewBuilder(lbls)
+	b.Set(labels.MetricName, carbonMetricName)
+	_, err := app.Append(0, b.Labels(), timestamp.FromTime(r.Time), r.Read/1000*f.KgCO2ePerM3)
+	return err
+}
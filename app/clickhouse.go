@@ -0,0 +1,169 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// ClickHouse configures a ClickHouse table, which receives the imported
+// readings through the HTTP interface. The table is created if missing, using
+// the ReplacingMergeTree engine, so readings imported again replace the
+// existing rows eventually.
+type ClickHouse struct {
+	// URL of the HTTP interface, e.g. http://clickhouse:8123.
+	URL      string
+	Database string
+	Table    string
+	Username string
+	Password string
+	// Timeout of a single request.
+	Timeout time.Duration
+	// BatchSize is the maximum number of rows inserted per request.
+	BatchSize int
+	// Retries of a failed request.
+	Retries int
+}
+
+// WithClickHouse inserts the imported readings into ClickHouse.
+func WithClickHouse(ch ClickHouse) NewOption {
+	return func(a *App) {
+		a.cfg.clickHouses = append(a.cfg.clickHouses, ch)
+	}
+}
+
+// clickHouseRow is a single row in the JSONEachRow format.
+type clickHouseRow struct {
+	Time      string   `json:"time"`
+	Meter     string   `json:"meter"`
+	Usage     *float64 `json:"usage"`
+	Read      float64  `json:"read"`
+	Estimated uint8    `json:"estimated"`
+}
+
+type clickHouseSink struct {
+	logger log.Logger
+	cfg    ClickHouse
+	client *http.Client
+
+	rows []clickHouseRow
+}
+
+func newClickHouseSink(logger log.Logger, cfg ClickHouse) *clickHouseSink {
+	if cfg.Database == "" {
+		cfg.Database = "default"
+	}
+	if cfg.Table == "" {
+		cfg.Table = "water_readings"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 10000
+	}
+	return &clickHouseSink{
+		logger: logger,
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+func (s *clickHouseSink) Name() string {
+	return "ClickHouse " + s.cfg.URL
+}
+
+func (s *clickHouseSink) Close() error {
+	return nil
+}
+
+// AppendSample ignores the samples, the readings are received by
+// AppendReadings.
+func (s *clickHouseSink) AppendSample(labels.Labels, int64, float64) {}
+
+func (s *clickHouseSink) AppendReadings(readings []Reading) {
+	for _, r := range readings {
+		row := clickHouseRow{
+			Time:  r.Time.UTC().Format("2006-01-02 15:04:05"),
+			Meter: r.Meter,
+			Read:  r.Read,
+		}
+		if r.Estimated {
+			row.Estimated = 1
+		}
+		if !math.IsNaN(r.Usage) {
+			usage := r.Usage
+			row.Usage = &usage
+		}
+		s.rows = append(s.rows, row)
+	}
+}
+
+func (s *clickHouseSink) table() string {
+	return fmt.Sprintf("`%s`.`%s`", s.cfg.Database, s.cfg.Table)
+}
+
+func (s *clickHouseSink) Flush(ctx context.Context) error {
+	if len(s.rows) == 0 {
+		return nil
+	}
+
+	if err := s.query(ctx, `CREATE TABLE IF NOT EXISTS `+s.table()+` (
+	time      DateTime('UTC'),
+	meter     LowCardinality(String),
+	usage     Nullable(Float64),
+	read      Float64,
+	estimated UInt8
+) ENGINE = ReplacingMergeTree
+ORDER BY (meter, time)`, nil); err != nil {
+		return fmt.Errorf("error creating table: %w", err)
+	}
+
+	for pos := 0; pos < len(s.rows); pos += s.cfg.BatchSize {
+		end := pos + s.cfg.BatchSize
+		if end > len(s.rows) {
+			end = len(s.rows)
+		}
+
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, r := range s.rows[pos:end] {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		if err := s.query(ctx, `INSERT INTO `+s.table()+` FORMAT JSONEachRow`, buf.Bytes()); err != nil {
+			return fmt.Errorf("error inserting rows: %w", err)
+		}
+	}
+
+	_ = level.Info(s.logger).Log("msg", "inserted readings into ClickHouse", "url", s.cfg.URL, "table", s.table(), "readings", len(s.rows))
+	s.rows = nil
+	return nil
+}
+
+// query runs the query, with the data appended to it in the request body.
+func (s *clickHouseSink) query(ctx context.Context, query string, data []byte) error {
+	params := url.Values{}
+	params.Set("query", query)
+	u := strings.TrimSuffix(s.cfg.URL, "/") + "/?" + params.Encode()
+
+	return doWithRetries(ctx, log.With(s.logger, "url", s.cfg.URL), s.client, s.cfg.Retries, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if s.cfg.Username != "" {
+			req.Header.Set("X-ClickHouse-User", s.cfg.Username)
+			req.Header.Set("X-ClickHouse-Key", s.cfg.Password)
+		}
+		return req, nil
+	})
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// PeriodConsumption is the total consumption within a period.
+type PeriodConsumption struct {
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Liters float64   `json:"liters"`
+	// ChangePercent is the relative change of the current period compared to
+	// this period, nil if this period has no consumption.
+	ChangePercent *float64 `json:"change_percent,omitempty"`
+	// Significant is set if the current period increased by more than the
+	// threshold compared to this period.
+	Significant bool `json:"significant"`
+}
+
+// Comparison compares the current period with the previous period and the
+// same period last year.
+type Comparison struct {
+	Period   string            `json:"period"`
+	Current  PeriodConsumption `json:"current"`
+	Previous PeriodConsumption `json:"previous"`
+	LastYear PeriodConsumption `json:"last_year"`
+}
+
+func (a *App) periodAggregation(period string) (aggregation, error) {
+	switch period {
+	case "week":
+		return weeklyAggregation(), nil
+	case "month":
+		return billingMonthAggregation(a.cfg.billingAnchorDay), nil
+	default:
+		return aggregation{}, fmt.Errorf("unknown period '%s', expected week or month", period)
+	}
+}
+
+func (a *App) consumptionBetween(ctx context.Context, from, to time.Time) (liters float64, latest time.Time, err error) {
+	err = a.querySamples(ctx, from, to, []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, consumptionMetricName),
+	}, func(_ labels.Labels, t time.Time, v float64) {
+		liters += v
+		if t.After(latest) {
+			latest = t
+		}
+	})
+	return liters, latest, err
+}
+
+// Compare compares the consumption of the current week or billing month with
+// the previous one and the same period last year. As imported data lags behind,
+// only the part of the current period covered by data is compared against the
+// same length of the other periods. Increases above thresholdPercent are
+// flagged as significant.
+func (a *App) Compare(ctx context.Context, period string, now time.Time, thresholdPercent float64) (*Comparison, error) {
+	agg, err := a.periodAggregation(period)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Comparison{Period: period}
+
+	start := agg.start(now)
+	liters, latest, err := a.consumptionBetween(ctx, start, now)
+	if err != nil {
+		return nil, err
+	}
+	end := start
+	if !latest.IsZero() {
+		// hourly readings cover the hour starting at their timestamp
+		end = latest.Add(time.Hour)
+	}
+	c.Current = PeriodConsumption{From: start, To: end, Liters: liters}
+	elapsed := end.Sub(start)
+
+	compareTo := func(from time.Time) (PeriodConsumption, error) {
+		p := PeriodConsumption{From: from, To: from.Add(elapsed)}
+		p.Liters, _, err = a.consumptionBetween(ctx, p.From, p.To)
+		if err != nil {
+			return p, err
+		}
+		if p.Liters > 0 {
+			change := (c.Current.Liters - p.Liters) / p.Liters * 100
+			p.ChangePercent = &change
+			p.Significant = change > thresholdPercent
+		}
+		return p, nil
+	}
+
+	if c.Previous, err = compareTo(agg.start(start.Add(-time.Millisecond))); err != nil {
+		return nil, err
+	}
+	if c.LastYear, err = compareTo(start.AddDate(-1, 0, 0)); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
@@ -0,0 +1,138 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/go-kit/log/level"
+	"golang.org/x/sync/errgroup"
+)
+
+// DaemonConfig configures the daemon mode.
+type DaemonConfig struct {
+	// ListenAddress of the HTTP listener.
+	ListenAddress string
+	// Interval between two runs.
+	Interval time.Duration
+	// ReadyMaxAge is the maximum age of the last successful run, for /readyz
+	// to report the daemon ready.
+	ReadyMaxAge time.Duration
+	// EnablePprof serves the pprof handlers under /debug/pprof/, to profile
+	// the daemon in place.
+	EnablePprof bool
+	// LeaderElection, if set, lets only the elected leader of multiple
+	// replicas import and upload.
+	LeaderElection *LeaderElection
+	// APIToken enables the API under /api/v1/ to trigger runs outside the
+	// schedule, poll their status and list the run history, with the token
+	// as bearer token.
+	APIToken string
+	// GRPCListenAddress, if set, serves the gRPC control API, which requires
+	// the APIToken as well.
+	GRPCListenAddress string
+	// DisableWebUI stops serving the web UI on /, which shows the recent
+	// runs, the freshness of the data and the daily consumption.
+	DisableWebUI bool
+	// ConfigReloadInterval, if set, is the interval to check the config
+	// files for changes, to reload them.
+	ConfigReloadInterval time.Duration
+}
+
+// Daemon runs an import right away and then every interval, until the
+// context is done. Failed runs are logged and retried at the next interval.
+// The importer's own metrics, including those of the TSDB and the shipper,
+// are served on /metrics of the listen address, next to /healthz, /readyz and
+// the web UI on /.
+// Run as a systemd service of Type=notify, it signals readiness once
+// listening, pets the watchdog and reports the run's progress as status.
+// With leader election, only the leader of the replicas runs imports, while
+// the others stand by. Changed config files are picked up by the next run.
+func (a *App) Daemon(ctx context.Context, cfg DaemonConfig) error {
+	if err := a.validateConfig(); err != nil {
+		return err
+	}
+
+	if cfg.GRPCListenAddress != "" && cfg.APIToken == "" {
+		return errors.New("the gRPC control API requires an API token")
+	}
+
+	mux := a.httpHandler(a.reg)
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		a.readyz(w, r, cfg.ReadyMaxAge)
+	})
+	if !cfg.DisableWebUI {
+		mux.Handle("/", a.uiHandler())
+	}
+	triggers := make(chan string, 10)
+	if cfg.APIToken != "" {
+		mux.Handle("/api/v1/", a.runAPIHandler(cfg.APIToken, triggers))
+	}
+	if cfg.EnablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	// the runs and listeners stop together, once one of them fails
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		_ = level.Info(a.logger).Log("msg", "serving metrics", "address", cfg.ListenAddress)
+		return a.serveHTTP(ctx, cfg.ListenAddress, mux)
+	})
+	if cfg.GRPCListenAddress != "" {
+		g.Go(func() error {
+			return a.serveGRPC(ctx, cfg.GRPCListenAddress, cfg.APIToken, triggers)
+		})
+	}
+	a.sdNotify(daemon.SdNotifyReady)
+	defer a.sdNotify(daemon.SdNotifyStopping)
+	go a.sdWatchdog(ctx)
+	if cfg.ConfigReloadInterval > 0 {
+		go a.watchConfigFiles(ctx, cfg.ConfigReloadInterval)
+	}
+
+	g.Go(func() error {
+		if cfg.LeaderElection == nil {
+			a.runEvery(ctx, cfg.Interval, triggers)
+			return nil
+		}
+		a.sdStatus("standing by for leader election")
+		return a.runAsLeader(ctx, *cfg.LeaderElection, func(ctx context.Context) {
+			a.runEvery(ctx, cfg.Interval, triggers)
+		})
+	})
+
+	return g.Wait()
+}
+
+// runEvery runs an import right away and then every interval, until the
+// context is done. The runs queued by the triggers run in between.
+func (a *App) runEvery(ctx context.Context, interval time.Duration, triggers <-chan string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	id := a.runs.add(RunTriggerSchedule, nil)
+	for {
+		if err := a.runRecorded(ctx, id); err != nil {
+			_ = level.Error(a.logger).Log("msg", "run failed", "err", err)
+			a.sdStatus(fmt.Sprintf("waiting for next run, last run failed: %s", err))
+		} else {
+			a.sdStatus("waiting for next run, last run succeeded")
+		}
+		_ = level.Info(a.logger).Log("msg", "waiting for next run", "interval", interval)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			id = a.runs.add(RunTriggerSchedule, nil)
+		case id = <-triggers:
+		}
+	}
+}
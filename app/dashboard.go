@@ -0,0 +1,266 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// DashboardConfig configures a generated Grafana dashboard.
+type DashboardConfig struct {
+	Title string
+	// UID of the dashboard, which Grafana generates on import if empty.
+	UID string
+}
+
+// Grafana units of the series.
+const (
+	grafanaUnitLiters  = "litre"
+	grafanaUnitGBP     = "currencyGBP"
+	grafanaUnitKg      = "masskg"
+	grafanaUnitSeconds = "s"
+	grafanaUnitNone    = "none"
+)
+
+type grafanaTarget struct {
+	RefID        string            `json:"refId"`
+	Datasource   map[string]string `json:"datasource"`
+	Expr         string            `json:"expr"`
+	LegendFormat string            `json:"legendFormat,omitempty"`
+	Interval     string            `json:"interval,omitempty"`
+}
+
+type grafanaPanel struct {
+	ID          int                    `json:"id"`
+	Type        string                 `json:"type"`
+	Title       string                 `json:"title"`
+	Description string                 `json:"description,omitempty"`
+	GridPos     map[string]int         `json:"gridPos"`
+	Datasource  map[string]string      `json:"datasource,omitempty"`
+	Targets     []grafanaTarget        `json:"targets,omitempty"`
+	FieldConfig map[string]interface{} `json:"fieldConfig,omitempty"`
+	Options     map[string]interface{} `json:"options,omitempty"`
+	Collapsed   *bool                  `json:"collapsed,omitempty"`
+	Panels      []grafanaPanel         `json:"panels,omitempty"`
+}
+
+// dashboardBuilder lays out the panels in rows from left to right.
+type dashboardBuilder struct {
+	panels []grafanaPanel
+	x, y   int
+	height int
+}
+
+var grafanaDatasource = map[string]string{"type": "prometheus", "uid": "${datasource}"}
+
+func (b *dashboardBuilder) row(title string) {
+	if b.x > 0 {
+		b.y += b.height
+		b.x = 0
+	}
+	collapsed := false
+	b.panels = append(b.panels, grafanaPanel{
+		ID:        len(b.panels) + 1,
+		Type:      "row",
+		Title:     title,
+		GridPos:   map[string]int{"x": 0, "y": b.y, "w": 24, "h": 1},
+		Collapsed: &collapsed,
+	})
+	b.y++
+}
+
+func (b *dashboardBuilder) add(p grafanaPanel, width, height int) {
+	if b.x+width > 24 {
+		b.y += b.height
+		b.x = 0
+	}
+	p.ID = len(b.panels) + 1
+	p.GridPos = map[string]int{"x": b.x, "y": b.y, "w": width, "h": height}
+	p.Datasource = grafanaDatasource
+	for i := range p.Targets {
+		p.Targets[i].RefID = string(rune('A' + i))
+		p.Targets[i].Datasource = grafanaDatasource
+	}
+	b.panels = append(b.panels, p)
+	b.x += width
+	b.height = height
+}
+
+// timeSeries is a panel of series per meter. Bars suit the sparse samples of
+// the imported readings better than lines.
+func timeSeries(title, description, unit string, bars bool, targets ...grafanaTarget) grafanaPanel {
+	custom := map[string]interface{}{"drawStyle": "line", "fillOpacity": 10, "spanNulls": false}
+	if bars {
+		custom = map[string]interface{}{"drawStyle": "bars", "fillOpacity": 80, "lineWidth": 1}
+	}
+	return grafanaPanel{
+		Type:        "timeseries",
+		Title:       title,
+		Description: description,
+		Targets:     targets,
+		FieldConfig: map[string]interface{}{
+			"defaults":  map[string]interface{}{"unit": unit, "custom": custom},
+			"overrides": []interface{}{},
+		},
+		Options: map[string]interface{}{
+			"legend":  map[string]interface{}{"displayMode": "list", "placement": "bottom"},
+			"tooltip": map[string]interface{}{"mode": "multi"},
+		},
+	}
+}
+
+func stat(title, description, unit string, thresholds []interface{}, targets ...grafanaTarget) grafanaPanel {
+	defaults := map[string]interface{}{"unit": unit}
+	if thresholds != nil {
+		defaults["thresholds"] = map[string]interface{}{"mode": "absolute", "steps": thresholds}
+	}
+	return grafanaPanel{
+		Type:        "stat",
+		Title:       title,
+		Description: description,
+		Targets:     targets,
+		FieldConfig: map[string]interface{}{"defaults": defaults, "overrides": []interface{}{}},
+		Options: map[string]interface{}{
+			"reduceOptions": map[string]interface{}{"calcs": []string{"lastNotNull"}, "fields": "", "values": false},
+			"colorMode":     "value",
+		},
+	}
+}
+
+func thresholdSteps(colors ...interface{}) []interface{} {
+	var steps []interface{}
+	for i := 0; i+1 < len(colors); i += 2 {
+		steps = append(steps, map[string]interface{}{"color": colors[i], "value": colors[i+1]})
+	}
+	return steps
+}
+
+// seriesSelector returns the selector of the imported series, which carry
+// the job and external labels of the importer, with the additional matchers.
+func (a *App) seriesSelector(metricName string, extra ...string) string {
+	var matchers []string
+	if a.cfg.jobLabel != "" {
+		matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, "job", a.cfg.jobLabel).String())
+	}
+	for _, l := range a.externalLabels() {
+		matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, l.Name, l.Value).String())
+	}
+	matchers = append(matchers, extra...)
+	return metricName + "{" + strings.Join(matchers, ",") + "}"
+}
+
+// GenerateDashboard returns a Grafana dashboard of the imported series, which
+// queries the series by the configured job and external labels. Panels of the
+// costs, emissions, aggregates and anomaly scores are only included, if
+// these are enabled. The Prometheus data source is selected by a variable.
+func (a *App) GenerateDashboard(cfg DashboardConfig) ([]byte, error) {
+	if err := a.validateConfig(); err != nil {
+		return nil, err
+	}
+	if cfg.Title == "" {
+		cfg.Title = "Water consumption"
+	}
+
+	const meterMatcher = `meter=~"$meter"`
+	sel := func(metricName string) string {
+		return a.seriesSelector(metricName, meterMatcher)
+	}
+	perMeter := func(expr, interval string) grafanaTarget {
+		return grafanaTarget{Expr: expr, LegendFormat: "{{meter}}", Interval: interval}
+	}
+
+	b := &dashboardBuilder{}
+	b.row("Consumption")
+	b.add(timeSeries("Hourly consumption", "Consumption within each interval reading.", grafanaUnitLiters, true,
+		perMeter(fmt.Sprintf("sum by (meter) (%s)", sel(consumptionMetricName)), "1h"),
+	), 24, 9)
+	b.add(timeSeries("Daily consumption", "Consumption per day, available once the day has been imported.", grafanaUnitLiters, true,
+		perMeter(fmt.Sprintf("sum by (meter) (sum_over_time(%s[1d]))", sel(consumptionMetricName)), "1d"),
+	), 18, 8)
+	b.add(stat("Consumption in range", "Total consumption within the selected time range.", grafanaUnitLiters, nil,
+		grafanaTarget{Expr: fmt.Sprintf("sum(sum_over_time(%s[$__range]))", sel(consumptionMetricName)), Interval: "1d"},
+	), 6, 8)
+
+	if a.cfg.aggregateSeries {
+		b.add(timeSeries("Weekly consumption", "Totals of the completed weeks.", grafanaUnitLiters, true,
+			perMeter(sel(weeklyAggregation().metricName), "1d"),
+		), 12, 8)
+		b.add(timeSeries("Billing month consumption", fmt.Sprintf("Totals of the completed billing months, starting on day %d.", a.cfg.billingAnchorDay), grafanaUnitLiters, true,
+			perMeter(sel(billingMonthAggregation(a.cfg.billingAnchorDay).metricName), "1d"),
+		), 12, 8)
+	}
+
+	if len(a.resolved.tariffs) > 0 {
+		b.row("Cost")
+		b.add(timeSeries("Daily cost", "Cost per day according to the tariffs, including the standing charge.", grafanaUnitGBP, true,
+			perMeter(fmt.Sprintf("sum by (meter) (sum_over_time(%s[1d]))", sel(costMetricName)), "1d"),
+		), 18, 8)
+		b.add(stat("Cost in range", "Total cost within the selected time range.", grafanaUnitGBP, nil,
+			grafanaTarget{Expr: fmt.Sprintf("sum(sum_over_time(%s[$__range]))", sel(costMetricName)), Interval: "1d"},
+		), 6, 8)
+	}
+
+	if len(a.resolved.emissions) > 0 {
+		b.row("Emissions")
+		b.add(timeSeries("Daily emissions", "Emissions per day according to the emission factors.", grafanaUnitKg, true,
+			perMeter(fmt.Sprintf("sum by (meter) (sum_over_time(%s[1d]))", sel(carbonMetricName)), "1d"),
+		), 18, 8)
+		b.add(stat("Emissions in range", "Total emissions within the selected time range.", grafanaUnitKg, nil,
+			grafanaTarget{Expr: fmt.Sprintf("sum(sum_over_time(%s[$__range]))", sel(carbonMetricName)), Interval: "1d"},
+		), 6, 8)
+	}
+
+	b.row("Importer")
+	if a.cfg.anomalyDetection != nil {
+		b.add(timeSeries("Anomaly score", fmt.Sprintf("Deviation of the newest day from the baseline, alerted above %g.", a.cfg.anomalyDetection.Threshold), grafanaUnitNone, false,
+			perMeter(fmt.Sprintf(`max by (meter) (water_importer_anomaly_score{%s})`, meterMatcher), ""),
+		), 24, 8)
+	}
+	b.add(stat("Last run", "Whether the last run succeeded.", grafanaUnitNone, thresholdSteps("red", nil, "green", 1),
+		grafanaTarget{Expr: "min(water_importer_last_run_success)"},
+	), 6, 6)
+	b.add(stat("Data age", "Age of the newest imported sample per meter.", grafanaUnitSeconds, thresholdSteps("green", nil, "orange", 3*86400, "red", 7*86400),
+		perMeter(fmt.Sprintf(`time() - max by (meter) (water_importer_latest_sample_timestamp_seconds{%s})`, meterMatcher), ""),
+	), 6, 6)
+	b.add(timeSeries("Days fetched", "Days of readings fetched from the provider per meter.", grafanaUnitNone, true,
+		perMeter(fmt.Sprintf(`sum by (meter) (increase(water_importer_days_fetched_total{%s}[1h]))`, meterMatcher), "1h"),
+	), 12, 6)
+
+	dashboard := map[string]interface{}{
+		"title":         cfg.Title,
+		"tags":          []string{"water"},
+		"timezone":      "utc",
+		"schemaVersion": 36,
+		"editable":      true,
+		"time":          map[string]string{"from": "now-30d", "to": "now"},
+		"panels":        b.panels,
+		"templating": map[string]interface{}{
+			"list": []interface{}{
+				map[string]interface{}{
+					"name":  "datasource",
+					"label": "Data source",
+					"type":  "datasource",
+					"query": "prometheus",
+				},
+				map[string]interface{}{
+					"name":       "meter",
+					"label":      "Meter",
+					"type":       "query",
+					"datasource": grafanaDatasource,
+					"query":      fmt.Sprintf("label_values(%s, meter)", a.seriesSelector(consumptionMetricName)),
+					"refresh":    2,
+					"multi":      true,
+					"includeAll": true,
+					"allValue":   ".+",
+					"current":    map[string]interface{}{"text": "All", "value": "$__all"},
+				},
+			},
+		},
+	}
+	if cfg.UID != "" {
+		dashboard["uid"] = cfg.UID
+	}
+	return json.MarshalIndent(dashboard, "", "  ")
+}
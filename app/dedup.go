@@ -0,0 +1,256 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// overlappingGroups groups blocks, which overlap in time. Only groups with
+// more than one block are returned.
+func overlappingGroups(metas []*metadata.Meta) [][]*metadata.Meta {
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].MinTime < metas[j].MinTime
+	})
+
+	var (
+		groups [][]*metadata.Meta
+		group  []*metadata.Meta
+		maxt   int64
+	)
+	for _, m := range metas {
+		if len(group) > 0 && m.MinTime < maxt {
+			group = append(group, m)
+			if m.MaxTime > maxt {
+				maxt = m.MaxTime
+			}
+			continue
+		}
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+		group = []*metadata.Meta{m}
+		maxt = m.MaxTime
+	}
+	if len(group) > 1 {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+type dedupSample struct {
+	v         float64
+	estimated bool
+}
+
+type dedupSeries struct {
+	lbls    labels.Labels
+	samples map[int64]dedupSample
+}
+
+// estimatedKey identifies the reading a sample is derived from, so the
+// consumption, cost and emission samples of a reading share the marker of
+// the estimated reading.
+func estimatedKey(lbls labels.Labels, t int64) string {
+	b := labels.NewBuilder(lbls)
+	b.Del(labels.MetricName, "component")
+	return fmt.Sprintf("%s@%d", b.Labels(), t)
+}
+
+// mergeBlocks merges a group of overlapping blocks into a single block. For
+// samples with the same timestamp actual readings win over estimated ones,
+// otherwise the value of the newest block wins. The markers of the estimated
+// readings are written for the winning consumption samples.
+func (a *App) mergeBlocks(ctx context.Context, dir string, group []*metadata.Meta) error {
+	// process the oldest block first, so newer blocks override its samples
+	sorted := make([]*metadata.Meta, len(group))
+	copy(sorted, group)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ULID.Time() < sorted[j].ULID.Time()
+	})
+
+	type blockSample struct {
+		lbls labels.Labels
+		t    int64
+		v    float64
+	}
+
+	var (
+		series     = make(map[string]*dedupSeries)
+		mint, maxt = sorted[0].MinTime, sorted[0].MaxTime
+		conflicts  int
+	)
+	for _, m := range sorted {
+		if m.MinTime < mint {
+			mint = m.MinTime
+		}
+		if m.MaxTime > maxt {
+			maxt = m.MaxTime
+		}
+
+		// read the whole block first, as the markers may follow the samples
+		// they mark
+		var (
+			samples   []blockSample
+			estimated = make(map[string]struct{})
+		)
+		if err := a.readBlockSamples(filepath.Join(dir, m.ULID.String()), m, func(lbls labels.Labels, t int64, v float64) {
+			if lbls.Get(labels.MetricName) == estimatedMetricName {
+				estimated[estimatedKey(lbls, t)] = struct{}{}
+				return
+			}
+			samples = append(samples, blockSample{lbls: lbls, t: t, v: v})
+		}); err != nil {
+			return err
+		}
+
+		for _, bs := range samples {
+			key := bs.lbls.String()
+			s, ok := series[key]
+			if !ok {
+				s = &dedupSeries{lbls: bs.lbls, samples: make(map[int64]dedupSample)}
+				series[key] = s
+			}
+			_, isEstimated := estimated[estimatedKey(bs.lbls, bs.t)]
+			if old, ok := s.samples[bs.t]; ok {
+				if old.v != bs.v {
+					conflicts++
+				}
+				if !old.estimated && isEstimated {
+					continue
+				}
+			}
+			s.samples[bs.t] = dedupSample{v: bs.v, estimated: isEstimated}
+		}
+	}
+
+	id, err := a.writeBlock(ctx, dir, mint, maxt, nil, func(app storage.Appender) error {
+		for _, s := range series {
+			ts := make([]int64, 0, len(s.samples))
+			for t := range s.samples {
+				ts = append(ts, t)
+			}
+			sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+
+			var (
+				ref, estimatedRef storage.SeriesRef
+				estimatedLbls     labels.Labels
+			)
+			if s.lbls.Get(labels.MetricName) == consumptionMetricName {
+				b := labels.NewBuilder(s.lbls)
+				b.Set(labels.MetricName, estimatedMetricName)
+				estimatedLbls = b.Labels()
+			}
+			for _, t := range ts {
+				var err error
+				sample := s.samples[t]
+				if ref, err = app.Append(ref, s.lbls, t, sample.v); err != nil {
+					return err
+				}
+				if sample.estimated && estimatedLbls != nil {
+					if estimatedRef, err = app.Append(estimatedRef, estimatedLbls, t, 1); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range group {
+		if err := os.RemoveAll(filepath.Join(dir, m.ULID.String())); err != nil {
+			return err
+		}
+	}
+
+	_ = level.Info(a.logger).Log("msg", "merged overlapping blocks", "path", dir, "blocks", len(group), "conflicting_samples", conflicts, "ulid", id)
+	return nil
+}
+
+func (a *App) readBlockSamples(blockDir string, m *metadata.Meta, f func(lbls labels.Labels, t int64, v float64)) error {
+	pb, err := tsdb.OpenBlock(a.logger, blockDir, nil)
+	if err != nil {
+		return err
+	}
+	defer pb.Close()
+
+	q, err := tsdb.NewBlockQuerier(pb, m.MinTime, m.MaxTime-1)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	ss := q.Select(false, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+	for ss.Next() {
+		s := ss.At()
+		it := s.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			f(s.Labels(), t, v)
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+	return ss.Err()
+}
+
+// DeduplicateBlocks merges overlapping blocks in the local TSDB into single
+// blocks, resolving duplicate samples in favour of actual readings and then of
+// the newest block. Groups containing blocks uploaded already are skipped, as
+// the merged block would be uploaded next to their copies in the buckets. It
+// returns the number of merged groups.
+func (a *App) DeduplicateBlocks(ctx context.Context) (int, error) {
+	l, err := a.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer l.Release()
+
+	streams, err := a.localStreams()
+	if err != nil {
+		return 0, err
+	}
+
+	var merged int
+	for _, s := range streams {
+		metas, err := listBlocks(s.path)
+		if err != nil {
+			return merged, err
+		}
+		uploaded, err := a.uploadedToAny(s)
+		if err != nil {
+			return merged, err
+		}
+
+	groups:
+		for _, group := range overlappingGroups(metas) {
+			if err := ctx.Err(); err != nil {
+				return merged, err
+			}
+			for _, m := range group {
+				if _, ok := uploaded[m.ULID]; ok {
+					_ = level.Warn(a.logger).Log("msg", "skipped overlapping blocks, as they have been uploaded already", "path", s.path, "blocks", len(group), "uploaded", m.ULID)
+					continue groups
+				}
+			}
+			if err := a.mergeBlocks(ctx, s.path, group); err != nil {
+				return merged, fmt.Errorf("error merging overlapping blocks in %s: %w", s.path, err)
+			}
+			merged++
+		}
+	}
+
+	return merged, nil
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/grafana/dskit/runutil"
+	"github.com/oklog/ulid"
+	"github.com/thanos-io/thanos/pkg/objstore"
+)
+
+// destination is a bucket the blocks are uploaded to. Each destination keeps
+// track of the blocks uploaded to it in its own shipper meta file.
+type destination struct {
+	// name is empty for the primary bucket
+	name string
+	bkt  objstore.Bucket
+}
+
+func (d destination) String() string {
+	if d.name == "" {
+		return "primary"
+	}
+	return d.name
+}
+
+// stateDir returns the directory of the shipper meta file, which records the
+// blocks uploaded from the stream to the destination.
+func (d destination) stateDir(s stream) string {
+	return destinationStateDir(s, d.name)
+}
+
+// destinationStateDir returns the state directory of the named destination.
+// The primary bucket uses the stream directory itself, like the Thanos
+// shipper.
+func destinationStateDir(s stream, name string) string {
+	if name == "" {
+		return s.path
+	}
+	return filepath.Join(s.path, "thanos", "mirrors", name)
+}
+
+// mirrorName derives the name of a mirror from its config file.
+func mirrorName(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// destinationNames returns the names of all destinations, starting with the
+// primary bucket.
+func (a *App) destinationNames() []string {
+	names := []string{""}
+	for _, path := range a.cfg.mirrorBucketConfigFiles {
+		names = append(names, mirrorName(path))
+	}
+	return names
+}
+
+// newDestinations creates the clients of the primary bucket and all mirrors.
+func (a *App) newDestinations() ([]destination, error) {
+	bktConfig, err := a.bucketConfig()
+	if err != nil {
+		return nil, err
+	}
+	primary := destination{}
+	primary.bkt, err = a.newBucket(primary, bktConfig)
+	if err != nil {
+		return nil, err
+	}
+	dests := []destination{primary}
+
+	seen := make(map[string]struct{})
+	for _, path := range a.cfg.mirrorBucketConfigFiles {
+		name := mirrorName(path)
+		if _, ok := seen[name]; ok || name == "" {
+			a.closeDestinations(dests)
+			return nil, fmt.Errorf("mirror bucket config files need distinct names, got %s", path)
+		}
+		seen[name] = struct{}{}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			a.closeDestinations(dests)
+			return nil, fmt.Errorf("error reading mirror bucket config file: %w", err)
+		}
+		mirror := destination{name: name}
+		mirror.bkt, err = a.newBucket(mirror, data)
+		if err != nil {
+			a.closeDestinations(dests)
+			return nil, fmt.Errorf("error creating mirror bucket %s: %w", name, err)
+		}
+		dests = append(dests, mirror)
+	}
+
+	return dests, nil
+}
+
+func (a *App) closeDestinations(dests []destination) {
+	for _, d := range dests {
+		runutil.CloseWithLogOnErr(a.logger, d.bkt, "bucket client")
+	}
+}
+
+// uploadedToAll returns the blocks of the stream, which have been uploaded to
+// all destinations.
+func (a *App) uploadedToAll(s stream) (map[ulid.ULID]struct{}, error) {
+	var all map[ulid.ULID]struct{}
+	for _, name := range a.destinationNames() {
+		uploaded, err := uploadedBlocks(destinationStateDir(s, name))
+		if err != nil {
+			return nil, err
+		}
+		if all == nil {
+			all = uploaded
+			continue
+		}
+		for id := range all {
+			if _, ok := uploaded[id]; !ok {
+				delete(all, id)
+			}
+		}
+	}
+	return all, nil
+}
+
+// uploadedToAny returns the blocks of the stream, which have been uploaded to
+// any destination. Rewriting them would leave their old copies in the
+// buckets.
+func (a *App) uploadedToAny(s stream) (map[ulid.ULID]struct{}, error) {
+	result := make(map[ulid.ULID]struct{})
+	for _, name := range a.destinationNames() {
+		uploaded, err := uploadedBlocks(destinationStateDir(s, name))
+		if err != nil {
+			return nil, err
+		}
+		for id := range uploaded {
+			result[id] = struct{}{}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+// discordMessageLimit is the maximum length of the content of a Discord
+// message.
+const discordMessageLimit = 2000
+
+// Discord configures a Discord webhook, which receives the run results.
+type Discord struct {
+	WebhookURL string `yaml:"webhook_url"`
+	// OnlyFailures skips the messages about successful runs without alerts.
+	OnlyFailures bool          `yaml:"only_failures"`
+	Timeout      time.Duration `yaml:"timeout"`
+	Retries      int           `yaml:"retries"`
+}
+
+// WithDiscord sends the run results to the Discord webhook.
+func WithDiscord(d Discord) NewOption {
+	return func(a *App) {
+		a.cfg.discords = append(a.cfg.discords, d)
+	}
+}
+
+type discordNotifier struct {
+	logger log.Logger
+	cfg    Discord
+}
+
+func (n *discordNotifier) name() string {
+	// the webhook URL contains the secret
+	return "discord"
+}
+
+func (n *discordNotifier) text(summary RunSummary) string {
+	text := summaryText(summary)
+	if summary.Status == RunStatusFailure && len(summary.LastLogLines) > 0 {
+		logs := strings.Join(summary.LastLogLines, "\n")
+		// keep the closing code fence, even when the logs are too long
+		logs = truncateText(logs, discordMessageLimit-len([]rune(text))-len("```\n\n```"))
+		text += "```\n" + logs + "\n```"
+	}
+	return truncateText(text, discordMessageLimit)
+}
+
+func (n *discordNotifier) notify(ctx context.Context, summary RunSummary) error {
+	if n.cfg.OnlyFailures && !summary.needsAttention() {
+		return nil
+	}
+
+	body, err := json.Marshal(struct {
+		Content string `json:"content"`
+	}{Content: n.text(summary)})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: n.cfg.Timeout}
+	return doWithRetries(ctx, log.With(n.logger, "notifier", n.name()), client, n.cfg.Retries, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.cfg.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+}
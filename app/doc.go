@@ -0,0 +1,16 @@
+// Package app implements the importer of the Thames Water smart meter
+// readings, which backs the thames-water-importer command.
+//
+// Programs embedding the importer use an Importer to fetch the readings or to
+// write the samples derived from them to their own Sink:
+//
+//	imp := app.NewImporter(
+//		app.WithThamesWaterLogin(email, password),
+//		app.WithLogger(logger),
+//	)
+//	readings, err := imp.FetchReadings(ctx, from, to)
+//
+// Readings are imported from Thames Water, unless another Provider is set by
+// WithAnglianWater, WithPlugin or WithProvider. The App adds the local TSDB,
+// the uploads and the long running modes of the command on top.
+package app
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+// DuplicateSamplePolicy decides how samples are handled, whose timestamp is
+// already stored in the local TSDB, e.g. when overlapping days are imported
+// again.
+type DuplicateSamplePolicy string
+
+const (
+	// DuplicateSamplesError passes all samples to the TSDB, which fails the
+	// run on samples older than the newest stored one.
+	DuplicateSamplesError DuplicateSamplePolicy = "error"
+	// DuplicateSamplesIgnoreIdentical skips samples, which are stored with
+	// the same value already, and fails the run on conflicting values.
+	DuplicateSamplesIgnoreIdentical DuplicateSamplePolicy = "ignore-identical"
+	// DuplicateSamplesPreferNewest skips identical samples as well. Readings
+	// of the same interval within a response are resolved in favour of the
+	// newest non-estimated one. Conflicting values of stored samples are
+	// logged and counted, as the TSDB can't replace them, so the stored ones
+	// are kept.
+	DuplicateSamplesPreferNewest DuplicateSamplePolicy = "prefer-newest"
+)
+
+// ParseDuplicateSamplePolicy validates the name of a policy.
+func ParseDuplicateSamplePolicy(s string) (DuplicateSamplePolicy, error) {
+	switch p := DuplicateSamplePolicy(s); p {
+	case DuplicateSamplesError, DuplicateSamplesIgnoreIdentical, DuplicateSamplesPreferNewest:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown duplicate sample policy '%s', must be one of %s, %s or %s", s, DuplicateSamplesError, DuplicateSamplesIgnoreIdentical, DuplicateSamplesPreferNewest)
+	}
+}
+
+// WithDuplicateSamplePolicy sets how samples already stored in the local TSDB
+// are handled, it defaults to DuplicateSamplesIgnoreIdentical.
+func WithDuplicateSamplePolicy(p DuplicateSamplePolicy) NewOption {
+	return func(a *App) {
+		a.cfg.duplicateSamplePolicy = p
+	}
+}
+
+// dedupReadings resolves readings of the same meter and interval within a
+// response. Identical ones are dropped, conflicting ones fail, unless the
+// newest non-estimated one is preferred. The order of the first occurrences
+// is kept, so the first reading of the day stays first.
+func (a *App) dedupReadings(readings []Reading) ([]Reading, error) {
+	if a.cfg.duplicateSamplePolicy == DuplicateSamplesError {
+		return readings, nil
+	}
+
+	type key struct {
+		meter string
+		t     int64
+	}
+	var (
+		index  = make(map[key]int, len(readings))
+		result = make([]Reading, 0, len(readings))
+	)
+	for _, r := range readings {
+		k := key{meter: r.Meter, t: r.Time.UnixNano()}
+		pos, ok := index[k]
+		if !ok {
+			index[k] = len(result)
+			result = append(result, r)
+			continue
+		}
+
+		existing := result[pos]
+		if math.Float64bits(existing.Read) == math.Float64bits(r.Read) && existing.Estimated == r.Estimated {
+			a.metrics.duplicateSamples.WithLabelValues("identical").Inc()
+			continue
+		}
+		if a.cfg.duplicateSamplePolicy != DuplicateSamplesPreferNewest {
+			return nil, fmt.Errorf("conflicting readings of meter %s at %s: %g and %g", r.Meter, r.Time.UTC().Format(time.RFC3339), existing.Read, r.Read)
+		}
+		a.metrics.duplicateSamples.WithLabelValues("conflict").Inc()
+		if !r.Estimated || existing.Estimated {
+			result[pos] = r
+		}
+	}
+	return result, nil
+}
+
+// duplicateAppender skips the samples, which the TSDB stores already. The
+// stored samples of a series within [mint, maxt] are looked up once per
+// series.
+type duplicateAppender struct {
+	storage.Appender
+	ctx        context.Context
+	app        *App
+	db         *tsdb.DB
+	mint, maxt int64
+	stored     map[string]map[int64]float64
+}
+
+// duplicateAppender wraps the appender, unless all samples are passed to the
+// TSDB.
+func (a *App) duplicateAppender(ctx context.Context, db *tsdb.DB, next storage.Appender, readings []Reading) storage.Appender {
+	if a.cfg.duplicateSamplePolicy == DuplicateSamplesError || len(readings) == 0 {
+		return next
+	}
+	d := &duplicateAppender{
+		Appender: next,
+		ctx:      ctx,
+		app:      a,
+		db:       db,
+		mint:     math.MaxInt64,
+		maxt:     math.MinInt64,
+		stored:   make(map[string]map[int64]float64),
+	}
+	for _, r := range readings {
+		t := timestamp.FromTime(r.Time)
+		if t < d.mint {
+			d.mint = t
+		}
+		if t > d.maxt {
+			d.maxt = t
+		}
+	}
+	return d
+}
+
+func (d *duplicateAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	samples, err := d.storedSamples(l)
+	if err != nil {
+		return ref, err
+	}
+	stored, ok := samples[t]
+	if !ok {
+		return d.Appender.Append(ref, l, t, v)
+	}
+
+	if math.Float64bits(stored) == math.Float64bits(v) {
+		d.app.metrics.duplicateSamples.WithLabelValues("identical").Inc()
+		return ref, nil
+	}
+	if d.app.cfg.duplicateSamplePolicy != DuplicateSamplesPreferNewest {
+		return ref, fmt.Errorf("conflicting sample of %s at %s: stored %g, new %g", l, timestamp.Time(t).UTC().Format(time.RFC3339), stored, v)
+	}
+	d.app.metrics.duplicateSamples.WithLabelValues("conflict").Inc()
+	_ = level.Warn(d.app.logger).Log("msg", "keeping stored sample, which conflicts with the imported one", "series", l, "time", timestamp.Time(t), "stored", stored, "imported", v)
+	return ref, nil
+}
+
+// storedSamples returns the stored samples of the series.
+func (d *duplicateAppender) storedSamples(l labels.Labels) (map[int64]float64, error) {
+	key := l.String()
+	if samples, ok := d.stored[key]; ok {
+		return samples, nil
+	}
+
+	q, err := d.db.Querier(d.ctx, d.mint, d.maxt)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	matchers := make([]*labels.Matcher, 0, len(l))
+	for _, lbl := range l {
+		matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, lbl.Name, lbl.Value))
+	}
+
+	samples := make(map[int64]float64)
+	ss := q.Select(false, nil, matchers...)
+	for ss.Next() {
+		s := ss.At()
+		// the matchers don't rule out series with additional labels
+		if !labels.Equal(s.Labels(), l) {
+			continue
+		}
+		it := s.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			samples[t] = v
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if err := ss.Err(); err != nil {
+		return nil, err
+	}
+
+	d.stored[key] = samples
+	return samples, nil
+}
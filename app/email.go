@@ -0,0 +1,268 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"html/template"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// Email configures an SMTP server, which sends failure alerts, the alerts
+// raised by a run and consumption summaries by email.
+type Email struct {
+	Host string `yaml:"host"`
+	// Port 465 uses implicit TLS, all other ports upgrade the connection using
+	// STARTTLS, if the server supports it.
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	// Summary is the period of the consumption summary, either day or week.
+	// The summary is sent once the imported data completes the period. By
+	// default only failures are sent.
+	Summary string        `yaml:"summary"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// WithEmail sends failure alerts and consumption summaries by email.
+func WithEmail(e Email) NewOption {
+	return func(a *App) {
+		a.cfg.emails = append(a.cfg.emails, e)
+	}
+}
+
+// ValidateEmail checks the email configuration.
+func ValidateEmail(e Email) error {
+	if e.Host == "" || e.From == "" || len(e.To) == 0 {
+		return fmt.Errorf("email needs host, from and to")
+	}
+	switch e.Summary {
+	case "", "day", "week":
+	default:
+		return fmt.Errorf("invalid email summary '%s', expected day or week", e.Summary)
+	}
+	return nil
+}
+
+var emailTemplate = template.Must(template.New("email").Funcs(template.FuncMap{
+	"liters": func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 0, 64) + " l"
+	},
+	"gbp": func(v float64) string {
+		return "£" + strconv.FormatFloat(v, 'f', 2, 64)
+	},
+	"date": func(t time.Time) string {
+		return t.Format("Mon 2 Jan 2006")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+{{- with .Run }}{{ if eq .Status "failure" }}
+<h2 style="color: #b00020;">Thames Water import failed</h2>
+<p>The import failed after {{ printf "%.0f" .DurationSeconds }}s ({{ .ErrorCategory }}):</p>
+<pre>{{ .Error }}</pre>
+{{- if .Screenshot }}
+<p>A screenshot of the failed login has been saved to <code>{{ .Screenshot }}</code>.</p>
+{{- end }}
+{{- if .LastLogLines }}
+<p>Last log lines:</p>
+<pre style="font-size: small;">{{ range .LastLogLines }}{{ . }}
+{{ end }}</pre>
+{{- end }}
+{{- end }}
+{{- if .Alerts }}
+<h2 style="color: #b00020;">Water alerts</h2>
+<ul>
+{{- range .Alerts }}
+<li><b>{{ .Name }}:</b> {{ .Summary }}</li>
+{{- end }}
+</ul>
+{{- end }}{{ end }}
+{{- with .Consumption }}
+<h2>Water consumption {{ date .From }}{{ if ne (date .From) (date $.Last) }} &ndash; {{ date $.Last }}{{ end }}</h2>
+<p style="font-size: x-large;">{{ liters .TotalLiters }}{{ if .TotalCostGBP }} &middot; {{ gbp .TotalCostGBP }}{{ end }}</p>
+<table cellpadding="4" style="border-collapse: collapse;">
+<tr><th align="left">Day</th><th align="left">Meter</th><th align="right">Consumption</th>{{ if .TotalCostGBP }}<th align="right">Cost</th>{{ end }}</tr>
+{{- range .Days }}
+<tr><td>{{ date .Date }}</td><td>{{ .Meter }}</td><td align="right">{{ liters .Liters }}</td>{{ if $.Consumption.TotalCostGBP }}<td align="right">{{ gbp .CostGBP }}</td>{{ end }}</tr>
+{{- end }}
+</table>
+{{- end }}
+</body>
+</html>
+`))
+
+type emailTemplateData struct {
+	Run         *RunSummary
+	Consumption *Summary
+	// Last is the last day of the consumption summary.
+	Last time.Time
+}
+
+type emailNotifier struct {
+	logger log.Logger
+	cfg    Email
+	// summary returns the consumption within [from, to).
+	summary func(ctx context.Context, from, to time.Time) (*Summary, error)
+}
+
+func (n *emailNotifier) name() string {
+	return "email " + strings.Join(n.cfg.To, ",")
+}
+
+// summaryPeriod returns the period completed by the days imported during the
+// run, which is zero if no period has been completed.
+func (n *emailNotifier) summaryPeriod(days []time.Time) (from, to time.Time) {
+	var agg aggregation
+	switch n.cfg.Summary {
+	case "day":
+		agg = aggregation{
+			start: truncateDay,
+			end: func(start time.Time) time.Time {
+				return start.AddDate(0, 0, 1)
+			},
+		}
+	case "week":
+		agg = weeklyAggregation()
+	default:
+		return
+	}
+
+	for _, day := range days {
+		start := agg.start(day)
+		end := agg.end(start)
+		if !end.AddDate(0, 0, -1).Equal(truncateDay(day)) {
+			continue
+		}
+		if start.After(from) {
+			from, to = start, end
+		}
+	}
+	return from, to
+}
+
+func (n *emailNotifier) notify(ctx context.Context, summary RunSummary) error {
+	var (
+		data    emailTemplateData
+		subject string
+	)
+	if summary.Status == RunStatusFailure {
+		data.Run = &summary
+		subject = "Thames Water import failed"
+	} else {
+		if from, to := n.summaryPeriod(summary.days); !from.IsZero() {
+			consumption, err := n.summary(ctx, from, to)
+			if err != nil {
+				return fmt.Errorf("error summarizing consumption: %w", err)
+			}
+			data.Consumption = consumption
+			data.Last = to.AddDate(0, 0, -1)
+			subject = fmt.Sprintf("Water consumption: %.0f l", consumption.TotalLiters)
+		}
+		if len(summary.Alerts) > 0 {
+			data.Run = &summary
+			subject = fmt.Sprintf("Water alert: %s", summary.Alerts[0].Name)
+		}
+		if subject == "" {
+			return nil
+		}
+	}
+
+	var body bytes.Buffer
+	if err := emailTemplate.Execute(&body, data); err != nil {
+		return err
+	}
+
+	if err := n.send(subject, body.Bytes()); err != nil {
+		return err
+	}
+	_ = level.Info(n.logger).Log("msg", "sent email", "subject", subject, "to", strings.Join(n.cfg.To, ","))
+	return nil
+}
+
+func (n *emailNotifier) message(subject string, body []byte) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.Write(bytes.ReplaceAll(body, []byte("\n"), []byte("\r\n")))
+	return msg.Bytes()
+}
+
+func (n *emailNotifier) send(subject string, body []byte) error {
+	port := n.cfg.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(n.cfg.Host, strconv.Itoa(port))
+	dialer := &net.Dialer{Timeout: n.cfg.Timeout}
+	tlsConfig := &tls.Config{ServerName: n.cfg.Host}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if n.cfg.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(n.cfg.Timeout))
+	}
+
+	c, err := smtp.NewClient(conn, n.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && port != 465 {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if n.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, n.cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range n.cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(n.message(subject, body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"bytes"
+	"math"
+	"text/template"
+	"time"
+)
+
+// readingEvent is the JSON representation of a reading published to message
+// brokers.
+type readingEvent struct {
+	Time      time.Time `json:"time"`
+	Meter     string    `json:"meter"`
+	Usage     *float64  `json:"usage,omitempty"`
+	Read      float64   `json:"read"`
+	Estimated bool      `json:"estimated"`
+}
+
+func newReadingEvent(r Reading) readingEvent {
+	e := readingEvent{
+		Time:      r.Time,
+		Meter:     r.Meter,
+		Read:      r.Read,
+		Estimated: r.Estimated,
+	}
+	if !math.IsNaN(r.Usage) {
+		usage := r.Usage
+		e.Usage = &usage
+	}
+	return e
+}
+
+// meterTemplateData is available to topic and subject templates.
+type meterTemplateData struct {
+	Meter string
+}
+
+func executeMeterTemplate(t *template.Template, meter string) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, meterTemplateData{Meter: meter}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
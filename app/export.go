@@ -0,0 +1,149 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+)
+
+type exportSample struct {
+	t int64
+	v float64
+}
+
+type exportSeries struct {
+	lbls    labels.Labels
+	samples []exportSample
+}
+
+// exportSeriesSet collects all series of the local TSDB within [from, to),
+// grouped by their metric name.
+func (a *App) exportSeriesSet(ctx context.Context, from, to time.Time) (map[string][]*exportSeries, error) {
+	var (
+		families = make(map[string][]*exportSeries)
+		byLabels = make(map[string]*exportSeries)
+	)
+	if err := a.querySamples(ctx, from, to, []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"),
+	}, func(lbls labels.Labels, t time.Time, v float64) {
+		key := lbls.String()
+		s, ok := byLabels[key]
+		if !ok {
+			s = &exportSeries{lbls: lbls}
+			byLabels[key] = s
+			name := lbls.Get(labels.MetricName)
+			families[name] = append(families[name], s)
+		}
+		s.samples = append(s.samples, exportSample{t: timestamp.FromTime(t), v: v})
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, series := range families {
+		for _, s := range series {
+			sort.Slice(s.samples, func(i, j int) bool { return s.samples[i].t < s.samples[j].t })
+		}
+	}
+	return families, nil
+}
+
+var openMetricsEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func formatOpenMetricsValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ExportOpenMetrics writes all series of the local TSDB within [from, to) in
+// the OpenMetrics text format, as accepted by `promtool tsdb create-blocks-from
+// openmetrics`.
+func (a *App) ExportOpenMetrics(ctx context.Context, w io.Writer, from, to time.Time) error {
+	families, err := a.exportSeriesSet(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		fmt.Fprintf(bw, "# TYPE %s gauge\n", name)
+		for _, s := range families[name] {
+			var lbls []string
+			for _, l := range s.lbls {
+				if l.Name == labels.MetricName {
+					continue
+				}
+				lbls = append(lbls, fmt.Sprintf(`%s="%s"`, l.Name, openMetricsEscaper.Replace(l.Value)))
+			}
+			for _, sample := range s.samples {
+				fmt.Fprintf(bw, "%s{%s} %s %d.%03d\n", name, strings.Join(lbls, ","), formatOpenMetricsValue(sample.v), sample.t/1000, sample.t%1000)
+			}
+		}
+	}
+	fmt.Fprintln(bw, "# EOF")
+
+	return bw.Flush()
+}
+
+// csvHeader follows the layout of the manual download on the Thames Water
+// website, with an additional meter column at the end.
+var csvHeader = []string{"Date", "Time", "Consumption (Litres)", "Meter Reading", "Estimated", "Meter"}
+
+// ExportCSV writes one line per reading interval. The consumption column
+// holds the consumption stored as water_consumption_liters, the meter reading
+// column the usage reported by the provider. Meter reading and estimated
+// columns are left empty for readings from the TSDB, which doesn't store them.
+func (a *App) ExportCSV(w io.Writer, readings []Reading, fromTSDB bool) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, r := range readings {
+		var usage, estimated string
+		if !math.IsNaN(r.Usage) {
+			usage = strconv.FormatFloat(r.Usage, 'f', -1, 64)
+		}
+		if !fromTSDB {
+			estimated = "No"
+			if r.Estimated {
+				estimated = "Yes"
+			}
+		}
+		if err := cw.Write([]string{
+			r.Time.Format("02/01/2006"),
+			r.Time.Format("15:04"),
+			strconv.FormatFloat(r.Read, 'f', -1, 64),
+			usage,
+			estimated,
+			r.Meter,
+		}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
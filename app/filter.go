@@ -0,0 +1,73 @@
+package app
+
+import (
+	"math"
+)
+
+// SampleFilter configures which readings are written. It is applied to the
+// readings of every day before their samples reach the local TSDB or any
+// sink.
+type SampleFilter struct {
+	// DropZero drops readings without any consumption.
+	DropZero bool
+	// ClampNegative writes negative readings, e.g. of corrected meter reads,
+	// as zero.
+	ClampNegative bool
+	// SkipEstimated drops readings, which the provider flags as estimated.
+	SkipEstimated bool
+	// MinLiters and MaxLiters drop readings outside [MinLiters, MaxLiters],
+	// if MaxLiters is greater than MinLiters.
+	MinLiters float64
+	MaxLiters float64
+}
+
+// Reasons of filtered readings.
+const (
+	filterReasonEstimated  = "estimated"
+	filterReasonNegative   = "negative"
+	filterReasonZero       = "zero"
+	filterReasonOutOfRange = "out_of_range"
+)
+
+// WithSampleFilter filters the readings before they are written, dropping or
+// clamping them as configured.
+func WithSampleFilter(f SampleFilter) NewOption {
+	return func(a *App) {
+		a.cfg.sampleFilter = &f
+	}
+}
+
+// filterReadings applies the sample filter to the readings and counts the
+// readings filtered by reason. Negative readings are clamped first, so the
+// clamped ones are dropped with the zero ones, if both is configured.
+func (a *App) filterReadings(readings []Reading) []Reading {
+	f := a.cfg.sampleFilter
+	if f == nil {
+		return readings
+	}
+
+	result := make([]Reading, 0, len(readings))
+	for _, r := range readings {
+		if f.SkipEstimated && r.Estimated {
+			a.metrics.samplesFiltered.WithLabelValues(filterReasonEstimated).Inc()
+			continue
+		}
+		if f.ClampNegative && r.Read < 0 {
+			a.metrics.samplesFiltered.WithLabelValues(filterReasonNegative).Inc()
+			r.Read = 0
+			if r.Usage < 0 {
+				r.Usage = 0
+			}
+		}
+		if f.DropZero && r.Read == 0 {
+			a.metrics.samplesFiltered.WithLabelValues(filterReasonZero).Inc()
+			continue
+		}
+		if f.MaxLiters > f.MinLiters && (r.Read < f.MinLiters || r.Read > f.MaxLiters || math.IsNaN(r.Read)) {
+			a.metrics.samplesFiltered.WithLabelValues(filterReasonOutOfRange).Inc()
+			continue
+		}
+		result = append(result, r)
+	}
+	return result
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+)
+
+// deleteUploadedBlocks removes local blocks, which have been uploaded to all
+// destinations and which have been created longer than the grace period ago.
+// If verified uploads are required, the blocks also need to be listed in every
+// bucket and pass the upload verification. The newest block of each stream is
+// always kept, as the import relies on it to skip days already imported.
+func (a *App) deleteUploadedBlocks(ctx context.Context, dests []destination) error {
+	if a.cfg.deleteUploadedAfter <= 0 && !a.cfg.deleteVerifiedUploads {
+		return nil
+	}
+
+	listed := make([]map[ulid.ULID]struct{}, len(dests))
+	if a.cfg.deleteVerifiedUploads {
+		for i, d := range dests {
+			var err error
+			listed[i], err = bucketBlocks(ctx, d.bkt)
+			if err != nil {
+				return fmt.Errorf("error listing %s bucket: %w", d, err)
+			}
+		}
+	}
+
+	streams, err := a.localStreams()
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(-a.cfg.deleteUploadedAfter)
+
+	for _, s := range streams {
+		metas, err := listBlocks(s.path)
+		if err != nil {
+			return err
+		}
+		if len(metas) == 0 {
+			continue
+		}
+
+		uploaded, err := a.uploadedToAll(s)
+		if err != nil {
+			return err
+		}
+
+		// keep the newest block
+		for _, m := range metas[:len(metas)-1] {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if _, ok := uploaded[m.ULID]; !ok {
+				continue
+			}
+			if ulid.Time(m.ULID.Time()).After(deadline) {
+				continue
+			}
+			if a.cfg.deleteVerifiedUploads && !a.verifiedInAll(ctx, dests, listed, s, m.ULID) {
+				continue
+			}
+
+			if err := os.RemoveAll(filepath.Join(s.path, m.ULID.String())); err != nil {
+				return err
+			}
+			_ = level.Info(a.logger).Log("msg", "deleted uploaded block", "path", s.path, "ulid", m.ULID)
+		}
+	}
+
+	return nil
+}
+
+// verifiedInAll checks that the block is listed in every destination and
+// passes the upload verification.
+func (a *App) verifiedInAll(ctx context.Context, dests []destination, listed []map[ulid.ULID]struct{}, s stream, id ulid.ULID) bool {
+	for i, d := range dests {
+		if _, ok := listed[i][id]; !ok {
+			_ = level.Warn(a.logger).Log("msg", "kept uploaded block, which is missing from the bucket", "path", s.path, "destination", d, "ulid", id)
+			return false
+		}
+		if err := a.verifyUploadedBlock(ctx, d.bkt, s.path, id); err != nil {
+			_ = level.Warn(a.logger).Log("msg", "kept uploaded block, which failed verification", "path", s.path, "destination", d, "ulid", id, "err", err)
+			return false
+		}
+	}
+	return true
+}
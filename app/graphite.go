@@ -0,0 +1,231 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+const (
+	defaultGraphitePath = "thames_water.{{ .Meter }}.{{ .Name }}"
+
+	// graphitePickleBatchSize is the number of samples per pickle message.
+	graphitePickleBatchSize = 500
+)
+
+// Graphite protocols.
+const (
+	GraphitePlaintext = "plaintext"
+	GraphitePickle    = "pickle"
+)
+
+// Graphite configures a Graphite carbon receiver, which receives all samples
+// imported by a run. Carbon accepts historical timestamps, so backfilled days
+// arrive as well.
+type Graphite struct {
+	// Address of the receiver, e.g. carbon:2003 for the plaintext or
+	// carbon:2004 for the pickle protocol.
+	Address  string
+	Protocol string
+	// Path is a template of the metric path. The metric name is available as
+	// {{ .Name }}, the meter as {{ .Meter }} and all labels as
+	// {{ .Labels.<name> }}. Empty path components are dropped.
+	Path string
+	// Timeout of connecting and sending.
+	Timeout time.Duration
+}
+
+// WithGraphite sends the imported samples to Graphite.
+func WithGraphite(g Graphite) NewOption {
+	return func(a *App) {
+		a.cfg.graphites = append(a.cfg.graphites, g)
+	}
+}
+
+type graphitePathData struct {
+	Name   string
+	Meter  string
+	Labels map[string]string
+}
+
+type graphiteSample struct {
+	path string
+	t    int64
+	v    float64
+}
+
+var graphiteInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+type graphiteSink struct {
+	logger log.Logger
+	cfg    Graphite
+	path   *template.Template
+
+	samples []graphiteSample
+	err     error
+}
+
+func newGraphiteSink(logger log.Logger, cfg Graphite) (*graphiteSink, error) {
+	if cfg.Protocol == "" {
+		cfg.Protocol = GraphitePlaintext
+	}
+	if cfg.Protocol != GraphitePlaintext && cfg.Protocol != GraphitePickle {
+		return nil, fmt.Errorf("invalid Graphite protocol '%s', expected %s or %s", cfg.Protocol, GraphitePlaintext, GraphitePickle)
+	}
+	if cfg.Path == "" {
+		cfg.Path = defaultGraphitePath
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	path, err := template.New("path").Option("missingkey=zero").Parse(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Graphite path: %w", err)
+	}
+	return &graphiteSink{
+		logger: logger,
+		cfg:    cfg,
+		path:   path,
+	}, nil
+}
+
+func (s *graphiteSink) Name() string {
+	return "Graphite " + s.cfg.Address
+}
+
+func (s *graphiteSink) Close() error {
+	return nil
+}
+
+// metricPath renders the path of the series. The label values are sanitized,
+// so they form a single path component each.
+func (s *graphiteSink) metricPath(lbls labels.Labels) (string, error) {
+	data := graphitePathData{Labels: make(map[string]string, len(lbls))}
+	for _, l := range lbls {
+		v := graphiteInvalidChars.ReplaceAllString(l.Value, "_")
+		switch l.Name {
+		case labels.MetricName:
+			data.Name = v
+		case "meter":
+			data.Meter = v
+		}
+		data.Labels[l.Name] = v
+	}
+
+	var buf bytes.Buffer
+	if err := s.path.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	var components []string
+	for _, c := range strings.Split(buf.String(), ".") {
+		if c != "" {
+			components = append(components, c)
+		}
+	}
+	return strings.Join(components, "."), nil
+}
+
+// AppendSample skips NaN values, which carbon doesn't store.
+func (s *graphiteSink) AppendSample(lbls labels.Labels, t int64, v float64) {
+	if math.IsNaN(v) || s.err != nil {
+		return
+	}
+	path, err := s.metricPath(lbls)
+	if err != nil {
+		s.err = fmt.Errorf("error rendering Graphite path of %s: %w", lbls, err)
+		return
+	}
+	s.samples = append(s.samples, graphiteSample{path: path, t: t, v: v})
+}
+
+func (s *graphiteSink) Flush(ctx context.Context) error {
+	if s.err != nil {
+		return s.err
+	}
+	if len(s.samples) == 0 {
+		return nil
+	}
+
+	d := net.Dialer{Timeout: s.cfg.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", s.cfg.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(s.cfg.Timeout))
+
+	w := bufio.NewWriter(conn)
+	if s.cfg.Protocol == GraphitePickle {
+		for pos := 0; pos < len(s.samples); pos += graphitePickleBatchSize {
+			end := pos + graphitePickleBatchSize
+			if end > len(s.samples) {
+				end = len(s.samples)
+			}
+			payload := graphitePickle(s.samples[pos:end])
+			var header [4]byte
+			binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+			if _, err := w.Write(header[:]); err != nil {
+				return err
+			}
+			if _, err := w.Write(payload); err != nil {
+				return err
+			}
+		}
+	} else {
+		for _, sample := range s.samples {
+			if _, err := fmt.Fprintf(w, "%s %s %d\n",
+				sample.path,
+				strconv.FormatFloat(sample.v, 'f', -1, 64),
+				sample.t/1000,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := conn.Close(); err != nil {
+		return err
+	}
+
+	_ = level.Info(s.logger).Log("msg", "sent samples to Graphite", "address", s.cfg.Address, "samples", len(s.samples))
+	s.samples = nil
+	return nil
+}
+
+// graphitePickle encodes the samples as pickled list of (path, (timestamp,
+// value)) tuples, which is what carbon's pickle receiver expects.
+func graphitePickle(samples []graphiteSample) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0x80, 2}) // PROTO 2
+	buf.WriteByte(']')         // EMPTY_LIST
+	buf.WriteByte('(')         // MARK
+	for _, sample := range samples {
+		buf.WriteByte('X') // BINUNICODE
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(len(sample.path)))
+		buf.WriteString(sample.path)
+		buf.WriteByte('G') // BINFLOAT
+		_ = binary.Write(&buf, binary.BigEndian, math.Float64bits(float64(sample.t)/1000))
+		buf.WriteByte('G') // BINFLOAT
+		_ = binary.Write(&buf, binary.BigEndian, math.Float64bits(sample.v))
+		buf.WriteByte(0x86) // TUPLE2
+		buf.WriteByte(0x86) // TUPLE2
+	}
+	buf.WriteByte('e') // APPENDS
+	buf.WriteByte('.') // STOP
+	return buf.Bytes()
+}
@@ -0,0 +1,151 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/simonswine/thames-water-importer/controlpb"
+)
+
+// controlServer implements the gRPC control API of the daemon.
+type controlServer struct {
+	controlpb.UnimplementedControlServer
+
+	app      *App
+	triggers chan<- string
+}
+
+func (s *controlServer) TriggerRun(_ context.Context, req *controlpb.TriggerRunRequest) (*controlpb.Run, error) {
+	dayRange, err := dayRangeRequest{Start: req.Start, End: req.End}.parse()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	info, err := s.app.triggerRun(s.triggers, dayRange)
+	switch {
+	case errors.Is(err, errStandby):
+		return nil, status.Error(codes.Unavailable, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return runToProto(info), nil
+}
+
+func (s *controlServer) GetRunStatus(_ context.Context, req *controlpb.GetRunStatusRequest) (*controlpb.Run, error) {
+	info, ok := s.app.runs.get(req.Id)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "run %s not found", req.Id)
+	}
+	return runToProto(info), nil
+}
+
+func (s *controlServer) ListRuns(context.Context, *controlpb.ListRunsRequest) (*controlpb.ListRunsResponse, error) {
+	var resp controlpb.ListRunsResponse
+	for _, info := range s.app.runs.list() {
+		resp.Runs = append(resp.Runs, runToProto(info))
+	}
+	return &resp, nil
+}
+
+func (s *controlServer) GetLatestReadings(ctx context.Context, req *controlpb.GetLatestReadingsRequest) (*controlpb.GetLatestReadingsResponse, error) {
+	metricName := req.MetricName
+	if metricName == "" {
+		metricName = consumptionMetricName
+	}
+
+	latest, err := s.app.latestSamples(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	var resp controlpb.GetLatestReadingsResponse
+	for _, l := range latest {
+		if l.lbls.Get(labels.MetricName) != metricName || (req.Meter != "" && l.lbls.Get("meter") != req.Meter) {
+			continue
+		}
+		resp.Samples = append(resp.Samples, &controlpb.Sample{
+			Labels:    l.lbls.Map(),
+			Timestamp: timestamppb.New(l.t),
+			Value:     l.v,
+		})
+	}
+	return &resp, nil
+}
+
+func runToProto(info RunInfo) *controlpb.Run {
+	run := &controlpb.Run{
+		Id:      info.ID,
+		Trigger: info.Trigger,
+		Status:  info.Status,
+		Queued:  timestamppb.New(info.Queued),
+	}
+	if info.Start != nil {
+		run.Start = info.Start.Format("2006-01-02")
+	}
+	if info.End != nil {
+		run.End = info.End.Format("2006-01-02")
+	}
+	if s := info.Summary; s != nil {
+		run.Summary = &controlpb.RunSummary{
+			Status:          s.Status,
+			DurationSeconds: s.DurationSeconds,
+			DaysImported:    int64(s.DaysImported),
+			Samples:         int64(s.Samples),
+			Error:           s.Error,
+			ErrorCategory:   s.ErrorCategory,
+			LoginPhase:      s.LoginPhase,
+		}
+		if !s.Start.IsZero() {
+			run.Summary.Start = timestamppb.New(s.Start)
+		}
+		for _, alert := range s.Alerts {
+			run.Summary.Alerts = append(run.Summary.Alerts, &controlpb.Alert{
+				Name:     alert.Name,
+				Meter:    alert.Meter,
+				Summary:  alert.Summary,
+				StartsAt: timestamppb.New(alert.StartsAt),
+				EndsAt:   timestamppb.New(alert.EndsAt),
+			})
+		}
+	}
+	return run
+}
+
+// tokenInterceptor requires the token as bearer token in the authorization
+// metadata.
+func tokenInterceptor(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if auth := md.Get("authorization"); len(auth) != 1 || !validBearerToken(auth[0], token) {
+			return nil, status.Error(codes.Unauthenticated, "invalid or missing token")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// serveGRPC serves the control API until the context is done.
+func (a *App) serveGRPC(ctx context.Context, listenAddress, token string, triggers chan<- string) error {
+	lis, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		return err
+	}
+
+	srv := grpc.NewServer(grpc.UnaryInterceptor(tokenInterceptor(token)))
+	controlpb.RegisterControlServer(srv, &controlServer{app: a, triggers: triggers})
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+
+	_ = level.Info(a.logger).Log("msg", "serving gRPC control API", "address", listenAddress)
+	return srv.Serve(lis)
+}
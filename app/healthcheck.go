@@ -0,0 +1,52 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// WithHealthcheckPingURL pings the URL at the start and end of every run,
+// following the protocol of Healthchecks.io: The start is signalled to
+// <url>/start, a success to <url> and a failure to <url>/fail.
+func WithHealthcheckPingURL(url string) NewOption {
+	return func(a *App) {
+		a.cfg.healthcheckPingURL = url
+	}
+}
+
+// pingHealthcheck sends a ping to the endpoint selected by the suffix. Failed
+// pings are only logged, as the missing ping is going to raise an alert
+// anyhow.
+func (a *App) pingHealthcheck(ctx context.Context, suffix string, body string) {
+	if a.cfg.healthcheckPingURL == "" {
+		return
+	}
+
+	u := strings.TrimSuffix(a.cfg.healthcheckPingURL, "/") + suffix
+	client := &http.Client{Timeout: 10 * time.Second}
+	if err := doWithRetries(ctx, log.With(a.logger, "url", u), client, 3, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		return req, nil
+	}); err != nil {
+		_ = level.Warn(a.logger).Log("msg", "error pinging healthcheck", "url", u, "err", err)
+	}
+}
+
+// pingHealthcheckResult signals the outcome of the run. The body of a failure
+// contains the error and the last log lines.
+func (a *App) pingHealthcheckResult(ctx context.Context, summary RunSummary) {
+	if summary.Status != RunStatusFailure {
+		a.pingHealthcheck(ctx, "", summaryText(summary))
+		return
+	}
+	a.pingHealthcheck(ctx, "/fail", summaryText(summary)+"\n"+strings.Join(summary.LastLogLines, "\n"))
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHomeAssistantDiscoveryPrefix = "homeassistant"
+	defaultMQTTStateTopic               = "thames-water/{{ .Meter }}/state"
+)
+
+// homeAssistantDevice groups the entities of a meter in Home Assistant.
+type homeAssistantDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+}
+
+// homeAssistantSensor is the discovery config of a MQTT sensor.
+type homeAssistantSensor struct {
+	Name              string `json:"name"`
+	UniqueID          string `json:"unique_id"`
+	ObjectID          string `json:"object_id"`
+	DeviceClass       string `json:"device_class"`
+	StateClass        string `json:"state_class"`
+	UnitOfMeasurement string `json:"unit_of_measurement"`
+	StateTopic        string `json:"state_topic"`
+	ValueTemplate     string `json:"value_template"`
+	// LastResetValueTemplate extracts the start of the cycle of a total
+	// sensor from the state.
+	LastResetValueTemplate string              `json:"last_reset_value_template,omitempty"`
+	Device                 homeAssistantDevice `json:"device"`
+}
+
+var homeAssistantInvalidID = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// homeAssistantState is the state of a meter's sensor, the consumption of a
+// day, which is reset at the start of the day.
+type homeAssistantState struct {
+	mqttDaily
+	LastReset time.Time `json:"last_reset"`
+}
+
+// homeAssistantMessages returns the discovery config of each meter's water
+// consumption sensor, followed by the consumption of every imported day as
+// its state, oldest first. Both are retained, so Home Assistant picks them up
+// after restarts. The sensor is a total water sensor in liters, which is
+// reset at the start of each day, so Home Assistant adds up the daily
+// consumption and the sensor can be added to the Energy dashboard.
+func (s *mqttSink) homeAssistantMessages(dailys []*mqttDaily) ([]mqttMessage, error) {
+	var (
+		meters   []string
+		perMeter = make(map[string][]*mqttDaily)
+	)
+	for _, d := range dailys {
+		if _, ok := perMeter[d.Meter]; !ok {
+			meters = append(meters, d.Meter)
+		}
+		perMeter[d.Meter] = append(perMeter[d.Meter], d)
+	}
+
+	var msgs []mqttMessage
+	for _, meter := range meters {
+		stateTopic, err := executeMeterTemplate(s.stateTopic, meter)
+		if err != nil {
+			return nil, err
+		}
+
+		id := "thames_water_" + strings.ToLower(homeAssistantInvalidID.ReplaceAllString(meter, "_"))
+		config, err := json.Marshal(homeAssistantSensor{
+			Name:                   "Water consumption",
+			UniqueID:               id + "_consumption",
+			ObjectID:               "water_consumption",
+			DeviceClass:            "water",
+			StateClass:             "total",
+			UnitOfMeasurement:      "L",
+			StateTopic:             stateTopic,
+			ValueTemplate:          "{{ value_json.usage }}",
+			LastResetValueTemplate: "{{ value_json.last_reset }}",
+			Device: homeAssistantDevice{
+				Identifiers:  []string{id},
+				Name:         "Thames Water meter " + meter,
+				Manufacturer: "Thames Water",
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, mqttMessage{
+			topic:   s.cfg.HomeAssistantDiscoveryPrefix + "/sensor/" + id + "/consumption/config",
+			payload: config,
+			retain:  true,
+		})
+
+		for _, d := range perMeter[meter] {
+			day, err := time.Parse("2006-01-02", d.Date)
+			if err != nil {
+				return nil, err
+			}
+			state, err := json.Marshal(homeAssistantState{mqttDaily: *d, LastReset: day})
+			if err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, mqttMessage{
+				topic:   stateTopic,
+				payload: state,
+				retain:  true,
+			})
+		}
+	}
+	return msgs, nil
+}
@@ -0,0 +1,353 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// homeAssistantStatisticsSource is the source of the external statistics, which
+// is also the prefix of their statistic IDs.
+const homeAssistantStatisticsSource = "thames_water"
+
+// HomeAssistant configures a Home Assistant instance, whose recorder receives
+// the imported readings as hourly long-term statistics. Unlike MQTT states,
+// these can be backfilled, so the Energy dashboard shows the consumption at
+// the time it happened. The statistics are named
+// thames_water:consumption_<meter>.
+type HomeAssistant struct {
+	// URL of Home Assistant, e.g. http://homeassistant:8123.
+	URL string
+	// Token is a long-lived access token.
+	Token string
+	// Timeout of the whole import.
+	Timeout time.Duration
+}
+
+// WithHomeAssistant imports the readings as long-term statistics into Home
+// Assistant.
+func WithHomeAssistant(ha HomeAssistant) NewOption {
+	return func(a *App) {
+		a.cfg.homeAssistants = append(a.cfg.homeAssistants, ha)
+	}
+}
+
+type homeAssistantStatisticsMetadata struct {
+	HasMean           bool   `json:"has_mean"`
+	HasSum            bool   `json:"has_sum"`
+	Name              string `json:"name"`
+	Source            string `json:"source"`
+	StatisticID       string `json:"statistic_id"`
+	UnitOfMeasurement string `json:"unit_of_measurement"`
+}
+
+type homeAssistantStatistic struct {
+	Start time.Time `json:"start"`
+	State float64   `json:"state"`
+	Sum   float64   `json:"sum"`
+}
+
+type homeAssistantMessage struct {
+	ID           int                              `json:"id,omitempty"`
+	Type         string                           `json:"type"`
+	AccessToken  string                           `json:"access_token,omitempty"`
+	Metadata     *homeAssistantStatisticsMetadata `json:"metadata,omitempty"`
+	Stats        []homeAssistantStatistic         `json:"stats,omitempty"`
+	StartTime    *time.Time                       `json:"start_time,omitempty"`
+	EndTime      *time.Time                       `json:"end_time,omitempty"`
+	StatisticIDs []string                         `json:"statistic_ids,omitempty"`
+	Period       string                           `json:"period,omitempty"`
+	Types        []string                         `json:"types,omitempty"`
+}
+
+type homeAssistantResponse struct {
+	ID      int             `json:"id"`
+	Type    string          `json:"type"`
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Result  json.RawMessage `json:"result"`
+	Error   *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// err returns the error of an unsuccessful response to the request.
+func (r *homeAssistantResponse) err(id int) error {
+	if r.ID == id && r.Success {
+		return nil
+	}
+	if r.Error != nil {
+		return errors.New(r.Error.Message)
+	}
+	return errors.New("unknown error")
+}
+
+// homeAssistantSumLookback is how far back the sum of a statistic is looked up,
+// before the hours imported continue it.
+const homeAssistantSumLookback = 10 * 365 * 24 * time.Hour
+
+type homeAssistantStatisticsSink struct {
+	logger log.Logger
+	cfg    HomeAssistant
+
+	readings []Reading
+}
+
+func newHomeAssistantStatisticsSink(logger log.Logger, cfg HomeAssistant) *homeAssistantStatisticsSink {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	return &homeAssistantStatisticsSink{
+		logger: logger,
+		cfg:    cfg,
+	}
+}
+
+func (s *homeAssistantStatisticsSink) Name() string {
+	return "Home Assistant " + s.cfg.URL
+}
+
+func (s *homeAssistantStatisticsSink) Close() error {
+	return nil
+}
+
+// AppendSample ignores the samples, the readings are received by
+// AppendReadings.
+func (s *homeAssistantStatisticsSink) AppendSample(labels.Labels, int64, float64) {}
+
+func (s *homeAssistantStatisticsSink) AppendReadings(readings []Reading) {
+	s.readings = append(s.readings, readings...)
+}
+
+// statistics groups the readings by meter into hourly statistics. The state is
+// the consumption within the hour, the sum is the running total of the
+// consumption, starting at zero before the first hour.
+func (s *homeAssistantStatisticsSink) statistics() map[string][]homeAssistantStatistic {
+	sort.SliceStable(s.readings, func(i, j int) bool {
+		return s.readings[i].Time.Before(s.readings[j].Time)
+	})
+
+	stats := make(map[string][]homeAssistantStatistic)
+	for _, r := range s.readings {
+		start := r.Time.UTC().Truncate(time.Hour)
+		meterStats := stats[r.Meter]
+		if n := len(meterStats); n > 0 && meterStats[n-1].Start.Equal(start) {
+			meterStats[n-1].State += r.Read
+			meterStats[n-1].Sum += r.Read
+			continue
+		}
+		var sum float64
+		if n := len(meterStats); n > 0 {
+			sum = meterStats[n-1].Sum
+		}
+		stats[r.Meter] = append(meterStats, homeAssistantStatistic{
+			Start: start,
+			State: r.Read,
+			Sum:   sum + r.Read,
+		})
+	}
+	return stats
+}
+
+// lastSum returns the sum of the statistic before the hour, so the imported
+// hours continue it. It is zero, if the statistic has no earlier hours.
+func (s *homeAssistantStatisticsSink) lastSum(c *homeAssistantConn, id int, statisticID string, before time.Time) (float64, error) {
+	start := before.Add(-homeAssistantSumLookback)
+	if err := c.write(homeAssistantMessage{
+		ID:           id,
+		Type:         "recorder/statistics_during_period",
+		StartTime:    &start,
+		EndTime:      &before,
+		StatisticIDs: []string{statisticID},
+		// the sum of a month is the sum of its last hour
+		Period: "month",
+		Types:  []string{"sum"},
+	}); err != nil {
+		return 0, err
+	}
+
+	var resp homeAssistantResponse
+	if err := c.read(&resp); err != nil {
+		return 0, err
+	}
+	if err := resp.err(id); err != nil {
+		return 0, err
+	}
+	var result map[string][]struct {
+		Sum *float64 `json:"sum"`
+	}
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return 0, err
+	}
+	periods := result[statisticID]
+	for i := len(periods) - 1; i >= 0; i-- {
+		if periods[i].Sum != nil {
+			return *periods[i].Sum, nil
+		}
+	}
+	return 0, nil
+}
+
+func (s *homeAssistantStatisticsSink) websocketURL() (string, error) {
+	u, err := url.Parse(s.cfg.URL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("invalid Home Assistant URL '%s', expected http:// or https://", s.cfg.URL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/websocket"
+	return u.String(), nil
+}
+
+// homeAssistantConn reads messages buffered during the handshake, before
+// reading from the connection.
+type homeAssistantConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (c *homeAssistantConn) read(v interface{}) error {
+	data, err := wsutil.ReadServerText(c)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (c *homeAssistantConn) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return wsutil.WriteClientText(c, data)
+}
+
+// connect opens the WebSocket API and authenticates.
+func (s *homeAssistantStatisticsSink) connect(ctx context.Context) (net.Conn, *homeAssistantConn, error) {
+	u, err := s.websocketURL()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, br, _, err := ws.Dialer{}.Dial(ctx, u)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error connecting to Home Assistant: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c := &homeAssistantConn{Reader: conn, Writer: conn}
+	if br != nil {
+		c.Reader = io.MultiReader(br, conn)
+	}
+
+	var resp homeAssistantResponse
+	if err := c.read(&resp); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.Type != "auth_required" {
+		conn.Close()
+		return nil, nil, fmt.Errorf("unexpected message %s, expected auth_required", resp.Type)
+	}
+	if err := c.write(homeAssistantMessage{Type: "auth", AccessToken: s.cfg.Token}); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if err := c.read(&resp); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.Type != "auth_ok" {
+		conn.Close()
+		return nil, nil, fmt.Errorf("authentication failed: %s", resp.Message)
+	}
+
+	return conn, c, nil
+}
+
+func (s *homeAssistantStatisticsSink) Flush(ctx context.Context) error {
+	if len(s.readings) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	conn, c, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stats := s.statistics()
+	meters := make([]string, 0, len(stats))
+	for meter := range stats {
+		meters = append(meters, meter)
+	}
+	sort.Strings(meters)
+
+	var id int
+	for _, meter := range meters {
+		statisticID := homeAssistantStatisticsSource + ":consumption_" + strings.ToLower(homeAssistantInvalidID.ReplaceAllString(meter, "_"))
+		meterStats := stats[meter]
+
+		// continue the sum of the hours imported before
+		id++
+		sum, err := s.lastSum(c, id, statisticID, meterStats[0].Start)
+		if err != nil {
+			return fmt.Errorf("error reading statistics of meter %s: %w", meter, err)
+		}
+		for i := range meterStats {
+			meterStats[i].Sum += sum
+		}
+
+		id++
+		if err := c.write(homeAssistantMessage{
+			ID:   id,
+			Type: "recorder/import_statistics",
+			Metadata: &homeAssistantStatisticsMetadata{
+				HasSum:            true,
+				Name:              "Water consumption " + meter,
+				Source:            homeAssistantStatisticsSource,
+				StatisticID:       statisticID,
+				UnitOfMeasurement: "L",
+			},
+			Stats: meterStats,
+		}); err != nil {
+			return err
+		}
+
+		var resp homeAssistantResponse
+		if err := c.read(&resp); err != nil {
+			return err
+		}
+		if err := resp.err(id); err != nil {
+			return fmt.Errorf("error importing statistics of meter %s: %w", meter, err)
+		}
+	}
+
+	_ = level.Info(s.logger).Log("msg", "imported statistics into Home Assistant", "url", s.cfg.URL, "readings", len(s.readings))
+	s.readings = nil
+	return nil
+}
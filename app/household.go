@@ -0,0 +1,359 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/model/labels"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v2"
+)
+
+// householdLabel tells the series and the importer's own metrics of the
+// households apart.
+const householdLabel = "household"
+
+// Household is an account imported by a process importing several
+// households, e.g. those of family members.
+type Household struct {
+	// Name identifies the household. It is added as household label to all
+	// series and is the default of the TSDB path and the bucket prefix.
+	Name string `yaml:"name"`
+	// Provider is either thames-water, the default, or anglian-water.
+	Provider     string `yaml:"provider"`
+	Email        string `yaml:"email"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	// ExternalLabels are added to the external labels of the process.
+	ExternalLabels map[string]string `yaml:"external_labels"`
+	// TSDBPath of the household, relative to the TSDB path of the process.
+	TSDBPath string `yaml:"tsdb_path"`
+	// BucketPrefix the blocks of the household are uploaded below.
+	BucketPrefix string `yaml:"bucket_prefix"`
+	// ThanosBucketConfigFile uploads to another bucket than the one of the
+	// process.
+	ThanosBucketConfigFile string `yaml:"thanos_bucket_config_file"`
+	// Interval between two runs of the daemon, overriding its interval.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// LoadHouseholds reads the households from a YAML file of the form:
+//
+//	households:
+//	  - name: parents
+//	    email: parents@example.com
+//	    password_file: /run/secrets/parents
+//	    external_labels:
+//	      city: london
+//	  - name: sister
+//	    provider: anglian-water
+//	    email: sister@example.com
+//	    password_file: /run/secrets/sister
+//	    interval: 12h
+func LoadHouseholds(path string) ([]Household, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Households []Household `yaml:"households"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	if len(file.Households) == 0 {
+		return nil, errors.New("no households configured")
+	}
+
+	seen := make(map[string]struct{}, len(file.Households))
+	for pos := range file.Households {
+		h := &file.Households[pos]
+		if err := h.validate(); err != nil {
+			return nil, fmt.Errorf("invalid household %d: %w", pos+1, err)
+		}
+		if _, ok := seen[h.Name]; ok {
+			return nil, fmt.Errorf("duplicate household %s", h.Name)
+		}
+		seen[h.Name] = struct{}{}
+	}
+	return file.Households, nil
+}
+
+// validate checks the household and reads its password file.
+func (h *Household) validate() error {
+	if h.Name == "" {
+		return errors.New("missing name")
+	}
+	if h.Name != filepath.Base(h.Name) || strings.HasPrefix(h.Name, ".") {
+		return fmt.Errorf("name %s can't be used as directory name", h.Name)
+	}
+	if h.Provider == "" {
+		h.Provider = "thames-water"
+	}
+	if h.Provider != "thames-water" && h.Provider != "anglian-water" {
+		return fmt.Errorf("unknown provider '%s' of household %s", h.Provider, h.Name)
+	}
+	if h.PasswordFile != "" {
+		if h.Password != "" {
+			return fmt.Errorf("household %s sets both password and password_file", h.Name)
+		}
+		data, err := os.ReadFile(h.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("error reading password file of household %s: %w", h.Name, err)
+		}
+		h.Password = strings.TrimSpace(string(data))
+	}
+	if h.Email == "" || h.Password == "" {
+		return fmt.Errorf("household %s needs an email and a password", h.Name)
+	}
+	if h.TSDBPath == "" {
+		h.TSDBPath = h.Name
+	}
+	if filepath.IsAbs(h.TSDBPath) || strings.HasPrefix(filepath.Clean(h.TSDBPath), "..") {
+		return fmt.Errorf("TSDB path %s of household %s needs to be below the TSDB path", h.TSDBPath, h.Name)
+	}
+	if h.BucketPrefix == "" {
+		h.BucketPrefix = h.Name
+	}
+	if h.Interval < 0 {
+		return fmt.Errorf("invalid interval %s of household %s", h.Interval, h.Name)
+	}
+	return nil
+}
+
+// options returns the options of the household, which are applied on top of
+// the options of the process.
+func (h Household) options() []NewOption {
+	opts := []NewOption{
+		func(a *App) {
+			a.logger = log.With(a.logger, householdLabel, h.Name)
+			a.cfg.tsdbPath = filepath.Join(a.cfg.tsdbPath, h.TSDBPath)
+			a.cfg.noSystemdNotify = true
+
+			processLabels := a.cfg.externalLabels
+			a.cfg.externalLabels = func() labels.Labels {
+				b := labels.NewBuilder(processLabels())
+				for name, value := range h.ExternalLabels {
+					b.Set(name, value)
+				}
+				b.Set(householdLabel, h.Name)
+				return b.Labels()
+			}
+		},
+		WithThanosBucketPrefix(h.BucketPrefix),
+	}
+	if h.ThanosBucketConfigFile != "" {
+		opts = append(opts, WithThanosBucketConfigFile(h.ThanosBucketConfigFile))
+	}
+
+	switch h.Provider {
+	case "anglian-water":
+		opts = append(opts, WithAnglianWater(AnglianWater{
+			Email:    h.Email,
+			Password: h.Password,
+		}))
+	default:
+		opts = append(opts, WithThamesWaterLogin(h.Email, h.Password))
+	}
+	return opts
+}
+
+// Households imports several households in one process. Every household is
+// an App of its own, with its own TSDB, lock, schedule and metrics, so a
+// failing household doesn't hold up the others.
+type Households struct {
+	logger     log.Logger
+	households []Household
+	apps       []*App
+}
+
+// NewHouseholds creates the Apps of the households from the options of the
+// process and those of each household. Options writing to a single file, like
+// WithRunHistory or WithAuditLog, are shared by all households.
+func NewHouseholds(households []Household, opts ...NewOption) *Households {
+	h := &Households{
+		logger:     New(opts...).logger,
+		households: households,
+	}
+	for _, household := range households {
+		h.apps = append(h.apps, New(append(opts[:len(opts):len(opts)], household.options()...)...))
+	}
+	return h
+}
+
+// Run runs an import of every household, one after another. The households
+// after a failed one are still imported, the errors are returned together.
+func (h *Households) Run(ctx context.Context) error {
+	var failed []string
+	for pos, a := range h.apps {
+		name := h.households[pos].Name
+		if err := a.Run(ctx); err != nil {
+			_ = level.Error(h.logger).Log("msg", "run failed", householdLabel, name, "err", err)
+			failed = append(failed, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("runs of %d of %d households failed: %s", len(failed), len(h.apps), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Daemon runs the imports of every household on its own schedule, until the
+// context is done. The metrics of all households are served on /metrics with
+// a household label, their web UIs below /households/<name>/. /readyz reports
+// ready, once every household has finished a successful run within the
+// ReadyMaxAge. The API, the gRPC control API and the leader election aren't
+// supported.
+func (h *Households) Daemon(ctx context.Context, cfg DaemonConfig) error {
+	if cfg.APIToken != "" || cfg.GRPCListenAddress != "" || cfg.LeaderElection != nil {
+		return errors.New("the API, the gRPC control API and the leader election aren't supported with households")
+	}
+	for pos, a := range h.apps {
+		if err := a.validateConfig(); err != nil {
+			return fmt.Errorf("invalid config of household %s: %w", h.households[pos].Name, err)
+		}
+	}
+
+	mux := h.apps[0].httpHandler(householdGatherer{h})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		h.readyz(w, r, cfg.ReadyMaxAge)
+	})
+	if !cfg.DisableWebUI {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = fmt.Fprint(w, "<!DOCTYPE html>\n<title>Households</title>\n<ul>\n")
+			for _, household := range h.households {
+				name := html.EscapeString(household.Name)
+				_, _ = fmt.Fprintf(w, "<li><a href=\"households/%s/\">%s</a></li>\n", name, name)
+			}
+			_, _ = fmt.Fprint(w, "</ul>\n")
+		})
+		for pos, a := range h.apps {
+			prefix := "/households/" + h.households[pos].Name
+			mux.Handle(prefix+"/", http.StripPrefix(prefix, a.uiHandler()))
+		}
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		_ = level.Info(h.logger).Log("msg", "serving metrics", "address", cfg.ListenAddress, "households", len(h.apps))
+		return h.apps[0].serveHTTP(ctx, cfg.ListenAddress, mux)
+	})
+	h.sdNotify(daemon.SdNotifyReady)
+	defer h.sdNotify(daemon.SdNotifyStopping)
+
+	for pos, a := range h.apps {
+		a := a
+		interval := cfg.Interval
+		if i := h.households[pos].Interval; i > 0 {
+			interval = i
+		}
+		if cfg.ConfigReloadInterval > 0 {
+			go a.watchConfigFiles(ctx, cfg.ConfigReloadInterval)
+		}
+		g.Go(func() error {
+			a.runEvery(ctx, interval, nil)
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+// Reload reloads the config files of all households.
+func (h *Households) Reload() error {
+	var err error
+	for _, a := range h.apps {
+		if reloadErr := a.Reload(); reloadErr != nil && err == nil {
+			err = reloadErr
+		}
+	}
+	return err
+}
+
+func (h *Households) readyz(w http.ResponseWriter, _ *http.Request, maxAge time.Duration) {
+	var notReady []string
+	for pos, a := range h.apps {
+		if last := a.lastSuccess(); last.IsZero() || time.Since(last) > maxAge {
+			notReady = append(notReady, h.households[pos].Name)
+		}
+	}
+	if len(notReady) > 0 {
+		http.Error(w, fmt.Sprintf("no successful run within %s for households %s", maxAge, strings.Join(notReady, ", ")), http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "ok, %d households\n", len(h.apps))
+}
+
+// sdNotify notifies systemd for the whole process, as the households don't.
+func (h *Households) sdNotify(state string) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		_ = level.Debug(h.logger).Log("msg", "error notifying systemd", "state", state, "err", err)
+	}
+}
+
+// householdGatherer gathers the metrics of all households and tells them
+// apart by the household label.
+type householdGatherer struct {
+	h *Households
+}
+
+func (g householdGatherer) Gather() ([]*dto.MetricFamily, error) {
+	var (
+		families = make(map[string]*dto.MetricFamily)
+		errs     prometheus.MultiError
+	)
+	for pos, a := range g.h.apps {
+		name := g.h.households[pos].Name
+		mfs, err := a.reg.Gather()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("household %s: %w", name, err))
+		}
+		for _, mf := range mfs {
+			for _, m := range mf.Metric {
+				m.Label = append(m.Label, &dto.LabelPair{
+					Name:  stringPtr(householdLabel),
+					Value: stringPtr(name),
+				})
+				sort.Slice(m.Label, func(i, j int) bool {
+					return m.Label[i].GetName() < m.Label[j].GetName()
+				})
+			}
+			if f, ok := families[mf.GetName()]; ok {
+				f.Metric = append(f.Metric, mf.Metric...)
+				continue
+			}
+			families[mf.GetName()] = mf
+		}
+	}
+
+	result := make([]*dto.MetricFamily, 0, len(families))
+	for _, mf := range families {
+		result = append(result, mf)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetName() < result[j].GetName()
+	})
+	return result, errs.MaybeUnwrap()
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	retry "github.com/avast/retry-go/v4"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// doWithRetries sends the request built by newReq and retries it with an
+// exponential backoff. Client errors other than 429 are not retried.
+func doWithRetries(ctx context.Context, logger log.Logger, client *http.Client, retries int, newReq func() (*http.Request, error)) error {
+	return retry.Do(
+		func() error {
+			req, err := newReq()
+			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+			req.Header.Set("User-Agent", "thames-water-importer")
+
+			resp, err := client.Do(req)
+			if err != nil {
+				// drop the URL from the error, as the URLs of chat
+				// webhooks and bots contain secrets
+				var urlErr *url.Error
+				if errors.As(err, &urlErr) {
+					return fmt.Errorf("%s request failed: %w", req.Method, urlErr.Err)
+				}
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode/100 == 2 {
+				_, _ = io.Copy(io.Discard, resp.Body)
+				return nil
+			}
+
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+			if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+				return retry.Unrecoverable(err)
+			}
+			return err
+		},
+		retry.Context(ctx),
+		retry.Attempts(uint(retries)+1),
+		retry.Delay(time.Second),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+		retry.OnRetry(func(n uint, err error) {
+			_ = level.Warn(logger).Log("msg", "request failed", "err", err, "try", n+1)
+		}),
+	)
+}
@@ -0,0 +1,376 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func (a *App) openTSDB(s stream) (*tsdb.DB, error) {
+	options := tsdb.DefaultOptions()
+
+	// set retention, blocks which are never uploaded are kept, like the
+	// history of a backfill until it is uploaded
+	options.RetentionDuration = 0
+	if !a.cfg.noUpload && !a.backfillHistory {
+		options.RetentionDuration = a.cfg.tsdbRetention.Milliseconds()
+	}
+
+	options.MinBlockDuration = a.cfg.tsdbBlockDuration.Milliseconds()
+	options.MaxBlockDuration = a.cfg.tsdbBlockDuration.Milliseconds()
+
+	// set head and memory tuning
+	options.MaxBytes = a.cfg.tsdbMaxBytes
+	options.StripeSize = a.cfg.tsdbStripeSize
+	options.HeadChunksWriteBufferSize = a.cfg.tsdbHeadChunksWriteBufferSize
+	options.WALCompression = a.cfg.tsdbWALCompression
+	a.applyMemoryBudget(options)
+
+	return tsdb.Open(s.path, &logLevelOverride{next: a.logger, level: level.DebugValue()}, a.streamRegisterer(s), options, nil)
+}
+
+// login logs into the account of the provider, retrying on failures, and
+// returns the provider together with the account number.
+func (a *App) login(ctx context.Context) (_ Provider, _ string, err error) {
+	ctx, span := a.startSpan(ctx, "login")
+	defer func() { endSpan(span, err) }()
+	a.startPhase("login")
+
+	var (
+		provider      = a.newProvider()
+		accountNumber string
+	)
+
+	if err := a.retry(ctx, PhaseLogin, func() error {
+		if err := a.waitRequest(ctx); err != nil {
+			return err
+		}
+		var err error
+		accountNumber, err = provider.Login(ctx)
+		return err
+	}); err != nil {
+		a.closeProvider(provider)
+		return nil, "", withCategory(ErrorCategoryLogin, fmt.Errorf("error logging into %s: %w", provider.Name(), err))
+	}
+
+	return provider, accountNumber, nil
+}
+
+// importConsumption imports the new readings into the local TSDB and all
+// other sinks.
+func (a *App) importConsumption(ctx context.Context) error {
+	provider, accountNumber, err := a.login(ctx)
+	if err != nil {
+		return err
+	}
+	defer a.closeProvider(provider)
+
+	a.startPhase("import")
+	resp, err := a.listMeters(ctx, provider)
+	if err != nil {
+		return withCategory(ErrorCategoryThamesWaterAPI, err)
+	}
+
+	if len(resp.Meters) == 0 {
+		return fmt.Errorf("no meters found")
+	}
+
+	_ = level.Info(a.logger).Log("msg", "found meters", "meters", strings.Join(resp.Meters, ", "))
+	if skipped := resp.Meters[len(a.importMeters(resp.Meters)):]; len(skipped) > 0 {
+		_ = level.Warn(a.logger).Log("msg", "only the first meter is imported, enable per-meter streams to import all meters", "skipped_meters", strings.Join(skipped, ", "))
+	}
+
+	days := filterDays(ctx, resp.Days)
+
+	a.sinks, err = a.newSinks()
+	if err != nil {
+		return err
+	}
+	// the local TSDB is flushed last, so its workspaces are only committed
+	// once all other sinks have received the samples
+	local := &localTSDBSink{app: a}
+	a.sinks = append(a.sinks, local)
+	defer a.closeSinks()
+
+	// import into per-run workspaces, which are only committed once all
+	// streams have been imported successfully
+	for _, s := range a.importStreams(resp.Meters) {
+		w, err := a.newWorkspace(s)
+		if err != nil {
+			return withCategory(ErrorCategoryLocalTSDB, fmt.Errorf("error preparing workspace: %w", err))
+		}
+		local.workspaces = append(local.workspaces, w)
+
+		if err := a.importStream(ctx, provider, w.stream, days, accountNumber); err != nil {
+			return err
+		}
+	}
+
+	a.sdStatus("flushing sinks")
+	a.startPhase("flush")
+	return a.flushSinks(ctx)
+}
+
+// importStream fetches the readings of the stream's meters for every day and
+// appends them to the stream's TSDB. During a backfill, the days before the
+// stream's oldest block are imported into blocks of their own first.
+func (a *App) importStream(ctx context.Context, provider Provider, s stream, days []time.Time, accountNumber string) error {
+	if a.backfillHistory {
+		history, err := historyDays(s, days)
+		if err != nil {
+			return fmt.Errorf("error reading blocks of %s: %w", s.path, err)
+		}
+		if len(history) > 0 {
+			if err := a.importHistory(ctx, provider, s, history, accountNumber); err != nil {
+				return err
+			}
+		}
+	}
+
+	db, err := a.openTSDB(s)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var (
+		minTime, maxTime time.Time
+	)
+	if mT, init := db.Head().AppendableMinValidTime(); init {
+		// samples at the min valid time can still be appended, like the
+		// first day after the blocks the head has been initialized from
+		minTime = timestamp.Time(mT - 1)
+		maxTime = timestamp.Time(db.Head().MaxTime())
+	} else if blocks := db.Blocks(); len(blocks) > 0 {
+		// block intervals are half-open, so the last block can contain
+		// samples up to one millisecond before its max time
+		minTime = timestamp.Time(blocks[len(blocks)-1].Meta().MaxTime - 1)
+		maxTime = minTime
+	}
+	if !minTime.IsZero() {
+		_ = level.Debug(a.logger).Log("msg", "opened TSDB",
+			"path", s.path,
+			"min_time", minTime,
+			"max_time", maxTime,
+		)
+	}
+
+	return a.importDays(ctx, provider, db, s, days, minTime, accountNumber)
+}
+
+// importDays fetches the readings of the stream's meters for the days after
+// minTime, appends them to the TSDB and persists its head.
+func (a *App) importDays(ctx context.Context, provider Provider, db *tsdb.DB, s stream, days []time.Time, minTime time.Time, accountNumber string) error {
+	lbls := a.seriesLabels(s.labels, accountNumber)
+
+	var firstDay time.Time
+	if len(days) > 0 {
+		firstDay = days[0]
+	}
+
+	// count the days to import, to report the progress per meter
+	var daysTotal int
+	for _, day := range days {
+		if minTime.Before(day) {
+			daysTotal++
+		}
+	}
+	daysDone := make(map[string]int, len(s.meters))
+
+	var headSamplesFlushed int
+	if a.stats != nil {
+		headSamplesFlushed = a.stats.samples
+	}
+
+	for _, day := range days {
+		for _, meter := range s.meters {
+			if !minTime.Before(day) {
+				_ = level.Debug(a.logger).Log("msg", "skipped daily reading, as TSDB already contains data", "meter", meter, "date", day.Format("2006-01-02"))
+				continue
+			}
+			_ = level.Debug(a.logger).Log("msg", "daily reading", "meter", meter, "date", day.Format("2006-01-02"))
+			a.sdStatus("importing " + day.Format("2006-01-02"))
+
+			fetchCtx, span := a.startSpan(ctx, "fetch day",
+				attribute.String("meter", meter),
+				attribute.String("date", day.Format("2006-01-02")),
+			)
+			readings, err := a.getConsumption(fetchCtx, provider, meter, day)
+			endSpan(span, err)
+			if err != nil {
+				return withCategory(ErrorCategoryThamesWaterAPI, err)
+			}
+			a.metrics.daysFetched.WithLabelValues(meter).Inc()
+			if a.stats != nil {
+				a.stats.days[day] = struct{}{}
+				a.stats.readings = append(a.stats.readings, readings...)
+			}
+
+			if err := a.appendReadings(ctx, db, lbls, readings, accountNumber); err != nil {
+				return err
+			}
+			daysDone[meter]++
+			a.reportProgress(Progress{
+				Type:      ProgressDay,
+				Phase:     "import",
+				Day:       day,
+				Meter:     meter,
+				DaysDone:  daysDone[meter],
+				DaysTotal: daysTotal,
+			})
+		}
+
+		if !minTime.Before(day) {
+			continue
+		}
+		if err := a.appendAggregates(ctx, db, day, firstDay); err != nil {
+			return err
+		}
+
+		// persist the head during long backfills, to stay within the
+		// memory budget
+		if limit := a.headSampleLimit(); limit > 0 && a.stats != nil && a.stats.samples-headSamplesFlushed >= limit {
+			if err := a.compactHeadBefore(db, truncateDay(day).AddDate(0, 0, 1)); err != nil {
+				return fmt.Errorf("error persisting head: %w", err)
+			}
+			headSamplesFlushed = a.stats.samples
+		}
+	}
+
+	_, span := a.startSpan(ctx, "compaction", attribute.String("path", s.path))
+	err := a.flushHead(db)
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("error during compaction: %w", err)
+	}
+	_ = level.Debug(a.logger).Log("msg", "ran TSDB compaction", "path", s.path)
+
+	return nil
+}
+
+// appendReadings appends the samples of the readings and commits them.
+func (a *App) appendReadings(ctx context.Context, db *tsdb.DB, lbls labels.Labels, readings []Reading, accountNumber string) (err error) {
+	ctx, span := a.startSpan(ctx, "append", attribute.Int("readings", len(readings)))
+	defer func() { endSpan(span, err) }()
+
+	readings, err = a.dedupReadings(readings)
+	if err != nil {
+		return err
+	}
+	readings = a.filterReadings(readings)
+
+	batchSize := a.commitBatchSize()
+	if batchSize == 0 {
+		batchSize = len(readings)
+	}
+	for start := 0; start < len(readings); start += batchSize {
+		end := start + batchSize
+		if end > len(readings) {
+			end = len(readings)
+		}
+		batch := readings[start:end]
+
+		// get new appender to TSDB, which skips the samples stored already
+		appender := a.duplicateAppender(ctx, db, a.appender(ctx, db), batch)
+		if err := a.appendReadingSamples(appender, lbls, batch, accountNumber, start == 0); err != nil {
+			return err
+		}
+
+		if err := appender.Commit(); err != nil {
+			return err
+		}
+		a.appendReadingsToSinks(a.relabelReadings(lbls, batch))
+	}
+
+	return nil
+}
+
+// seriesLabels returns the labels of the consumption series of a stream,
+// before the meter labels are added.
+func (a *App) seriesLabels(streamLabels labels.Labels, accountNumber string) labels.Labels {
+	lbls := labels.NewBuilder(a.externalLabels())
+	for _, l := range streamLabels {
+		lbls.Set(l.Name, l.Value)
+	}
+	if a.cfg.jobLabel != "" {
+		lbls.Set("job", a.cfg.jobLabel)
+	}
+	lbls.Set(labels.MetricName, consumptionMetricName)
+	if a.cfg.accountLabel {
+		lbls.Set("account", accountNumber)
+	}
+	return lbls.Labels()
+}
+
+// meterSeriesLabels returns the labels of the consumption series of the
+// meter.
+func (a *App) meterSeriesLabels(lbls labels.Labels, meter string) labels.Labels {
+	b := labels.NewBuilder(lbls)
+	for _, l := range a.resolved.meterLabels[meter] {
+		b.Set(l.Name, l.Value)
+	}
+	b.Set("meter", meter)
+	return b.Labels()
+}
+
+// appendReadingSamples appends the samples derived from the readings of a
+// single day and meter, including costs, emissions and the markers of
+// estimated readings, without committing them. The standing charge is added to
+// the first reading, if the readings start the day. The series are relabeled
+// by the relabel configs.
+func (a *App) appendReadingSamples(appender storage.Appender, lbls labels.Labels, readings []Reading, accountNumber string, startOfDay bool) error {
+	appender = a.relabelAppender(appender)
+	for pos, r := range readings {
+		meterLbls := labels.NewBuilder(a.meterSeriesLabels(lbls, r.Meter))
+		if _, err := appender.Append(
+			0,
+			meterLbls.Labels(),
+			timestamp.FromTime(r.Time),
+			r.Read,
+		); err != nil {
+			return err
+		}
+
+		if r.Estimated {
+			estimatedLbls := labels.NewBuilder(meterLbls.Labels())
+			estimatedLbls.Set(labels.MetricName, estimatedMetricName)
+			if _, err := appender.Append(
+				0,
+				estimatedLbls.Labels(),
+				timestamp.FromTime(r.Time),
+				1,
+			); err != nil {
+				return err
+			}
+		}
+
+		if err := a.resolved.tariffs.appendCosts(appender, meterLbls.Labels(), r, startOfDay && pos == 0); err != nil {
+			return err
+		}
+		if err := a.resolved.emissions.appendEmissions(appender, meterLbls.Labels(), r); err != nil {
+			return err
+		}
+
+		if a.cfg.accountInfoSeries {
+			meterLbls.Set(labels.MetricName, accountInfoMetricName)
+			meterLbls.Set("account", accountNumber)
+			if _, err := appender.Append(
+				0,
+				meterLbls.Labels(),
+				timestamp.FromTime(r.Time),
+				1,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// Importer fetches the readings from the provider for programs embedding the
+// importer. Unlike the App, it neither keeps state in the local TSDB nor
+// uploads blocks, notifies or reports to systemd. It takes the same options,
+// e.g. WithThamesWaterLogin, WithAnglianWater, WithLogger, WithExternalLabels
+// or WithTariffFile, while the options of the local TSDB, the buckets and the
+// sinks have no effect.
+type Importer struct {
+	app *App
+}
+
+// NewImporter returns an Importer configured by the options.
+func NewImporter(opts ...NewOption) *Importer {
+	a := New(opts...)
+	a.cfg.noSystemdNotify = true
+	return &Importer{app: a}
+}
+
+// Gatherer returns the importer's own metrics, like the duration of the
+// requests to Thames Water, to be exposed by the embedding program.
+func (i *Importer) Gatherer() prometheus.Gatherer {
+	return i.app.reg
+}
+
+// FetchReadings logs into the account of the provider and fetches the readings of
+// all meters for the available days within [from, to).
+func (i *Importer) FetchReadings(ctx context.Context, from, to time.Time) ([]Reading, error) {
+	return i.app.FetchReadings(ctx, from, to)
+}
+
+// WriteTo fetches the readings within [from, to) and writes the samples
+// derived from them to the sink, like a run writes them to the local TSDB,
+// including costs and emissions. Sinks implementing ReadingSink receive the
+// readings as well. The sink is flushed once per day and meter, but not
+// closed.
+func (i *Importer) WriteTo(ctx context.Context, sink Sink, from, to time.Time) error {
+	a := i.app
+	if err := a.validateConfig(); err != nil {
+		return err
+	}
+
+	return a.fetchDays(ctx, from, to, func(accountNumber string, readings []Reading) error {
+		readings = a.filterReadings(readings)
+		lbls := a.seriesLabels(nil, accountNumber)
+		appender := &sampleAppender{sink: sink}
+		if err := a.appendReadingSamples(appender, lbls, readings, accountNumber, true); err != nil {
+			return err
+		}
+		if err := appender.Commit(); err != nil {
+			return err
+		}
+		if rs, ok := sink.(ReadingSink); ok {
+			rs.AppendReadings(a.relabelReadings(lbls, readings))
+		}
+		if err := sink.Flush(ctx); err != nil {
+			return fmt.Errorf("error flushing %s: %w", sink.Name(), err)
+		}
+		return nil
+	})
+}
+
+// sampleAppender passes the committed samples to a sink, without storing
+// them.
+type sampleAppender struct {
+	sink    Sink
+	pending []sinkSample
+}
+
+func (s *sampleAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	s.pending = append(s.pending, sinkSample{lbls: l, t: t, v: v})
+	return ref, nil
+}
+
+func (s *sampleAppender) AppendExemplar(ref storage.SeriesRef, _ labels.Labels, _ exemplar.Exemplar) (storage.SeriesRef, error) {
+	return ref, nil
+}
+
+func (s *sampleAppender) Commit() error {
+	for _, p := range s.pending {
+		s.sink.AppendSample(p.lbls, p.t, p.v)
+	}
+	s.pending = nil
+	return nil
+}
+
+func (s *sampleAppender) Rollback() error {
+	s.pending = nil
+	return nil
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// InfluxDB configures an InfluxDB v2 bucket, which receives all samples
+// imported by a run. Every series becomes a measurement named after the
+// metric, with the remaining labels as tags and the sample in the value
+// field.
+type InfluxDB struct {
+	// URL of the InfluxDB server, e.g. http://influxdb:8086.
+	URL    string
+	Org    string
+	Bucket string
+	Token  string
+	// Timeout of a single request.
+	Timeout time.Duration
+	// BatchSize is the maximum number of lines sent per request.
+	BatchSize int
+	// Retries of a failed request.
+	Retries int
+}
+
+// WithInfluxDB sends the imported samples to InfluxDB.
+func WithInfluxDB(db InfluxDB) NewOption {
+	return func(a *App) {
+		a.cfg.influxDBs = append(a.cfg.influxDBs, db)
+	}
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// influxLine formats the sample in the line protocol. The timestamp is
+// written as is, so its precision is up to the caller.
+func influxLine(lbls labels.Labels, t int64, v float64) string {
+	var sb strings.Builder
+	sb.WriteString(influxMeasurementEscaper.Replace(lbls.Get(labels.MetricName)))
+	for _, l := range lbls {
+		if l.Name == labels.MetricName || l.Value == "" {
+			continue
+		}
+		sb.WriteByte(',')
+		sb.WriteString(influxTagEscaper.Replace(l.Name))
+		sb.WriteByte('=')
+		sb.WriteString(influxTagEscaper.Replace(l.Value))
+	}
+	sb.WriteString(" value=")
+	sb.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatInt(t, 10))
+	return sb.String()
+}
+
+type influxDBSink struct {
+	logger log.Logger
+	cfg    InfluxDB
+	client *http.Client
+
+	lines []string
+}
+
+func newInfluxDBSink(logger log.Logger, cfg InfluxDB) *influxDBSink {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 5000
+	}
+	return &influxDBSink{
+		logger: logger,
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+func (s *influxDBSink) Name() string {
+	return "InfluxDB " + s.cfg.URL
+}
+
+func (s *influxDBSink) Close() error {
+	return nil
+}
+
+// AppendSample skips NaN values, which InfluxDB doesn't support.
+func (s *influxDBSink) AppendSample(lbls labels.Labels, t int64, v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	s.lines = append(s.lines, influxLine(lbls, t, v))
+}
+
+func (s *influxDBSink) writeURL() string {
+	params := url.Values{}
+	params.Set("org", s.cfg.Org)
+	params.Set("bucket", s.cfg.Bucket)
+	params.Set("precision", "ms")
+	return strings.TrimSuffix(s.cfg.URL, "/") + "/api/v2/write?" + params.Encode()
+}
+
+// Flush writes the lines in batches, one request at a time.
+func (s *influxDBSink) Flush(ctx context.Context) error {
+	for pos := 0; pos < len(s.lines); pos += s.cfg.BatchSize {
+		end := pos + s.cfg.BatchSize
+		if end > len(s.lines) {
+			end = len(s.lines)
+		}
+		if err := s.send(ctx, []byte(strings.Join(s.lines[pos:end], "\n"))); err != nil {
+			return err
+		}
+	}
+
+	_ = level.Info(s.logger).Log("msg", "wrote samples to InfluxDB", "url", s.cfg.URL, "bucket", s.cfg.Bucket, "samples", len(s.lines))
+	s.lines = nil
+	return nil
+}
+
+func (s *influxDBSink) send(ctx context.Context, body []byte) error {
+	return doWithRetries(ctx, log.With(s.logger, "url", s.cfg.URL), s.client, s.cfg.Retries, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.writeURL(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		if s.cfg.Token != "" {
+			req.Header.Set("Authorization", "Token "+s.cfg.Token)
+		}
+		return req, nil
+	})
+}
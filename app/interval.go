@@ -0,0 +1,183 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/simonswine/thames-water-importer/api"
+)
+
+// Granularity is the interval of the readings requested from the provider.
+type Granularity string
+
+const (
+	// GranularityHour requests hourly readings, which all providers support.
+	GranularityHour Granularity = "60m"
+	// GranularityHalfHour requests half-hourly readings, which only plugins
+	// and custom providers may support.
+	GranularityHalfHour Granularity = "30m"
+	// GranularityDay requests a single reading per day.
+	GranularityDay Granularity = "1d"
+)
+
+// ParseGranularity validates the name of a granularity.
+func ParseGranularity(s string) (Granularity, error) {
+	switch g := Granularity(s); g {
+	case GranularityHour, GranularityHalfHour, GranularityDay:
+		return g, nil
+	default:
+		return "", fmt.Errorf("unknown granularity '%s', must be one of %s, %s or %s", s, GranularityHour, GranularityHalfHour, GranularityDay)
+	}
+}
+
+// Interval returns the interval each reading covers.
+func (g Granularity) Interval() time.Duration {
+	switch g {
+	case GranularityHalfHour:
+		return 30 * time.Minute
+	case GranularityDay:
+		return 24 * time.Hour
+	default:
+		return time.Hour
+	}
+}
+
+// thamesWaterGranularity returns the granularity parameter of the Thames Water
+// API.
+func thamesWaterGranularity(g Granularity) (string, error) {
+	switch g {
+	case GranularityHour:
+		return api.GranularityHourly, nil
+	case GranularityDay:
+		return api.GranularityDaily, nil
+	default:
+		return "", fmt.Errorf("granularity %s isn't provided by Thames Water", g)
+	}
+}
+
+// WithGranularity sets the interval of the readings requested from the
+// provider, it defaults to GranularityHour. The readings returned are
+// validated against it.
+func WithGranularity(g Granularity) NewOption {
+	return func(a *App) {
+		a.cfg.granularity = g
+	}
+}
+
+// IntervalGapHandling decides how missing intervals within the readings of a
+// day are handled, e.g. when the provider returns a partial day.
+type IntervalGapHandling string
+
+const (
+	// IntervalGapsWarn logs and counts the missing intervals.
+	IntervalGapsWarn IntervalGapHandling = "warn"
+	// IntervalGapsInterpolate fills the missing intervals between two
+	// readings by interpolating linearly between them. The interpolated
+	// readings are flagged as estimated, so they mark the gap. Missing
+	// intervals at the end of the day are only warned about, as the provider
+	// may still publish them.
+	IntervalGapsInterpolate IntervalGapHandling = "interpolate"
+)
+
+// ParseIntervalGapHandling validates the name of a gap handling.
+func ParseIntervalGapHandling(s string) (IntervalGapHandling, error) {
+	switch h := IntervalGapHandling(s); h {
+	case IntervalGapsWarn, IntervalGapsInterpolate:
+		return h, nil
+	default:
+		return "", fmt.Errorf("unknown interval gap handling '%s', must be either %s or %s", s, IntervalGapsWarn, IntervalGapsInterpolate)
+	}
+}
+
+// WithIntervalGapHandling sets how missing intervals are handled, it defaults
+// to IntervalGapsWarn.
+func WithIntervalGapHandling(h IntervalGapHandling) NewOption {
+	return func(a *App) {
+		a.cfg.intervalGapHandling = h
+	}
+}
+
+// checkGranularity fails, if the configured provider doesn't offer the
+// granularity.
+func (a *App) checkGranularity() error {
+	switch {
+	case a.cfg.provider != nil, a.cfg.plugin != nil:
+		return nil
+	case a.cfg.anglianWater != nil:
+		if a.cfg.granularity != GranularityHour {
+			return fmt.Errorf("granularity %s isn't provided by Anglian Water", a.cfg.granularity)
+		}
+		return nil
+	default:
+		_, err := thamesWaterGranularity(a.cfg.granularity)
+		return err
+	}
+}
+
+// checkIntervals validates that the readings of the meter are labelled with
+// the start of an interval of the configured granularity within the day,
+// sorts them and handles missing intervals.
+func (a *App) checkIntervals(meter string, day time.Time, readings []Reading) ([]Reading, error) {
+	interval := a.cfg.granularity.Interval()
+	for _, r := range readings {
+		offset := r.Time.Sub(day)
+		if offset < 0 || offset >= 24*time.Hour {
+			return nil, fmt.Errorf("reading of meter %s at %s is outside of the day %s", meter, r.Time.UTC().Format(time.RFC3339), day.Format("2006-01-02"))
+		}
+		if offset%interval != 0 {
+			return nil, fmt.Errorf("reading of meter %s at %s doesn't match the granularity %s", meter, r.Time.UTC().Format(time.RFC3339), a.cfg.granularity)
+		}
+	}
+	sort.SliceStable(readings, func(i, j int) bool {
+		return readings[i].Time.Before(readings[j].Time)
+	})
+
+	var (
+		result       = make([]Reading, 0, len(readings))
+		interpolated int
+		missing      int
+		next         = day
+	)
+	for pos, r := range readings {
+		// readings of the same interval are resolved by the duplicate policy
+		if pos > 0 && r.Time.Equal(readings[pos-1].Time) {
+			result = append(result, r)
+			continue
+		}
+		gaps := int(r.Time.Sub(next) / interval)
+		missing += gaps
+		if gaps > 0 && pos > 0 && a.cfg.intervalGapHandling == IntervalGapsInterpolate {
+			result = append(result, interpolateReadings(readings[pos-1], r, gaps, interval)...)
+			interpolated += gaps
+		}
+		result = append(result, r)
+		next = r.Time.Add(interval)
+	}
+	missing += int(day.AddDate(0, 0, 1).Sub(next) / interval)
+
+	if missing > 0 {
+		a.metrics.intervalGaps.WithLabelValues(meter).Add(float64(missing))
+		_ = level.Warn(a.logger).Log("msg", "readings are missing intervals of the day", "meter", meter, "date", day.Format("2006-01-02"), "granularity", a.cfg.granularity, "missing", missing, "interpolated", interpolated)
+	}
+	return result, nil
+}
+
+// interpolateReadings returns estimated readings of the n intervals between
+// the readings, interpolated linearly.
+func interpolateReadings(prev, next Reading, n int, interval time.Duration) []Reading {
+	readings := make([]Reading, n)
+	for i := range readings {
+		f := float64(i+1) / float64(n+1)
+		readings[i] = Reading{
+			Time:      prev.Time.Add(time.Duration(i+1) * interval),
+			Meter:     next.Meter,
+			Usage:     prev.Usage + (next.Usage-prev.Usage)*f,
+			Read:      prev.Read + (next.Read-prev.Read)*f,
+			Estimated: true,
+		}
+	}
+	return readings
+}
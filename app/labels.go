@@ -0,0 +1,240 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v2"
+)
+
+// sanitizeLabelName replaces all characters not allowed in a Prometheus label
+// name with an underscore.
+func sanitizeLabelName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' || (r >= '0' && r <= '9' && i > 0) {
+			b.WriteRune(r)
+			continue
+		}
+		if r >= '0' && r <= '9' {
+			b.WriteRune('_')
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteRune('_')
+	}
+	return b.String()
+}
+
+// validateLabels checks that the label set only contains valid label names and
+// values. If sanitize is set, invalid label names are rewritten instead of
+// rejected.
+func validateLabels(lbls labels.Labels, sanitize bool) (labels.Labels, error) {
+	var (
+		b    = labels.NewBuilder(nil)
+		seen = make(map[string]struct{}, len(lbls))
+	)
+	for _, l := range lbls {
+		name := l.Name
+		if !model.LabelName(name).IsValid() {
+			if !sanitize {
+				return nil, fmt.Errorf("invalid label name '%s': must match %s", name, model.LabelNameRE.String())
+			}
+			name = sanitizeLabelName(name)
+		}
+		if strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return nil, fmt.Errorf("invalid label name '%s': the prefix '%s' is reserved for internal use", name, model.ReservedLabelPrefix)
+		}
+		if !utf8.ValidString(l.Value) {
+			return nil, fmt.Errorf("invalid value for label '%s': not valid UTF-8", name)
+		}
+		if l.Value == "" {
+			return nil, fmt.Errorf("invalid value for label '%s': must not be empty", name)
+		}
+		if _, ok := seen[name]; ok {
+			return nil, fmt.Errorf("duplicate label name '%s'", name)
+		}
+		seen[name] = struct{}{}
+		b.Set(name, l.Value)
+	}
+	return b.Labels(), nil
+}
+
+// loadLabelsFile reads labels from a YAML map or a properties file with one
+// name=value pair per line, as produced by the Kubernetes downward API.
+func loadLabelsFile(path string) (labels.Labels, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		var m map[string]string
+		if err := yaml.UnmarshalStrict(data, &m); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", path, err)
+		}
+		return labels.FromMap(m), nil
+	}
+
+	var (
+		lbls    []string
+		scanner = bufio.NewScanner(bytes.NewReader(data))
+		lineNo  int
+	)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("error parsing %s:%d: expected name=value", path, lineNo)
+		}
+		value := strings.TrimSpace(parts[1])
+		if strings.HasPrefix(value, `"`) {
+			value, err = strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing %s:%d: %w", path, lineNo, err)
+			}
+		}
+		lbls = append(lbls, strings.TrimSpace(parts[0]), value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return labels.FromStrings(lbls...), nil
+}
+
+// resolvedConfig is the configuration resolved from the options and the
+// config files.
+type resolvedConfig struct {
+	externalLabels labels.Labels
+	meterLabels    map[string]labels.Labels
+	tariffs        tariffSchedule
+	emissions      emissionSchedule
+	relabelConfigs []*relabel.Config
+}
+
+// resolveConfig loads the config files and validates the external and
+// per-meter labels, so invalid blocks are never produced.
+func (a *App) resolveConfig() (*resolvedConfig, error) {
+	if err := a.checkGranularity(); err != nil {
+		return nil, err
+	}
+
+	lbls := a.cfg.externalLabels()
+	if a.cfg.externalLabelsFile != "" {
+		fileLbls, err := loadLabelsFile(a.cfg.externalLabelsFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading external labels file: %w", err)
+		}
+		// labels given explicitly take precedence over the file, the file
+		// takes precedence over the defaults
+		base, override := fileLbls, lbls
+		if !a.cfg.externalLabelsSet {
+			base, override = lbls, fileLbls
+		}
+		b := labels.NewBuilder(base)
+		for _, l := range override {
+			b.Set(l.Name, l.Value)
+		}
+		lbls = b.Labels()
+	}
+
+	var (
+		rc  = &resolvedConfig{meterLabels: make(map[string]labels.Labels, len(a.cfg.meterLabels))}
+		err error
+	)
+	rc.externalLabels, err = validateLabels(lbls, a.cfg.sanitizeLabels)
+	if err != nil {
+		return nil, fmt.Errorf("invalid external labels: %w", err)
+	}
+
+	tariffs := a.cfg.tariffs
+	if a.cfg.tariffFile != "" {
+		fileTariffs, err := loadTariffFile(a.cfg.tariffFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading tariff file: %w", err)
+		}
+		tariffs = append(tariffs[:len(tariffs):len(tariffs)], fileTariffs...)
+	}
+	rc.tariffs, err = newTariffSchedule(tariffs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tariffs: %w", err)
+	}
+
+	factors := a.cfg.emissionFactors
+	if a.cfg.emissionFactorFile != "" {
+		fileFactors, err := loadEmissionFactorFile(a.cfg.emissionFactorFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading emission factor file: %w", err)
+		}
+		factors = append(factors[:len(factors):len(factors)], fileFactors...)
+	}
+	rc.emissions, err = newEmissionSchedule(factors)
+	if err != nil {
+		return nil, fmt.Errorf("invalid emission factors: %w", err)
+	}
+
+	rc.relabelConfigs = a.cfg.relabelConfigs
+	if a.cfg.relabelConfigFile != "" {
+		fileConfigs, err := loadRelabelConfigFile(a.cfg.relabelConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading relabel config file: %w", err)
+		}
+		rc.relabelConfigs = append(rc.relabelConfigs[:len(rc.relabelConfigs):len(rc.relabelConfigs)], fileConfigs...)
+	}
+	if err := validateRelabelConfigs(rc.relabelConfigs); err != nil {
+		return nil, fmt.Errorf("invalid relabel configs: %w", err)
+	}
+
+	for meter, lbls := range a.cfg.meterLabels {
+		lbls, err := validateLabels(lbls, a.cfg.sanitizeLabels)
+		if err != nil {
+			return nil, fmt.Errorf("invalid labels for meter %s: %w", meter, err)
+		}
+		rc.meterLabels[meter] = lbls
+	}
+
+	return rc, nil
+}
+
+// validateConfig resolves the configuration, which is used until it is
+// validated again. Once a configuration has been valid, an invalid one is
+// logged and the last valid one is kept, so a long running process isn't
+// stopped by a broken config file.
+func (a *App) validateConfig() error {
+	rc, err := a.resolveConfig()
+	if err != nil {
+		if a.resolved == nil {
+			return err
+		}
+		_ = level.Warn(a.logger).Log("msg", "invalid configuration, keeping the last valid one", "err", err)
+		a.metrics.observeReload(err)
+		return nil
+	}
+	a.metrics.observeReload(nil)
+	a.resolved = rc
+	return nil
+}
+
+// externalLabels returns the resolved external labels, or the configured ones
+// before the configuration has been validated.
+func (a *App) externalLabels() labels.Labels {
+	if a.resolved == nil {
+		return a.cfg.externalLabels()
+	}
+	return a.resolved.externalLabels
+}
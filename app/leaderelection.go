@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// LeaderElection configures the election of the replica, which performs the
+// imports and uploads, via a Kubernetes Lease. The service account needs to
+// be allowed to get, create and update the Lease.
+type LeaderElection struct {
+	// Namespace of the Lease, defaults to the namespace of the pod.
+	Namespace string
+	LeaseName string
+	// Identity of the replica, defaults to the hostname, which is the pod
+	// name.
+	Identity string
+	// Kubeconfig is used outside of a cluster.
+	Kubeconfig string
+	// LeaseDuration is the time the other replicas wait, before they take
+	// over from a leader, which stopped renewing the Lease.
+	LeaseDuration time.Duration
+}
+
+func (le LeaderElection) restConfig() (*rest.Config, error) {
+	if le.Kubeconfig != "" {
+		return clientcmd.BuildConfigFromFlags("", le.Kubeconfig)
+	}
+	return rest.InClusterConfig()
+}
+
+// newLock returns the Lease lock of the replica.
+func (le LeaderElection) newLock() (*resourcelock.LeaseLock, error) {
+	cfg, err := le.restConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error loading Kubernetes client config: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Kubernetes client: %w", err)
+	}
+
+	namespace := le.Namespace
+	if namespace == "" {
+		data, err := os.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("no leader election namespace set and unable to read the pod's namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	identity := le.Identity
+	if identity == "" {
+		identity, err = os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("error determining leader election identity: %w", err)
+		}
+	}
+
+	return &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: le.LeaseName},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}, nil
+}
+
+// runAsLeader runs f whenever the replica is elected leader, until the
+// context is done. The context passed to f is cancelled, when the leadership
+// is lost. The Lease is released on shutdown, so another replica takes over
+// right away.
+func (a *App) runAsLeader(ctx context.Context, le LeaderElection, f func(context.Context)) error {
+	lock, err := le.newLock()
+	if err != nil {
+		return err
+	}
+	a.setStandby(true)
+
+	// held while f runs, so a lost term's imports stop, before the replica
+	// stands by for the next election
+	var running sync.Mutex
+
+	// the durations keep the ratios of the client-go defaults of 15s, 10s
+	// and 2s
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            le.LeaseName,
+		LeaseDuration:   le.LeaseDuration,
+		RenewDeadline:   le.LeaseDuration * 2 / 3,
+		RetryPeriod:     le.LeaseDuration * 2 / 15,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				running.Lock()
+				defer running.Unlock()
+				_ = level.Info(a.logger).Log("msg", "elected leader, starting imports", "identity", lock.Identity())
+				a.setStandby(false)
+				f(ctx)
+			},
+			OnStoppedLeading: func() {
+				if !a.standby() {
+					_ = level.Info(a.logger).Log("msg", "stopped leading", "identity", lock.Identity())
+				}
+				a.setStandby(true)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != lock.Identity() {
+					_ = level.Info(a.logger).Log("msg", "another replica leads", "leader", identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("invalid leader election config: %w", err)
+	}
+
+	// the elector returns after losing the leadership, so it is run again
+	// to stand by for the next election
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+		// wait for f to return
+		running.Lock()
+		running.Unlock()
+	}
+	return nil
+}
+
+// setStandby records whether the replica waits to be elected leader.
+func (a *App) setStandby(standby bool) {
+	a.standbyMtx.Lock()
+	defer a.standbyMtx.Unlock()
+	a.standbyState = standby
+	if standby {
+		a.metrics.leader.Set(0)
+	} else {
+		a.metrics.leader.Set(1)
+	}
+}
+
+func (a *App) standby() bool {
+	a.standbyMtx.Lock()
+	defer a.standbyMtx.Unlock()
+	return a.standbyState
+}
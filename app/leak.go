@@ -0,0 +1,77 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// LeakDetection configures the search for continuous flow overnight, the
+// classic signature of a leak. The hours are in the meter's local time.
+type LeakDetection struct {
+	// StartHour and EndHour delimit the night, [StartHour, EndHour).
+	StartHour int
+	EndHour   int
+	// MinLiters is the consumption, which every reading of the night needs to
+	// reach to be considered a continuous flow.
+	MinLiters float64
+}
+
+// WithLeakDetection raises a WaterLeak alert for every night, in which all
+// readings show a consumption.
+func WithLeakDetection(l LeakDetection) NewOption {
+	return func(a *App) {
+		a.cfg.leakDetection = &l
+	}
+}
+
+type leakRule struct {
+	cfg LeakDetection
+}
+
+func (r leakRule) name() string {
+	return "leak detection"
+}
+
+func (r leakRule) evaluate(_ context.Context, readings []Reading) ([]Alert, error) {
+	var alerts []Alert
+	for meter, days := range readingsByMeterAndDay(readings) {
+		for _, day := range sortedDays(days) {
+			var (
+				night    []Reading
+				total    float64
+				minUsage = math.Inf(1)
+				complete = true
+			)
+			for _, reading := range days[day] {
+				h := reading.Time.Hour()
+				if h < r.cfg.StartHour || h >= r.cfg.EndHour {
+					continue
+				}
+				night = append(night, reading)
+				if math.IsNaN(reading.Read) || reading.Read < r.cfg.MinLiters {
+					complete = false
+					break
+				}
+				total += reading.Read
+				minUsage = math.Min(minUsage, reading.Read)
+			}
+			// a night needs at least an hour of readings
+			if !complete || len(night) < 2 {
+				continue
+			}
+
+			alerts = append(alerts, Alert{
+				Name:  "WaterLeak",
+				Meter: meter,
+				Summary: fmt.Sprintf(
+					"Possible leak on meter %s: continuous flow between %02d:00 and %02d:00 on %s, at least %s per reading, %s in total.",
+					meter, r.cfg.StartHour, r.cfg.EndHour, day.Format("2006-01-02"), formatLiters(minUsage), formatLiters(total),
+				),
+				StartsAt: night[0].Time,
+				EndsAt:   night[len(night)-1].Time,
+			})
+		}
+	}
+	return alerts, nil
+}
@@ -0,0 +1,21 @@
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+)
+
+const lockFilename = "thames-water-importer.lock"
+
+// lock acquires an exclusive lock on the TSDB path, so concurrent runs can't
+// corrupt the TSDB or upload blocks twice.
+func (a *App) lock() (fileutil.Releaser, error) {
+	path := filepath.Join(a.cfg.tsdbPath, lockFilename)
+	r, _, err := fileutil.Flock(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to lock %s, another instance is likely running on the same TSDB path: %w", path, err)
+	}
+	return r, nil
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+const (
+	// headBytesPerSample is a conservative estimate of the memory a sample
+	// takes in the TSDB head, including its share of the series and the WAL.
+	headBytesPerSample = 16
+	// memoryBudgetStripeSize is the TSDB stripe size within a memory budget,
+	// as the default stripes take several MiB, while only a few series are
+	// imported.
+	memoryBudgetStripeSize = 256
+	// minCommitBatchReadings are the fewest readings committed at once.
+	minCommitBatchReadings = 24
+)
+
+// WithMemoryBudget bounds the memory of runs to roughly b bytes, for devices
+// like the Raspberry Pi Zero, which run out of memory during long backfills.
+// It lowers the TSDB stripe size and head chunks write buffer, persists the
+// head as blocks during the import, once it grows beyond a quarter of the
+// budget, commits the readings in bounded batches and uploads one block at a
+// time. Readings are fetched one day at a time anyway and the responses are
+// decoded while streaming them.
+func WithMemoryBudget(b int64) NewOption {
+	return func(a *App) {
+		a.cfg.memoryBudget = b
+	}
+}
+
+// applyMemoryBudget lowers the memory tuning of the TSDB options to the
+// memory budget.
+func (a *App) applyMemoryBudget(o *tsdb.Options) {
+	if a.cfg.memoryBudget <= 0 {
+		return
+	}
+	if o.StripeSize > memoryBudgetStripeSize {
+		o.StripeSize = memoryBudgetStripeSize
+	}
+	bufferSize := int(a.cfg.memoryBudget / 16)
+	if bufferSize < chunks.MinWriteBufferSize {
+		bufferSize = chunks.MinWriteBufferSize
+	}
+	if o.HeadChunksWriteBufferSize > bufferSize {
+		o.HeadChunksWriteBufferSize = bufferSize
+	}
+}
+
+// headSampleLimit returns the number of samples, after which the head is
+// persisted during the import, or 0 for no limit.
+func (a *App) headSampleLimit() int {
+	if a.cfg.memoryBudget <= 0 {
+		return 0
+	}
+	return int(a.cfg.memoryBudget / 4 / headBytesPerSample)
+}
+
+// commitBatchSize returns the number of readings committed at once, or 0 to
+// commit the readings of a day at once.
+func (a *App) commitBatchSize() int {
+	if a.cfg.memoryBudget <= 0 {
+		return 0
+	}
+	n := int(a.cfg.memoryBudget / (64 << 10))
+	if n < minCommitBatchReadings {
+		n = minCommitBatchReadings
+	}
+	return n
+}
+
+// uploadConcurrency returns the number of blocks uploaded concurrently.
+func (a *App) uploadConcurrency() int {
+	if a.cfg.memoryBudget > 0 {
+		return 1
+	}
+	return a.cfg.uploadConcurrency
+}
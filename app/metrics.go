@@ -0,0 +1,244 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// reregisterer replaces collectors registered before, instead of failing. This
+// allows components like the TSDB, which register their metrics when opened,
+// to be opened again by later runs of a long running process.
+type reregisterer struct {
+	prometheus.Registerer
+}
+
+func (r reregisterer) Register(c prometheus.Collector) error {
+	err := r.Registerer.Register(c)
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		r.Registerer.Unregister(are.ExistingCollector)
+		return r.Registerer.Register(c)
+	}
+	return err
+}
+
+func (r reregisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+type metrics struct {
+	latestSampleTimestamp *prometheus.GaugeVec
+
+	lastRunSuccess         prometheus.Gauge
+	lastRunDuration        prometheus.Gauge
+	lastRunTimestamp       prometheus.Gauge
+	lastRunSamplesAppended prometheus.Gauge
+
+	anomalyScore *prometheus.GaugeVec
+
+	daysFetched        *prometheus.CounterVec
+	samplesAppended    prometheus.Counter
+	duplicateSamples   *prometheus.CounterVec
+	samplesFiltered    *prometheus.CounterVec
+	intervalGaps       *prometheus.CounterVec
+	circuitBreakerOpen *prometheus.GaugeVec
+	apiRequestDuration *prometheus.HistogramVec
+	loginAttempts      *prometheus.CounterVec
+	loginPhaseDuration *prometheus.HistogramVec
+	blocksUploaded     *prometheus.CounterVec
+
+	leader prometheus.Gauge
+
+	configLastReloadSuccessful    prometheus.Gauge
+	configLastReloadSuccessSecond prometheus.Gauge
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	m := &metrics{
+		latestSampleTimestamp: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "water_importer_latest_sample_timestamp_seconds",
+			Help: "Timestamp of the newest consumption sample in the local TSDB per meter.",
+		}, []string{"meter"}),
+		lastRunSuccess: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "water_importer_last_run_success",
+			Help: "Whether the last run succeeded.",
+		}),
+		lastRunDuration: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "water_importer_last_run_duration_seconds",
+			Help: "Duration of the last run.",
+		}),
+		lastRunTimestamp: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "water_importer_last_run_timestamp_seconds",
+			Help: "Time the last run finished.",
+		}),
+		lastRunSamplesAppended: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "water_importer_last_run_samples_appended",
+			Help: "Number of samples appended to the local TSDB by the last run.",
+		}),
+		anomalyScore: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "water_importer_anomaly_score",
+			Help: "Deviation of the newest imported day's hourly consumption from the baseline per meter, as root mean square of the hourly z-scores.",
+		}, []string{"meter"}),
+		daysFetched: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "water_importer_days_fetched_total",
+			Help: "Number of days of readings fetched from Thames Water per meter.",
+		}, []string{"meter"}),
+		samplesAppended: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "water_importer_samples_appended_total",
+			Help: "Number of samples appended to the local TSDB.",
+		}),
+		duplicateSamples: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "water_importer_duplicate_samples_total",
+			Help: "Number of samples skipped, as their timestamp was imported already, by whether the value was identical or conflicting.",
+		}, []string{"resolution"}),
+		samplesFiltered: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "water_importer_samples_filtered_total",
+			Help: "Number of readings dropped by the sample filter, or clamped to zero if negative, by reason.",
+		}, []string{"reason"}),
+		intervalGaps: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "water_importer_interval_gaps_total",
+			Help: "Number of intervals missing from the days of readings fetched from the provider per meter.",
+		}, []string{"meter"}),
+		circuitBreakerOpen: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "water_importer_circuit_breaker_open",
+			Help: "Whether the circuit breaker of a phase has been opened by its last failures, 1 if so.",
+		}, []string{"phase"}),
+		apiRequestDuration: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "water_importer_api_request_duration_seconds",
+			Help:    "Duration of the requests to the Thames Water API by endpoint and status code.",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		}, []string{"endpoint", "code"}),
+		loginAttempts: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "water_importer_login_attempts_total",
+			Help: "Number of logins to the Thames Water account by result.",
+		}, []string{"result"}),
+		loginPhaseDuration: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "water_importer_login_phase_duration_seconds",
+			Help:    "Duration of the completed phases of the browser login.",
+			Buckets: []float64{0.5, 1, 2.5, 5, 10, 20, 40, 80},
+		}, []string{"phase"}),
+		blocksUploaded: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "water_importer_blocks_uploaded_total",
+			Help: "Number of blocks uploaded and verified per destination.",
+		}, []string{"destination"}),
+		leader: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "water_importer_leader",
+			Help: "Whether the replica is the elected leader performing the imports. Always 1 without leader election.",
+		}),
+	}
+	m.configLastReloadSuccessful = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "water_importer_config_last_reload_successful",
+		Help: "Whether the last load of the configuration succeeded.",
+	})
+	m.configLastReloadSuccessSecond = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "water_importer_config_last_reload_success_timestamp_seconds",
+		Help: "Time of the last successful load of the configuration.",
+	})
+	m.leader.Set(1)
+	return m
+}
+
+// observeReload records the outcome of loading the configuration.
+func (m *metrics) observeReload(err error) {
+	if err != nil {
+		m.configLastReloadSuccessful.Set(0)
+		return
+	}
+	m.configLastReloadSuccessful.Set(1)
+	m.configLastReloadSuccessSecond.SetToCurrentTime()
+}
+
+// apiRoundTripper observes the duration of the requests to the Thames Water
+// API.
+type apiRoundTripper struct {
+	next     http.RoundTripper
+	duration *prometheus.HistogramVec
+}
+
+func (rt *apiRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := rt.next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	rt.duration.WithLabelValues(path.Base(req.URL.Path), code).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// observeRun records the outcome of a run.
+func (m *metrics) observeRun(summary RunSummary) {
+	if summary.Status == RunStatusSuccess {
+		m.lastRunSuccess.Set(1)
+	} else {
+		m.lastRunSuccess.Set(0)
+	}
+	m.lastRunDuration.Set(summary.DurationSeconds)
+	m.lastRunTimestamp.Set(float64(time.Now().UnixNano()) / 1e9)
+	m.lastRunSamplesAppended.Set(float64(summary.Samples))
+}
+
+// updateLatestSampleTimestamps sets the freshness gauge from the samples in
+// the local TSDB, so it also reflects data imported by previous runs.
+func (a *App) updateLatestSampleTimestamps(ctx context.Context) error {
+	latest := make(map[string]time.Time)
+	if err := a.querySamples(
+		ctx,
+		time.Unix(0, 0),
+		time.Unix(math.MaxInt64/1000, 0),
+		[]*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, consumptionMetricName)},
+		func(lbls labels.Labels, t time.Time, _ float64) {
+			meter := lbls.Get("meter")
+			if t.After(latest[meter]) {
+				latest[meter] = t
+			}
+		},
+	); err != nil {
+		return err
+	}
+
+	for meter, t := range latest {
+		a.metrics.latestSampleTimestamp.WithLabelValues(meter).Set(float64(t.Unix()))
+	}
+	return nil
+}
+
+// WithPushgateway pushes the importer's own metrics to the Pushgateway at the
+// end of each run, grouped by the job.
+func WithPushgateway(url, job string) NewOption {
+	return func(a *App) {
+		a.cfg.pushgatewayURL = url
+		a.cfg.pushgatewayJob = job
+	}
+}
+
+// pushMetrics replaces the importer's metrics on the Pushgateway.
+func (a *App) pushMetrics() error {
+	if a.cfg.pushgatewayURL == "" {
+		return nil
+	}
+	return push.New(a.cfg.pushgatewayURL, a.cfg.pushgatewayJob).Gatherer(a.reg).Push()
+}
+
+// writeMetricsTextfile writes the importer's own metrics in the text format
+// of the node_exporter textfile collector.
+func (a *App) writeMetricsTextfile() error {
+	if a.cfg.metricsTextfile == "" {
+		return nil
+	}
+	return prometheus.WriteToTextfile(a.cfg.metricsTextfile, a.reg)
+}
@@ -0,0 +1,253 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"text/template"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+const defaultMQTTReadingTopic = "thames-water/{{ .Meter }}/reading"
+
+// MQTT configures a MQTT broker, which receives every imported reading and
+// the daily totals once a run has imported them.
+type MQTT struct {
+	// Broker is the URL of the broker, e.g. tcp://mosquitto:1883.
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	QoS      byte
+	Retain   bool
+	// ReadingTopic and DailyTopic are templates of the topics, with the
+	// meter available as {{ .Meter }}. An empty DailyTopic disables the daily
+	// totals.
+	ReadingTopic string
+	DailyTopic   string
+
+	// HomeAssistantDiscovery publishes Home Assistant MQTT discovery configs
+	// of a water consumption sensor per meter, whose state is the meter's
+	// consumption of each imported day published to StateTopic.
+	HomeAssistantDiscovery       bool
+	HomeAssistantDiscoveryPrefix string
+	StateTopic                   string
+
+	// Timeout of connecting and of each publish.
+	Timeout time.Duration
+}
+
+// WithMQTT publishes the imported readings to the MQTT broker.
+func WithMQTT(m MQTT) NewOption {
+	return func(a *App) {
+		a.cfg.mqtts = append(a.cfg.mqtts, m)
+	}
+}
+
+type mqttDaily struct {
+	Date      string  `json:"date"`
+	Meter     string  `json:"meter"`
+	Usage     float64 `json:"usage"`
+	Estimated bool    `json:"estimated"`
+}
+
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	// retain the message, regardless of the configuration
+	retain bool
+}
+
+type mqttSink struct {
+	logger       log.Logger
+	cfg          MQTT
+	readingTopic *template.Template
+	dailyTopic   *template.Template
+	stateTopic   *template.Template
+
+	readings []Reading
+}
+
+func newMQTTSink(logger log.Logger, cfg MQTT) (*mqttSink, error) {
+	if cfg.ClientID == "" {
+		cfg.ClientID = "thames-water-importer"
+	}
+	if cfg.ReadingTopic == "" {
+		cfg.ReadingTopic = defaultMQTTReadingTopic
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.QoS > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS %d", cfg.QoS)
+	}
+
+	s := &mqttSink{
+		logger: logger,
+		cfg:    cfg,
+	}
+	var err error
+	if s.readingTopic, err = template.New("reading").Parse(cfg.ReadingTopic); err != nil {
+		return nil, fmt.Errorf("invalid MQTT reading topic: %w", err)
+	}
+	if cfg.DailyTopic != "" {
+		if s.dailyTopic, err = template.New("daily").Parse(cfg.DailyTopic); err != nil {
+			return nil, fmt.Errorf("invalid MQTT daily topic: %w", err)
+		}
+	}
+	if cfg.HomeAssistantDiscovery {
+		if s.cfg.HomeAssistantDiscoveryPrefix == "" {
+			s.cfg.HomeAssistantDiscoveryPrefix = defaultHomeAssistantDiscoveryPrefix
+		}
+		if s.cfg.StateTopic == "" {
+			s.cfg.StateTopic = defaultMQTTStateTopic
+		}
+		if s.stateTopic, err = template.New("state").Parse(s.cfg.StateTopic); err != nil {
+			return nil, fmt.Errorf("invalid MQTT state topic: %w", err)
+		}
+	}
+	return s, nil
+}
+
+func (s *mqttSink) Name() string {
+	return "MQTT broker " + s.cfg.Broker
+}
+
+func (s *mqttSink) Close() error {
+	return nil
+}
+
+// AppendSample ignores the samples, the readings are received by
+// AppendReadings.
+func (s *mqttSink) AppendSample(labels.Labels, int64, float64) {}
+
+func (s *mqttSink) AppendReadings(readings []Reading) {
+	s.readings = append(s.readings, readings...)
+}
+
+// messages returns the messages of all readings, followed by the daily totals
+// and the Home Assistant discovery.
+func (s *mqttSink) messages() ([]mqttMessage, error) {
+	sort.SliceStable(s.readings, func(i, j int) bool {
+		return s.readings[i].Time.Before(s.readings[j].Time)
+	})
+
+	var (
+		msgs   []mqttMessage
+		daily  = make(map[string]*mqttDaily)
+		dailys []*mqttDaily
+	)
+	for _, r := range s.readings {
+		t, err := executeMeterTemplate(s.readingTopic, r.Meter)
+		if err != nil {
+			return nil, err
+		}
+		payload, err := json.Marshal(newReadingEvent(r))
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, mqttMessage{topic: t, payload: payload})
+
+		date := r.Time.Format("2006-01-02")
+		d, ok := daily[r.Meter+"/"+date]
+		if !ok {
+			d = &mqttDaily{Date: date, Meter: r.Meter}
+			daily[r.Meter+"/"+date] = d
+			dailys = append(dailys, d)
+		}
+		if !math.IsNaN(r.Read) {
+			d.Usage += r.Read
+		}
+		d.Estimated = d.Estimated || r.Estimated
+	}
+
+	if s.dailyTopic != nil {
+		for _, d := range dailys {
+			t, err := executeMeterTemplate(s.dailyTopic, d.Meter)
+			if err != nil {
+				return nil, err
+			}
+			payload, err := json.Marshal(d)
+			if err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, mqttMessage{topic: t, payload: payload})
+		}
+	}
+
+	if s.cfg.HomeAssistantDiscovery {
+		haMsgs, err := s.homeAssistantMessages(dailys)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, haMsgs...)
+	}
+	return msgs, nil
+}
+
+// connect connects to the broker.
+func (s *mqttSink) connect() (mqtt.Client, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(s.cfg.Broker).
+		SetClientID(s.cfg.ClientID).
+		SetUsername(s.cfg.Username).
+		SetPassword(s.cfg.Password).
+		SetConnectTimeout(s.cfg.Timeout).
+		SetAutoReconnect(false)
+
+	client := mqtt.NewClient(opts)
+	if err := waitMQTT(client.Connect(), s.cfg.Timeout); err != nil {
+		return nil, fmt.Errorf("error connecting to MQTT broker: %w", err)
+	}
+	return client, nil
+}
+
+func (s *mqttSink) publish(client mqtt.Client, msgs []mqttMessage) error {
+	for _, m := range msgs {
+		if err := waitMQTT(client.Publish(m.topic, s.cfg.QoS, s.cfg.Retain || m.retain, m.payload), s.cfg.Timeout); err != nil {
+			return fmt.Errorf("error publishing to %s: %w", m.topic, err)
+		}
+	}
+	return nil
+}
+
+func (s *mqttSink) Flush(ctx context.Context) error {
+	if len(s.readings) == 0 {
+		return nil
+	}
+
+	msgs, err := s.messages()
+	if err != nil {
+		return err
+	}
+
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(250)
+
+	if err := s.publish(client, msgs); err != nil {
+		return err
+	}
+
+	_ = level.Info(s.logger).Log("msg", "published readings to MQTT", "broker", s.cfg.Broker, "messages", len(msgs))
+	s.readings = nil
+	return nil
+}
+
+// waitMQTT waits for the token to complete.
+func waitMQTT(t mqtt.Token, timeout time.Duration) error {
+	if !t.WaitTimeout(timeout) {
+		return errors.New("timed out")
+	}
+	return t.Error()
+}
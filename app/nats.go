@@ -0,0 +1,143 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+const defaultNATSSubject = "thames-water.{{ .Meter }}.reading"
+
+// NATS configures a NATS server, which receives an event per imported reading.
+type NATS struct {
+	// URL of the server, e.g. nats://nats:4222.
+	URL string
+	// Subject is a template of the subject, with the meter available as
+	// {{ .Meter }}.
+	Subject string
+	// CredentialsFile is an optional user credentials file.
+	CredentialsFile string
+	// JetStream publishes to a stream covering the subjects and waits for
+	// its acknowledgement. Every event carries a message ID of meter and
+	// time, so the stream drops readings imported again within its
+	// duplicate window.
+	JetStream bool
+	// Timeout of connecting and publishing.
+	Timeout time.Duration
+}
+
+// WithNATS publishes the imported readings to NATS.
+func WithNATS(n NATS) NewOption {
+	return func(a *App) {
+		a.cfg.natsServers = append(a.cfg.natsServers, n)
+	}
+}
+
+type natsSink struct {
+	logger  log.Logger
+	cfg     NATS
+	subject *template.Template
+
+	readings []Reading
+}
+
+func newNATSSink(logger log.Logger, cfg NATS) (*natsSink, error) {
+	if cfg.Subject == "" {
+		cfg.Subject = defaultNATSSubject
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	subject, err := template.New("subject").Parse(cfg.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS subject: %w", err)
+	}
+	return &natsSink{
+		logger:  logger,
+		cfg:     cfg,
+		subject: subject,
+	}, nil
+}
+
+func (s *natsSink) Name() string {
+	return "NATS " + s.cfg.URL
+}
+
+func (s *natsSink) Close() error {
+	return nil
+}
+
+// AppendSample ignores the samples, the readings are received by
+// AppendReadings.
+func (s *natsSink) AppendSample(labels.Labels, int64, float64) {}
+
+func (s *natsSink) AppendReadings(readings []Reading) {
+	s.readings = append(s.readings, readings...)
+}
+
+func (s *natsSink) Flush(ctx context.Context) error {
+	if len(s.readings) == 0 {
+		return nil
+	}
+
+	opts := []nats.Option{
+		nats.Name("thames-water-importer"),
+		nats.Timeout(s.cfg.Timeout),
+	}
+	if s.cfg.CredentialsFile != "" {
+		opts = append(opts, nats.UserCredentials(s.cfg.CredentialsFile))
+	}
+	nc, err := nats.Connect(s.cfg.URL, opts...)
+	if err != nil {
+		return fmt.Errorf("error connecting to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	var js nats.JetStreamContext
+	if s.cfg.JetStream {
+		js, err = nc.JetStream(nats.MaxWait(s.cfg.Timeout))
+		if err != nil {
+			return err
+		}
+	}
+
+	sort.SliceStable(s.readings, func(i, j int) bool {
+		return s.readings[i].Time.Before(s.readings[j].Time)
+	})
+	for _, r := range s.readings {
+		subject, err := executeMeterTemplate(s.subject, r.Meter)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(newReadingEvent(r))
+		if err != nil {
+			return err
+		}
+
+		if js != nil {
+			msgID := r.Meter + "/" + r.Time.UTC().Format(time.RFC3339)
+			if _, err := js.Publish(subject, data, nats.MsgId(msgID), nats.Context(ctx)); err != nil {
+				return fmt.Errorf("error publishing to %s: %w", subject, err)
+			}
+			continue
+		}
+		if err := nc.Publish(subject, data); err != nil {
+			return fmt.Errorf("error publishing to %s: %w", subject, err)
+		}
+	}
+	if err := nc.FlushTimeout(s.cfg.Timeout); err != nil {
+		return err
+	}
+
+	_ = level.Info(s.logger).Log("msg", "published readings to NATS", "url", s.cfg.URL, "readings", len(s.readings))
+	s.readings = nil
+	return nil
+}
@@ -0,0 +1,365 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"gopkg.in/yaml.v2"
+)
+
+// Run statuses reported in the RunSummary.
+const (
+	RunStatusSuccess = "success"
+	RunStatusFailure = "failure"
+)
+
+// Categories of the error of a failed run.
+const (
+	ErrorCategoryLogin          = "login"
+	ErrorCategoryThamesWaterAPI = "thames_water_api"
+	ErrorCategoryLocalTSDB      = "local_tsdb"
+	ErrorCategorySink           = "sink"
+	ErrorCategoryUpload         = "upload"
+	ErrorCategoryUnknown        = "unknown"
+)
+
+// categorizedError records which phase of the run failed.
+type categorizedError struct {
+	category string
+	err      error
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() error {
+	return e.err
+}
+
+// withCategory assigns the category to the error, unless it has been assigned
+// one already.
+func withCategory(category string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var ce *categorizedError
+	if errors.As(err, &ce) {
+		return err
+	}
+	return &categorizedError{category: category, err: err}
+}
+
+func errorCategory(err error) string {
+	var ce *categorizedError
+	if errors.As(err, &ce) {
+		return ce.category
+	}
+	return ErrorCategoryUnknown
+}
+
+// RunPhase is a phase of a run, like the login or the upload, and its
+// duration.
+type RunPhase struct {
+	Name            string  `json:"name"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// RunSummary describes the outcome of a run.
+type RunSummary struct {
+	Status          string    `json:"status"`
+	Start           time.Time `json:"start"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// Phases the run went through, in order.
+	Phases []RunPhase `json:"phases,omitempty"`
+	// DaysImported is the number of days, whose readings have been fetched
+	// from Thames Water.
+	DaysImported int `json:"days_imported"`
+	// Samples is the number of samples appended to the local TSDB.
+	Samples       int    `json:"samples"`
+	Error         string `json:"error,omitempty"`
+	ErrorCategory string `json:"error_category,omitempty"`
+	// LastLogLines are the last lines logged by the run.
+	LastLogLines []string `json:"last_log_lines,omitempty"`
+	// Screenshot is the path of the screenshot taken after a failed login.
+	Screenshot string `json:"screenshot,omitempty"`
+	// LoginPhase is the step of the browser login, which failed.
+	LoginPhase string `json:"login_phase,omitempty"`
+	// Alerts raised by the readings imported during the run.
+	Alerts []Alert `json:"alerts,omitempty"`
+
+	// days imported, oldest first
+	days []time.Time
+}
+
+// runStats are collected while a run imports.
+type runStats struct {
+	days       map[time.Time]struct{}
+	samples    int
+	screenshot string
+	loginPhase string
+	readings   []Reading
+	phases     []RunPhase
+	phaseStart time.Time
+}
+
+func newRunStats() *runStats {
+	return &runStats{days: make(map[time.Time]struct{})}
+}
+
+// startPhase ends the current phase of the run and starts the next one.
+func (s *runStats) startPhase(name string) {
+	if s == nil {
+		return
+	}
+	s.endPhase()
+	s.phases = append(s.phases, RunPhase{Name: name})
+	s.phaseStart = time.Now()
+}
+
+func (s *runStats) endPhase() {
+	if n := len(s.phases); n > 0 && !s.phaseStart.IsZero() {
+		s.phases[n-1].DurationSeconds = time.Since(s.phaseStart).Seconds()
+		s.phaseStart = time.Time{}
+	}
+}
+
+func (s *runStats) summary(start time.Time, err error) RunSummary {
+	s.endPhase()
+	summary := RunSummary{
+		Status:          RunStatusSuccess,
+		Start:           start,
+		DurationSeconds: time.Since(start).Seconds(),
+		Phases:          s.phases,
+		DaysImported:    len(s.days),
+		Samples:         s.samples,
+		Screenshot:      s.screenshot,
+		LoginPhase:      s.loginPhase,
+	}
+	for day := range s.days {
+		summary.days = append(summary.days, day)
+	}
+	sort.Slice(summary.days, func(i, j int) bool {
+		return summary.days[i].Before(summary.days[j])
+	})
+	if err != nil {
+		summary.Status = RunStatusFailure
+		summary.Error = err.Error()
+		summary.ErrorCategory = errorCategory(err)
+	}
+	return summary
+}
+
+// notifier is informed about the outcome of every run.
+type notifier interface {
+	name() string
+	notify(ctx context.Context, summary RunSummary) error
+}
+
+// needsAttention is true for failed runs and runs, which raised alerts.
+func (s RunSummary) needsAttention() bool {
+	return s.Status == RunStatusFailure || len(s.Alerts) > 0
+}
+
+// summaryText formats the summary as plain text, for chat messages.
+func summaryText(summary RunSummary) string {
+	var b strings.Builder
+	if summary.Status == RunStatusFailure {
+		fmt.Fprintf(&b, "Thames Water import failed after %.0fs (%s): %s\n", summary.DurationSeconds, summary.ErrorCategory, summary.Error)
+		if summary.Screenshot != "" {
+			fmt.Fprintf(&b, "Screenshot: %s\n", summary.Screenshot)
+		}
+	} else {
+		fmt.Fprintf(&b, "Thames Water import succeeded after %.0fs: %d days with %d samples imported\n", summary.DurationSeconds, summary.DaysImported, summary.Samples)
+	}
+	for _, alert := range summary.Alerts {
+		fmt.Fprintf(&b, "%s: %s\n", alert.Name, alert.Summary)
+	}
+	return b.String()
+}
+
+// truncateText cuts the text to the message size limit of a chat service.
+func truncateText(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	if limit < 1 {
+		return ""
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// NotificationConfig is the notification config block, which selects the
+// notification backends.
+type NotificationConfig struct {
+	Webhooks []Webhook  `yaml:"webhooks"`
+	Slack    []Slack    `yaml:"slack"`
+	Telegram []Telegram `yaml:"telegram"`
+	Discord  []Discord  `yaml:"discord"`
+	Ntfy     []Ntfy     `yaml:"ntfy"`
+	Email    []Email    `yaml:"email"`
+	// Alertmanager only receives the alerts raised by a run.
+	Alertmanager []Alertmanager `yaml:"alertmanager"`
+}
+
+// WithNotificationConfig adds the notification backends of the config block.
+func WithNotificationConfig(cfg NotificationConfig) NewOption {
+	return func(a *App) {
+		a.cfg.webhooks = append(a.cfg.webhooks, cfg.Webhooks...)
+		a.cfg.slacks = append(a.cfg.slacks, cfg.Slack...)
+		a.cfg.telegrams = append(a.cfg.telegrams, cfg.Telegram...)
+		a.cfg.discords = append(a.cfg.discords, cfg.Discord...)
+		a.cfg.ntfys = append(a.cfg.ntfys, cfg.Ntfy...)
+		a.cfg.emails = append(a.cfg.emails, cfg.Email...)
+		a.cfg.alertmanagers = append(a.cfg.alertmanagers, cfg.Alertmanager...)
+	}
+}
+
+// WithNotificationConfigFile reads the notification config block from the
+// YAML file, which is read again on every run.
+func WithNotificationConfigFile(path string) NewOption {
+	return func(a *App) {
+		a.cfg.notificationConfigFile = path
+	}
+}
+
+// loadNotificationConfigFile reads the notification config block from the
+// file. Unset timeouts and retries use the same defaults as the command line
+// flags.
+func loadNotificationConfigFile(path string) (NotificationConfig, error) {
+	var cfg NotificationConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	defaults := func(timeout *time.Duration, retries *int) {
+		if *timeout == 0 {
+			*timeout = 30 * time.Second
+		}
+		if *retries == 0 {
+			*retries = 3
+		}
+	}
+	for i := range cfg.Webhooks {
+		if cfg.Webhooks[i].URL == "" {
+			return cfg, fmt.Errorf("error parsing %s: webhook %d has no url", path, i)
+		}
+		defaults(&cfg.Webhooks[i].Timeout, &cfg.Webhooks[i].Retries)
+	}
+	for i := range cfg.Slack {
+		if cfg.Slack[i].WebhookURL == "" {
+			return cfg, fmt.Errorf("error parsing %s: slack %d has no webhook_url", path, i)
+		}
+		defaults(&cfg.Slack[i].Timeout, &cfg.Slack[i].Retries)
+	}
+	for i := range cfg.Telegram {
+		if cfg.Telegram[i].BotToken == "" || cfg.Telegram[i].ChatID == "" {
+			return cfg, fmt.Errorf("error parsing %s: telegram %d needs both bot_token and chat_id", path, i)
+		}
+		defaults(&cfg.Telegram[i].Timeout, &cfg.Telegram[i].Retries)
+	}
+	for i := range cfg.Discord {
+		if cfg.Discord[i].WebhookURL == "" {
+			return cfg, fmt.Errorf("error parsing %s: discord %d has no webhook_url", path, i)
+		}
+		defaults(&cfg.Discord[i].Timeout, &cfg.Discord[i].Retries)
+	}
+	for i := range cfg.Ntfy {
+		if cfg.Ntfy[i].Topic == "" {
+			return cfg, fmt.Errorf("error parsing %s: ntfy %d has no topic", path, i)
+		}
+		defaults(&cfg.Ntfy[i].Timeout, &cfg.Ntfy[i].Retries)
+	}
+	for i := range cfg.Email {
+		if err := ValidateEmail(cfg.Email[i]); err != nil {
+			return cfg, fmt.Errorf("error parsing %s: email %d: %w", path, i, err)
+		}
+		if cfg.Email[i].Timeout == 0 {
+			cfg.Email[i].Timeout = 30 * time.Second
+		}
+	}
+	for i := range cfg.Alertmanager {
+		if cfg.Alertmanager[i].URL == "" {
+			return cfg, fmt.Errorf("error parsing %s: alertmanager %d has no url", path, i)
+		}
+		defaults(&cfg.Alertmanager[i].Timeout, &cfg.Alertmanager[i].Retries)
+	}
+	return cfg, nil
+}
+
+// newNotifiers creates the configured notifiers.
+func (a *App) newNotifiers() ([]notifier, error) {
+	cfg := NotificationConfig{
+		Webhooks: a.cfg.webhooks,
+		Slack:    a.cfg.slacks,
+		Telegram: a.cfg.telegrams,
+		Discord:  a.cfg.discords,
+		Ntfy:     a.cfg.ntfys,
+		Email:    a.cfg.emails,
+
+		Alertmanager: a.cfg.alertmanagers,
+	}
+	if a.cfg.notificationConfigFile != "" {
+		fileCfg, err := loadNotificationConfigFile(a.cfg.notificationConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading notification config file: %w", err)
+		}
+		cfg.Webhooks = append(cfg.Webhooks[:len(cfg.Webhooks):len(cfg.Webhooks)], fileCfg.Webhooks...)
+		cfg.Slack = append(cfg.Slack[:len(cfg.Slack):len(cfg.Slack)], fileCfg.Slack...)
+		cfg.Telegram = append(cfg.Telegram[:len(cfg.Telegram):len(cfg.Telegram)], fileCfg.Telegram...)
+		cfg.Discord = append(cfg.Discord[:len(cfg.Discord):len(cfg.Discord)], fileCfg.Discord...)
+		cfg.Ntfy = append(cfg.Ntfy[:len(cfg.Ntfy):len(cfg.Ntfy)], fileCfg.Ntfy...)
+		cfg.Email = append(cfg.Email[:len(cfg.Email):len(cfg.Email)], fileCfg.Email...)
+		cfg.Alertmanager = append(cfg.Alertmanager[:len(cfg.Alertmanager):len(cfg.Alertmanager)], fileCfg.Alertmanager...)
+	}
+
+	var notifiers []notifier
+	for _, w := range cfg.Webhooks {
+		notifiers = append(notifiers, &webhookNotifier{logger: a.logger, cfg: w})
+	}
+	for _, s := range cfg.Slack {
+		notifiers = append(notifiers, &slackNotifier{logger: a.logger, cfg: s})
+	}
+	for _, t := range cfg.Telegram {
+		notifiers = append(notifiers, &telegramNotifier{logger: a.logger, cfg: t})
+	}
+	for _, d := range cfg.Discord {
+		notifiers = append(notifiers, &discordNotifier{logger: a.logger, cfg: d})
+	}
+	for _, n := range cfg.Ntfy {
+		notifiers = append(notifiers, &ntfyNotifier{logger: a.logger, cfg: n})
+	}
+	for _, e := range cfg.Email {
+		notifiers = append(notifiers, &emailNotifier{logger: a.logger, cfg: e, summary: a.Summary})
+	}
+	for _, am := range cfg.Alertmanager {
+		notifiers = append(notifiers, &alertmanagerNotifier{logger: a.logger, cfg: am})
+	}
+	return notifiers, nil
+}
+
+// notify informs all notifiers about the run. Failing notifiers are only
+// logged, as they shouldn't fail the run.
+func (a *App) notify(ctx context.Context, summary RunSummary) {
+	notifiers, err := a.newNotifiers()
+	if err != nil {
+		_ = level.Warn(a.logger).Log("msg", "error creating notifiers", "err", err)
+		return
+	}
+	for _, n := range notifiers {
+		if err := n.notify(ctx, summary); err != nil {
+			_ = level.Warn(a.logger).Log("msg", "error sending notification", "notifier", n.name(), "err", err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+// ntfyDefaultServer is the public ntfy server.
+const ntfyDefaultServer = "https://ntfy.sh"
+
+// Ntfy configures a topic of an ntfy server, which receives the run results
+// as push notifications.
+type Ntfy struct {
+	// Server is the URL of the ntfy server, by default https://ntfy.sh.
+	Server string `yaml:"server"`
+	Topic  string `yaml:"topic"`
+	// Username and Password authenticate with basic auth, alternatively an
+	// access token can be used.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Token    string `yaml:"token"`
+	// OnlyFailures skips the notifications about successful runs without
+	// alerts.
+	OnlyFailures bool          `yaml:"only_failures"`
+	Timeout      time.Duration `yaml:"timeout"`
+	Retries      int           `yaml:"retries"`
+}
+
+// WithNtfy publishes the run results to the ntfy topic.
+func WithNtfy(n Ntfy) NewOption {
+	return func(a *App) {
+		a.cfg.ntfys = append(a.cfg.ntfys, n)
+	}
+}
+
+type ntfyNotifier struct {
+	logger log.Logger
+	cfg    Ntfy
+}
+
+func (n *ntfyNotifier) name() string {
+	return "ntfy " + n.cfg.Topic
+}
+
+func (n *ntfyNotifier) url() string {
+	server := n.cfg.Server
+	if server == "" {
+		server = ntfyDefaultServer
+	}
+	return strings.TrimSuffix(server, "/") + "/" + n.cfg.Topic
+}
+
+func (n *ntfyNotifier) notify(ctx context.Context, summary RunSummary) error {
+	if n.cfg.OnlyFailures && !summary.needsAttention() {
+		return nil
+	}
+
+	title, priority, tags := "Thames Water import succeeded", "default", "white_check_mark"
+	if summary.Status == RunStatusFailure {
+		title, priority, tags = "Thames Water import failed", "high", "warning"
+	} else if len(summary.Alerts) > 0 {
+		title, priority, tags = "Thames Water alert", "high", "droplet"
+	}
+	body := summaryText(summary)
+
+	u := n.url()
+	client := &http.Client{Timeout: n.cfg.Timeout}
+	return doWithRetries(ctx, log.With(n.logger, "url", u), client, n.cfg.Retries, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Title", title)
+		req.Header.Set("Priority", priority)
+		req.Header.Set("Tags", tags)
+		if n.cfg.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+n.cfg.Token)
+		} else if n.cfg.Username != "" {
+			req.SetBasicAuth(n.cfg.Username, n.cfg.Password)
+		}
+		return req, nil
+	})
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+type parquetReading struct {
+	Timestamp int64    `parquet:"name=timestamp, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Meter     string   `parquet:"name=meter, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Usage     *float64 `parquet:"name=usage, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Read      float64  `parquet:"name=read, type=DOUBLE"`
+	Estimated *bool    `parquet:"name=estimated, type=BOOLEAN, repetitiontype=OPTIONAL"`
+}
+
+func writeParquetFile(path string, readings []Reading, known bool) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	pw, err := writer.NewParquetWriterFromWriter(f, new(parquetReading), 1)
+	if err != nil {
+		return err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	for _, r := range readings {
+		row := parquetReading{
+			Timestamp: timestamp.FromTime(r.Time),
+			Meter:     r.Meter,
+			Read:      r.Read,
+		}
+		if !math.IsNaN(r.Usage) {
+			usage := r.Usage
+			row.Usage = &usage
+		}
+		if known {
+			estimated := r.Estimated
+			row.Estimated = &estimated
+		}
+		if err := pw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return pw.WriteStop()
+}
+
+// ExportParquet writes the readings into Parquet files below dir, which are
+// partitioned by month using the Hive layout (month=YYYY-MM/readings.parquet).
+// Usage and estimated columns are null for readings from the TSDB, which
+// doesn't store them.
+func (a *App) ExportParquet(dir string, readings []Reading, fromTSDB bool) error {
+	months := make(map[string][]Reading)
+	for _, r := range readings {
+		month := r.Time.UTC().Format("2006-01")
+		months[month] = append(months[month], r)
+	}
+
+	keys := make([]string, 0, len(months))
+	for k := range months {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, month := range keys {
+		partition := filepath.Join(dir, fmt.Sprintf("month=%s", month))
+		if err := os.MkdirAll(partition, 0o755); err != nil {
+			return err
+		}
+		path := filepath.Join(partition, "readings.parquet")
+		if err := writeParquetFile(path, months[month], !fromTSDB); err != nil {
+			return fmt.Errorf("error writing %s: %w", path, err)
+		}
+		_ = level.Info(a.logger).Log("msg", "exported readings", "path", path, "readings", len(months[month]))
+	}
+
+	return nil
+}
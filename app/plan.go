@@ -0,0 +1,147 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/prometheus/model/timestamp"
+)
+
+// Reasons of the days of a Plan.
+const (
+	// PlanReasonMissing days are newer than the data in the local TSDB.
+	PlanReasonMissing = "missing"
+	// PlanReasonStored days are in the local TSDB already.
+	PlanReasonStored = "stored"
+	// PlanReasonOutsideRange days are outside the day range of the run.
+	PlanReasonOutsideRange = "outside range"
+	// PlanReasonHistory days are older than the data in the local TSDB,
+	// which only a backfill fetches.
+	PlanReasonHistory = "history"
+)
+
+// PlannedDay is a day of a meter, which has readings available.
+type PlannedDay struct {
+	Meter  string    `json:"meter"`
+	Day    time.Time `json:"day"`
+	Fetch  bool      `json:"fetch"`
+	Reason string    `json:"reason"`
+}
+
+// Plan lists the days of every meter a run would fetch, and those it would
+// skip, with the reason.
+type Plan struct {
+	Meters []string     `json:"meters"`
+	Days   []PlannedDay `json:"days"`
+}
+
+// Fetches returns the number of days, which would be fetched.
+func (p *Plan) Fetches() int {
+	var n int
+	for _, d := range p.Days {
+		if d.Fetch {
+			n++
+		}
+	}
+	return n
+}
+
+// Plan logs in and lists the meters and available days like a run, but only
+// returns the days the run would fetch, without fetching or changing
+// anything.
+func (a *App) Plan(ctx context.Context) (*Plan, error) {
+	if err := a.validateConfig(); err != nil {
+		return nil, err
+	}
+
+	provider, _, err := a.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.closeProvider(provider)
+
+	resp, err := a.listMeters(ctx, provider)
+	if err != nil {
+		return nil, withCategory(ErrorCategoryThamesWaterAPI, err)
+	}
+	if len(resp.Meters) == 0 {
+		return nil, fmt.Errorf("no meters found")
+	}
+
+	inRange := make(map[time.Time]struct{})
+	for _, day := range filterDays(ctx, resp.Days) {
+		inRange[day] = struct{}{}
+	}
+
+	plan := &Plan{Meters: a.importMeters(resp.Meters)}
+	for _, s := range a.importStreams(resp.Meters) {
+		minTime, err := storedMinTime(s)
+		if err != nil {
+			return nil, fmt.Errorf("error reading blocks of %s: %w", s.path, err)
+		}
+		oldest, err := storedOldestTime(s)
+		if err != nil {
+			return nil, fmt.Errorf("error reading blocks of %s: %w", s.path, err)
+		}
+		for _, meter := range s.meters {
+			for _, day := range resp.Days {
+				d := PlannedDay{Meter: meter, Day: day}
+				switch _, ok := inRange[day]; {
+				case !ok:
+					d.Reason = PlanReasonOutsideRange
+				case a.backfillHistory && beforeOldest(day, oldest):
+					d.Fetch = true
+					d.Reason = PlanReasonHistory
+				case !minTime.Before(day):
+					d.Reason = PlanReasonStored
+				default:
+					d.Fetch = true
+					d.Reason = PlanReasonMissing
+				}
+				plan.Days = append(plan.Days, d)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// storedOldestTime returns the time, from which on the stream contains data,
+// or the zero time without any blocks.
+func storedOldestTime(s stream) (time.Time, error) {
+	metas, err := listBlocks(s.path)
+	if err != nil || len(metas) == 0 {
+		return time.Time{}, err
+	}
+	oldest := metas[0].MinTime
+	for _, m := range metas[1:] {
+		if m.MinTime < oldest {
+			oldest = m.MinTime
+		}
+	}
+	return timestamp.Time(oldest), nil
+}
+
+// beforeOldest returns whether the day ends before the oldest data of a
+// stream, so it can be imported into blocks of its own.
+func beforeOldest(day, oldest time.Time) bool {
+	return !oldest.IsZero() && !day.AddDate(0, 0, 1).After(oldest)
+}
+
+// storedMinTime returns the time, up to which the stream contains data, like
+// importStream determines it from the blocks, which the stream consists of
+// between runs.
+func storedMinTime(s stream) (time.Time, error) {
+	metas, err := listBlocks(s.path)
+	if err != nil || len(metas) == 0 {
+		return time.Time{}, err
+	}
+	last := metas[0]
+	for _, m := range metas[1:] {
+		if m.MinTime > last.MinTime {
+			last = m
+		}
+	}
+	// block intervals are half-open
+	return timestamp.Time(last.MaxTime - 1), nil
+}
@@ -0,0 +1,287 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// PluginProtocolVersion is the version of the protocol spoken with plugins.
+//
+// Plugins are providers maintained out of tree, which run as a subprocess of
+// the importer. The importer writes requests as JSON objects, one per line,
+// to the plugin's stdin and reads a response per request from its stdout:
+//
+//	{"id":1,"method":"handshake","params":{"protocol_version":1}}
+//	{"id":1,"result":{"protocol_version":1}}
+//
+// Failed requests are answered with an error instead of a result:
+//
+//	{"id":2,"error":"invalid password"}
+//
+// The methods mirror the Provider interface:
+//
+//	handshake        {"protocol_version":1} -> {"protocol_version":1}
+//	login            {} -> {"account_number":"..."}
+//	list_meters      {} -> {"meters":["..."],"days":["2006-01-02"]}
+//	get_consumption  {"meter":"...","day":"2006-01-02","granularity":"60m"} ->
+//	                 {"readings":[{"time":"<RFC3339>","usage":1.5,"read":1.5,"estimated":false}]}
+//
+// The readings are labelled with the start of their interval, whose length is
+// the requested granularity, one of 60m, 30m or 1d.
+//
+// The plugin inherits the environment of the importer, which passes the
+// credentials. Lines written to stderr are logged. Once the run is done, the
+// importer closes stdin and the plugin is expected to exit.
+const PluginProtocolVersion = 1
+
+// pluginExitTimeout is how long a plugin gets to exit after stdin is closed,
+// before it is killed.
+const pluginExitTimeout = 5 * time.Second
+
+// Plugin configures a provider running as a subprocess.
+type Plugin struct {
+	// Name identifies the provider in logs and errors.
+	Name string
+	// Command of the plugin and its arguments.
+	Command []string
+}
+
+// WithPlugin imports the readings from the plugin, instead of Thames Water.
+func WithPlugin(p Plugin) NewOption {
+	return func(a *App) {
+		a.cfg.plugin = &p
+	}
+}
+
+type pluginRequest struct {
+	ID     int         `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+}
+
+type pluginResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+type pluginReading struct {
+	Time      time.Time `json:"time"`
+	Usage     float64   `json:"usage"`
+	Read      float64   `json:"read"`
+	Estimated bool      `json:"estimated"`
+}
+
+// pluginProvider speaks the plugin protocol with a subprocess, which is
+// started by the login and stopped by Close.
+type pluginProvider struct {
+	logger log.Logger
+	cfg    Plugin
+	// granularity of the readings requested from the plugin
+	granularity Granularity
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *json.Decoder
+	stderr sync.WaitGroup
+	lastID int
+}
+
+func (p *pluginProvider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *pluginProvider) Login(ctx context.Context) (string, error) {
+	// a retried login starts a fresh plugin
+	if err := p.Close(); err != nil {
+		_ = level.Warn(p.logger).Log("msg", "error stopping plugin", "err", err)
+	}
+	if err := p.start(); err != nil {
+		return "", err
+	}
+
+	var handshake struct {
+		ProtocolVersion int `json:"protocol_version"`
+	}
+	if err := p.call(ctx, "handshake", map[string]int{"protocol_version": PluginProtocolVersion}, &handshake); err != nil {
+		return "", err
+	}
+	if handshake.ProtocolVersion != PluginProtocolVersion {
+		return "", fmt.Errorf("plugin speaks protocol version %d, expected %d", handshake.ProtocolVersion, PluginProtocolVersion)
+	}
+
+	var resp struct {
+		AccountNumber string `json:"account_number"`
+	}
+	if err := p.call(ctx, "login", struct{}{}, &resp); err != nil {
+		return "", err
+	}
+	return resp.AccountNumber, nil
+}
+
+func (p *pluginProvider) ListMeters(ctx context.Context) (*MeterList, error) {
+	var resp struct {
+		Meters []string `json:"meters"`
+		Days   []string `json:"days"`
+	}
+	if err := p.call(ctx, "list_meters", struct{}{}, &resp); err != nil {
+		return nil, err
+	}
+
+	list := &MeterList{Meters: resp.Meters}
+	for _, d := range resp.Days {
+		day, err := time.Parse("2006-01-02", d)
+		if err != nil {
+			return nil, fmt.Errorf("invalid day: %w", err)
+		}
+		list.Days = append(list.Days, day)
+	}
+	return list, nil
+}
+
+func (p *pluginProvider) GetConsumption(ctx context.Context, meter string, day time.Time) ([]Reading, error) {
+	var resp struct {
+		Readings []pluginReading `json:"readings"`
+	}
+	if err := p.call(ctx, "get_consumption", map[string]string{
+		"meter":       meter,
+		"day":         day.Format("2006-01-02"),
+		"granularity": string(p.granularity),
+	}, &resp); err != nil {
+		return nil, err
+	}
+
+	readings := make([]Reading, len(resp.Readings))
+	for pos, r := range resp.Readings {
+		readings[pos] = Reading{
+			Time:      r.Time,
+			Meter:     meter,
+			Usage:     r.Usage,
+			Read:      r.Read,
+			Estimated: r.Estimated,
+		}
+	}
+	return readings, nil
+}
+
+func (p *pluginProvider) start() error {
+	if len(p.cfg.Command) == 0 {
+		return errors.New("no plugin command configured")
+	}
+
+	cmd := exec.Command(p.cfg.Command[0], p.cfg.Command[1:]...)
+	cmd.Env = os.Environ()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting plugin: %w", err)
+	}
+
+	p.stderr.Add(1)
+	go func() {
+		defer p.stderr.Done()
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			_ = level.Info(p.logger).Log("msg", scanner.Text(), "plugin", p.cfg.Name)
+		}
+	}()
+
+	p.cmd = cmd
+	p.stdin = stdin
+	p.stdout = json.NewDecoder(stdout)
+	return nil
+}
+
+// call sends a request to the plugin and decodes the result into v. The
+// plugin is killed, if the context is done before it responds.
+func (p *pluginProvider) call(ctx context.Context, method string, params, v interface{}) error {
+	if p.cmd == nil {
+		return errors.New("plugin not started")
+	}
+
+	p.lastID++
+	req := pluginRequest{ID: p.lastID, Method: method, Params: params}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if _, err := p.stdin.Write(append(body, '\n')); err != nil {
+		return fmt.Errorf("error sending %s request to plugin: %w", method, err)
+	}
+
+	respCh := make(chan error, 1)
+	var resp pluginResponse
+	go func() {
+		respCh <- p.stdout.Decode(&resp)
+	}()
+
+	select {
+	case <-ctx.Done():
+		// stop the plugin, which ends the pending decode, so later calls
+		// report it as not started
+		_ = p.cmd.Process.Kill()
+		_ = p.Close()
+		return ctx.Err()
+	case err := <-respCh:
+		if err != nil {
+			return fmt.Errorf("error reading %s response of plugin: %w", method, err)
+		}
+	}
+
+	if resp.ID != req.ID {
+		return fmt.Errorf("plugin answered request %d, expected %d", resp.ID, req.ID)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if err := json.Unmarshal(resp.Result, v); err != nil {
+		return fmt.Errorf("invalid %s response of plugin: %w", method, err)
+	}
+	return nil
+}
+
+// Close stops the plugin, by closing its stdin and killing it, if it doesn't
+// exit in time.
+func (p *pluginProvider) Close() error {
+	if p.cmd == nil {
+		return nil
+	}
+	cmd := p.cmd
+	p.cmd = nil
+
+	_ = p.stdin.Close()
+	exited := make(chan error, 1)
+	go func() {
+		p.stderr.Wait()
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-exited:
+		return err
+	case <-time.After(pluginExitTimeout):
+		_ = cmd.Process.Kill()
+		return fmt.Errorf("plugin didn't exit within %s", pluginExitTimeout)
+	}
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/thanos-io/thanos/pkg/objstore"
+)
+
+// prefixedBucket stores all objects below a prefix of the wrapped bucket, so
+// the importer can share a bucket with other data.
+type prefixedBucket struct {
+	bkt    objstore.Bucket
+	prefix string
+}
+
+func newPrefixedBucket(bkt objstore.Bucket, prefix string) objstore.Bucket {
+	prefix = strings.Trim(prefix, objstore.DirDelim)
+	if prefix == "" {
+		return bkt
+	}
+	return &prefixedBucket{bkt: bkt, prefix: prefix + objstore.DirDelim}
+}
+
+func (b *prefixedBucket) name(name string) string {
+	return b.prefix + name
+}
+
+func (b *prefixedBucket) Close() error {
+	return b.bkt.Close()
+}
+
+func (b *prefixedBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	prefixedDir := b.prefix
+	if dir != "" {
+		prefixedDir = b.name(dir)
+	}
+	return b.bkt.Iter(ctx, prefixedDir, func(name string) error {
+		return f(strings.TrimPrefix(name, b.prefix))
+	}, options...)
+}
+
+func (b *prefixedBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.bkt.Get(ctx, b.name(name))
+}
+
+func (b *prefixedBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return b.bkt.GetRange(ctx, b.name(name), off, length)
+}
+
+func (b *prefixedBucket) Exists(ctx context.Context, name string) (bool, error) {
+	return b.bkt.Exists(ctx, b.name(name))
+}
+
+func (b *prefixedBucket) IsObjNotFoundErr(err error) bool {
+	return b.bkt.IsObjNotFoundErr(err)
+}
+
+func (b *prefixedBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	return b.bkt.Attributes(ctx, b.name(name))
+}
+
+func (b *prefixedBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	return b.bkt.Upload(ctx, b.name(name), r)
+}
+
+func (b *prefixedBucket) Delete(ctx context.Context, name string) error {
+	return b.bkt.Delete(ctx, b.name(name))
+}
+
+func (b *prefixedBucket) Name() string {
+	return b.bkt.Name()
+}
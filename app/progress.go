@@ -0,0 +1,58 @@
+package app
+
+import "time"
+
+// Types of the Progress reported to the ProgressFunc.
+const (
+	ProgressRunStarted  = "run_started"
+	ProgressPhase       = "phase"
+	ProgressDay         = "day"
+	ProgressRunFinished = "run_finished"
+)
+
+// Progress describes a step of a run, for programs embedding the app to show
+// the progress of runs.
+type Progress struct {
+	Type string
+	// Phase the run is in, e.g. login, import, flush or upload.
+	Phase string
+	// Day and Meter, whose readings have been imported, for ProgressDay.
+	Day   time.Time
+	Meter string
+	// DaysDone and DaysTotal are the days of the meter imported so far and
+	// to be imported by the run, for ProgressDay.
+	DaysDone  int
+	DaysTotal int
+	// Samples appended to the local TSDB by the run so far.
+	Samples int
+	// Summary of the run, for ProgressRunFinished.
+	Summary *RunSummary
+}
+
+// ProgressFunc is called with the progress of runs.
+type ProgressFunc func(Progress)
+
+// WithProgressFunc reports the phase transitions of runs, the days imported
+// and the samples appended to f. It is called synchronously by the run, so it
+// needs to return quickly.
+func WithProgressFunc(f ProgressFunc) NewOption {
+	return func(a *App) {
+		a.progressFunc = f
+	}
+}
+
+func (a *App) reportProgress(p Progress) {
+	if a.progressFunc == nil {
+		return
+	}
+	if a.stats != nil {
+		p.Samples = a.stats.samples
+	}
+	a.progressFunc(p)
+}
+
+// startPhase starts the next phase of the run.
+func (a *App) startPhase(name string) {
+	a.stats.startPhase(name)
+	a.reportProgress(Progress{Type: ProgressPhase, Phase: name})
+}
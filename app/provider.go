@@ -0,0 +1,65 @@
+package app
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// Provider is the portal of a water supplier, the readings are imported from.
+// The methods are called by a single run at a time, which logs in first.
+// Providers implementing io.Closer are closed at the end of the run.
+type Provider interface {
+	// Name identifies the provider in logs and errors.
+	Name() string
+	// Login logs into the account and returns the account number.
+	Login(ctx context.Context) (string, error)
+	// ListMeters returns the meters of the account and the days with
+	// readings available.
+	ListMeters(ctx context.Context) (*MeterList, error)
+	// GetConsumption returns the interval readings of the meter on the day.
+	GetConsumption(ctx context.Context, meter string, day time.Time) ([]Reading, error)
+}
+
+// MeterList are the meters of an account.
+type MeterList struct {
+	Meters []string
+	// Days with readings available, oldest first.
+	Days []time.Time
+}
+
+// WithProvider imports the readings from the provider, instead of Thames
+// Water.
+func WithProvider(p Provider) NewOption {
+	return func(a *App) {
+		a.cfg.provider = p
+	}
+}
+
+// newProvider returns the configured provider, or a new Thames Water one.
+func (a *App) newProvider() Provider {
+	if a.cfg.provider != nil {
+		return a.cfg.provider
+	}
+	if a.cfg.plugin != nil {
+		return &pluginProvider{logger: a.logger, cfg: *a.cfg.plugin, granularity: a.cfg.granularity}
+	}
+	if a.cfg.anglianWater != nil {
+		return &anglianWaterProvider{app: a, cfg: *a.cfg.anglianWater}
+	}
+	return &thamesWaterProvider{app: a}
+}
+
+// closeProvider releases the resources of providers implementing io.Closer,
+// like the subprocess of a plugin, once a run is done with them.
+func (a *App) closeProvider(p Provider) {
+	c, ok := p.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := c.Close(); err != nil {
+		_ = level.Warn(a.logger).Log("msg", "error closing provider", "provider", p.Name(), "err", err)
+	}
+}
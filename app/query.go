@@ -0,0 +1,188 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+// querySamples calls f for every sample of the series matching the matchers in
+// the local TSDB within [from, to). The TSDB is opened read-only, so this is
+// safe to use while an import is running.
+func (a *App) querySamples(ctx context.Context, from, to time.Time, matchers []*labels.Matcher, f func(lbls labels.Labels, t time.Time, v float64)) error {
+	streams, err := a.localStreams()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	mint, maxt := timestamp.FromTime(from), timestamp.FromTime(to)-1
+
+	for _, s := range streams {
+		if err := a.queryStreamSamples(ctx, s, mint, maxt, matchers, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *App) queryStreamSamples(ctx context.Context, s stream, mint, maxt int64, matchers []*labels.Matcher, f func(lbls labels.Labels, t time.Time, v float64)) error {
+	// runs leave the stream without a WAL, which the read-only DB requires
+	if _, err := os.Stat(filepath.Join(s.path, "wal")); errors.Is(err, os.ErrNotExist) {
+		return a.queryBlockSamples(s, mint, maxt, matchers, f)
+	}
+
+	db, err := tsdb.OpenDBReadOnly(s.path, &logLevelOverride{next: a.logger, level: level.DebugValue()})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	q, err := db.Querier(ctx, mint, maxt)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	return selectSamples(q, matchers, f)
+}
+
+// queryBlockSamples queries the blocks of the stream one at a time.
+func (a *App) queryBlockSamples(s stream, mint, maxt int64, matchers []*labels.Matcher, f func(lbls labels.Labels, t time.Time, v float64)) error {
+	metas, err := listBlocks(s.path)
+	if err != nil {
+		return err
+	}
+	for _, m := range metas {
+		if m.MaxTime <= mint || m.MinTime > maxt {
+			continue
+		}
+		b, err := tsdb.OpenBlock(&logLevelOverride{next: a.logger, level: level.DebugValue()}, filepath.Join(s.path, m.ULID.String()), nil)
+		if err != nil {
+			return err
+		}
+		q, err := tsdb.NewBlockQuerier(b, mint, maxt)
+		if err != nil {
+			_ = b.Close()
+			return err
+		}
+		err = selectSamples(q, matchers, f)
+		_ = q.Close()
+		_ = b.Close()
+		if err != nil {
+			return fmt.Errorf("error querying block %s: %w", m.ULID, err)
+		}
+	}
+	return nil
+}
+
+// selectSamples calls f with every sample of the series matching the matchers.
+func selectSamples(q storage.Querier, matchers []*labels.Matcher, f func(lbls labels.Labels, t time.Time, v float64)) error {
+	ss := q.Select(false, nil, matchers...)
+	for ss.Next() {
+		s := ss.At()
+		it := s.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			f(s.Labels(), timestamp.Time(t).UTC(), v)
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+	return ss.Err()
+}
+
+// DailyConsumption is the consumption of a meter on a single day.
+type DailyConsumption struct {
+	Date   time.Time `json:"date"`
+	Meter  string    `json:"meter"`
+	Liters float64   `json:"liters"`
+	// CostGBP is the cost according to the configured tariffs, including
+	// the standing charge.
+	CostGBP float64 `json:"cost_gbp,omitempty"`
+}
+
+// Summary summarizes the consumption stored in the local TSDB.
+type Summary struct {
+	From         time.Time          `json:"from"`
+	To           time.Time          `json:"to"`
+	Days         []DailyConsumption `json:"days"`
+	TotalLiters  float64            `json:"total_liters"`
+	TotalCostGBP float64            `json:"total_cost_gbp,omitempty"`
+}
+
+// dailyConsumption sums up the consumption per meter and day within [from,
+// to).
+func (a *App) dailyConsumption(ctx context.Context, from, to time.Time) ([]DailyConsumption, error) {
+	type key struct {
+		date  time.Time
+		meter string
+	}
+	totals := make(map[key]*DailyConsumption)
+
+	if err := a.querySamples(ctx, from, to, []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, consumptionMetricName+"|"+costMetricName),
+	}, func(lbls labels.Labels, t time.Time, v float64) {
+		k := key{date: truncateDay(t), meter: lbls.Get("meter")}
+		d, ok := totals[k]
+		if !ok {
+			d = &DailyConsumption{Date: k.date, Meter: k.meter}
+			totals[k] = d
+		}
+		if lbls.Get(labels.MetricName) == costMetricName {
+			d.CostGBP += v
+		} else {
+			d.Liters += v
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	days := make([]DailyConsumption, 0, len(totals))
+	for _, d := range totals {
+		days = append(days, *d)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		if !days[i].Date.Equal(days[j].Date) {
+			return days[i].Date.Before(days[j].Date)
+		}
+		return days[i].Meter < days[j].Meter
+	})
+	return days, nil
+}
+
+// Summary returns the daily and total consumption within [from, to) from the
+// local TSDB.
+func (a *App) Summary(ctx context.Context, from, to time.Time) (*Summary, error) {
+	days, err := a.dailyConsumption(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Summary{
+		From: from,
+		To:   to,
+		Days: days,
+	}
+	for _, d := range days {
+		s.TotalLiters += d.Liters
+		s.TotalCostGBP += d.CostGBP
+	}
+	return s, nil
+}
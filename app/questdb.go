@@ -0,0 +1,144 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// QuestDB configures a QuestDB instance, which receives all samples imported
+// by a run in the InfluxDB line protocol. Every metric becomes a table, with
+// the labels as symbol columns.
+type QuestDB struct {
+	// Address is either tcp://host:9009 for the line protocol over TCP or
+	// http://host:9000 for its HTTP endpoint.
+	Address string
+	// Timeout of a single connection or request.
+	Timeout time.Duration
+	// Retries of a failed HTTP request.
+	Retries int
+}
+
+// WithQuestDB sends the imported samples to QuestDB.
+func WithQuestDB(q QuestDB) NewOption {
+	return func(a *App) {
+		a.cfg.questDBs = append(a.cfg.questDBs, q)
+	}
+}
+
+type questDBSink struct {
+	logger log.Logger
+	cfg    QuestDB
+	url    *url.URL
+	client *http.Client
+
+	lines []string
+}
+
+func newQuestDBSink(logger log.Logger, cfg QuestDB) (*questDBSink, error) {
+	u, err := url.Parse(cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid QuestDB address: %w", err)
+	}
+	if u.Scheme != "tcp" && u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid QuestDB address '%s', expected tcp://, http:// or https://", cfg.Address)
+	}
+	return &questDBSink{
+		logger: logger,
+		cfg:    cfg,
+		url:    u,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+func (s *questDBSink) Name() string {
+	return "QuestDB " + s.cfg.Address
+}
+
+func (s *questDBSink) Close() error {
+	return nil
+}
+
+// AppendSample skips NaN values, which the line protocol can't represent.
+func (s *questDBSink) AppendSample(lbls labels.Labels, t int64, v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	if s.url.Scheme == "tcp" {
+		// the TCP receiver expects nanoseconds
+		t *= int64(time.Millisecond)
+	}
+	s.lines = append(s.lines, influxLine(lbls, t, v))
+}
+
+func (s *questDBSink) Flush(ctx context.Context) error {
+	if len(s.lines) == 0 {
+		return nil
+	}
+
+	var err error
+	if s.url.Scheme == "tcp" {
+		err = s.sendTCP(ctx)
+	} else {
+		err = s.sendHTTP(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	_ = level.Info(s.logger).Log("msg", "sent samples to QuestDB", "address", s.cfg.Address, "samples", len(s.lines))
+	s.lines = nil
+	return nil
+}
+
+// sendTCP writes all lines over a single connection. The TCP receiver doesn't
+// acknowledge lines, so errors only surface as closed connections.
+func (s *questDBSink) sendTCP(ctx context.Context) error {
+	d := net.Dialer{Timeout: s.cfg.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", s.url.Host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	w := bufio.NewWriter(conn)
+	for _, l := range s.lines {
+		if _, err := w.WriteString(l + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (s *questDBSink) sendHTTP(ctx context.Context) error {
+	u := *s.url
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/write"
+	u.RawQuery = url.Values{"precision": []string{"ms"}}.Encode()
+	body := []byte(strings.Join(s.lines, "\n") + "\n")
+
+	return doWithRetries(ctx, log.With(s.logger, "address", s.cfg.Address), s.client, s.cfg.Retries, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		return req, nil
+	})
+}
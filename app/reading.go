@@ -0,0 +1,140 @@
+package app
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// Reading is a single interval reading of a meter.
+type Reading struct {
+	Time  time.Time
+	Meter string
+	// Usage is the usage the provider reports alongside the reading, which
+	// is passed on to the sinks exporting it. It is NaN for readings read
+	// back from the TSDB, which only stores Read.
+	Usage float64
+	// Read is the consumption within the interval in liters, which is
+	// stored as water_consumption_liters. The costs, emissions, totals and
+	// alerts are all derived from it.
+	Read      float64
+	Estimated bool
+}
+
+// FetchReadings logs into the account of the provider and fetches the readings of
+// all meters for the available days within [from, to).
+func (a *App) FetchReadings(ctx context.Context, from, to time.Time) ([]Reading, error) {
+	var readings []Reading
+	if err := a.fetchDays(ctx, from, to, func(_ string, dayReadings []Reading) error {
+		readings = append(readings, dayReadings...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+// fetchDays logs into the account of the provider and calls f with the
+// account number and the readings of every meter and available day within
+// [from, to).
+func (a *App) fetchDays(ctx context.Context, from, to time.Time, f func(accountNumber string, readings []Reading) error) error {
+	provider, accountNumber, err := a.login(ctx)
+	if err != nil {
+		return err
+	}
+	defer a.closeProvider(provider)
+
+	resp, err := a.listMeters(ctx, provider)
+	if err != nil {
+		return err
+	}
+
+	for _, day := range resp.Days {
+		if day.Before(truncateDay(from)) || !day.Before(to) {
+			continue
+		}
+		for _, meter := range resp.Meters {
+			_ = level.Debug(a.logger).Log("msg", "daily reading", "meter", meter, "date", day.Format("2006-01-02"))
+			dayReadings, err := a.getConsumption(ctx, provider, meter, day)
+			if err != nil {
+				return err
+			}
+			if err := f(accountNumber, dayReadings); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// StoredReadings reads back the readings within [from, to) from the local
+// TSDB.
+func (a *App) StoredReadings(ctx context.Context, from, to time.Time) ([]Reading, error) {
+	var readings []Reading
+	if err := a.querySamples(ctx, from, to, []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, consumptionMetricName),
+	}, func(lbls labels.Labels, t time.Time, v float64) {
+		readings = append(readings, Reading{
+			Time:  t,
+			Meter: lbls.Get("meter"),
+			Usage: math.NaN(),
+			Read:  v,
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(readings, func(i, j int) bool {
+		if !readings[i].Time.Equal(readings[j].Time) {
+			return readings[i].Time.Before(readings[j].Time)
+		}
+		return readings[i].Meter < readings[j].Meter
+	})
+	return readings, nil
+}
+
+// UsagePeriod is the consumption of a meter within a day or an hour.
+type UsagePeriod struct {
+	Start  time.Time `json:"start"`
+	Meter  string    `json:"meter"`
+	Liters float64   `json:"liters"`
+	// Estimated is set, if any of the readings has been estimated.
+	Estimated bool `json:"estimated,omitempty"`
+}
+
+// GroupReadings sums up the readings per meter and period of the given
+// length, e.g. 24h for days. The periods are sorted by meter and start.
+func GroupReadings(readings []Reading, period time.Duration) []UsagePeriod {
+	type key struct {
+		start time.Time
+		meter string
+	}
+	totals := make(map[key]*UsagePeriod)
+	for _, r := range readings {
+		k := key{start: r.Time.UTC().Truncate(period), meter: r.Meter}
+		p, ok := totals[k]
+		if !ok {
+			p = &UsagePeriod{Start: k.start, Meter: k.meter}
+			totals[k] = p
+		}
+		p.Liters += r.Read
+		p.Estimated = p.Estimated || r.Estimated
+	}
+
+	periods := make([]UsagePeriod, 0, len(totals))
+	for _, p := range totals {
+		periods = append(periods, *p)
+	}
+	sort.Slice(periods, func(i, j int) bool {
+		if periods[i].Meter != periods[j].Meter {
+			return periods[i].Meter < periods[j].Meter
+		}
+		return periods[i].Start.Before(periods[j].Start)
+	})
+	return periods
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// recentLogs keeps the last log lines of a run, so failure notifications can
+// include them.
+type recentLogs struct {
+	size int
+
+	mtx   sync.Mutex
+	lines []string
+}
+
+func newRecentLogs(size int) *recentLogs {
+	return &recentLogs{size: size}
+}
+
+// Log keeps the line, unless it is a debug line. The recent lines end up in
+// notifications sent to third parties, so they skip the debug noise, which
+// the level filter of the logger may drop, and never keep the email of the
+// account.
+func (r *recentLogs) Log(keyvals ...interface{}) error {
+	kept := []interface{}{"ts", time.Now().UTC().Format(time.RFC3339)}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] == level.Key() && keyvals[i+1] == level.DebugValue() {
+			return nil
+		}
+		if keyvals[i] == "email" {
+			continue
+		}
+		kept = append(kept, keyvals[i], keyvals[i+1])
+	}
+
+	var buf bytes.Buffer
+	if err := log.NewLogfmtLogger(&buf).Log(kept...); err != nil {
+		return err
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.lines = append(r.lines, strings.TrimSpace(buf.String()))
+	if len(r.lines) > r.size {
+		r.lines = r.lines[len(r.lines)-r.size:]
+	}
+	return nil
+}
+
+func (r *recentLogs) reset() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.lines = nil
+}
+
+func (r *recentLogs) get() []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]string(nil), r.lines...)
+}
+
+// teeLogger logs to both loggers.
+type teeLogger struct {
+	log.Logger
+	recent *recentLogs
+}
+
+func (t teeLogger) Log(keyvals ...interface{}) error {
+	_ = t.recent.Log(keyvals...)
+	return t.Logger.Log(keyvals...)
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/storage"
+	"gopkg.in/yaml.v2"
+)
+
+// WithRelabelConfigs relabels the series of the readings, like Prometheus'
+// relabel_configs, before their samples reach the local TSDB or any sink.
+// Series dropped by the configs aren't written at all. The configs need to be
+// valid, like those parsed from YAML. The configs must not change the meter
+// label or the metric name, as the stored series are read back by them.
+func WithRelabelConfigs(cfgs ...*relabel.Config) NewOption {
+	return func(a *App) {
+		a.cfg.relabelConfigs = append(a.cfg.relabelConfigs, cfgs...)
+	}
+}
+
+// WithRelabelConfigFile reads relabel configs from a YAML file with the
+// relabel_configs of a Prometheus scrape config, e.g. to replace the account
+// number with a hash of it:
+//
+//	relabel_configs:
+//	  - source_labels: [account]
+//	    action: hashmod
+//	    modulus: 18446744073709551615
+//	    target_label: account
+//
+// The file is read again, whenever the configuration is reloaded.
+func WithRelabelConfigFile(path string) NewOption {
+	return func(a *App) {
+		a.cfg.relabelConfigFile = path
+	}
+}
+
+func loadRelabelConfigFile(path string) ([]*relabel.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		RelabelConfigs []*relabel.Config `yaml:"relabel_configs"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	for i, cfg := range file.RelabelConfigs {
+		if cfg == nil {
+			return nil, fmt.Errorf("error parsing %s: empty relabel config %d", path, i)
+		}
+	}
+	return file.RelabelConfigs, nil
+}
+
+// protectedRelabelLabels are the labels the relabel configs must not change,
+// as the readers, e.g. verify-data and export, and the internal metrics
+// select the series by them.
+var protectedRelabelLabels = []string{labels.MetricName, "meter"}
+
+// validateRelabelConfigs rejects relabel configs, which change or drop the
+// protected labels. Configs mapping labels are checked, when the series are
+// relabeled.
+func validateRelabelConfigs(cfgs []*relabel.Config) error {
+	for i, cfg := range cfgs {
+		for _, name := range protectedRelabelLabels {
+			var changes bool
+			switch cfg.Action {
+			case relabel.Replace, relabel.HashMod:
+				changes = cfg.TargetLabel == name
+			case relabel.LabelDrop:
+				changes = cfg.Regex.MatchString(name)
+			case relabel.LabelKeep:
+				changes = !cfg.Regex.MatchString(name)
+			}
+			if changes {
+				return fmt.Errorf("relabel config %d changes the label %s, which can't be relabeled", i, name)
+			}
+		}
+	}
+	return nil
+}
+
+// relabelAppender relabels the series of the samples before appending them.
+type relabelAppender struct {
+	storage.Appender
+	cfgs []*relabel.Config
+}
+
+// relabelAppender wraps the appender, if any relabel configs are configured.
+func (a *App) relabelAppender(next storage.Appender) storage.Appender {
+	if a.resolved == nil || len(a.resolved.relabelConfigs) == 0 {
+		return next
+	}
+	return &relabelAppender{Appender: next, cfgs: a.resolved.relabelConfigs}
+}
+
+func (r *relabelAppender) Append(_ storage.SeriesRef, orig labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	l := relabel.Process(orig, r.cfgs...)
+	if l == nil {
+		return 0, nil
+	}
+	for _, name := range protectedRelabelLabels {
+		if before, after := orig.Get(name), l.Get(name); before != after {
+			return 0, fmt.Errorf("relabeling changed the label %s from '%s' to '%s', which can't be relabeled", name, before, after)
+		}
+	}
+	// the reference belongs to the series before relabeling
+	return r.Appender.Append(0, l, t, v)
+}
+
+// relabelReadings relabels the consumption series of the readings for the
+// sinks receiving the readings. The readings of dropped series are removed,
+// the meter is kept as the relabel configs can't change it.
+func (a *App) relabelReadings(lbls labels.Labels, readings []Reading) []Reading {
+	if a.resolved == nil || len(a.resolved.relabelConfigs) == 0 {
+		return readings
+	}
+	result := make([]Reading, 0, len(readings))
+	for _, r := range readings {
+		if relabel.Process(a.meterSeriesLabels(lbls, r.Meter), a.resolved.relabelConfigs...) == nil {
+			continue
+		}
+		result = append(result, r)
+	}
+	return result
+}
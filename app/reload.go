@@ -0,0 +1,93 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// Reload loads the config files again, to report errors right away, e.g.
+// after a SIGHUP or a change of a config file. The config files are read at
+// the start of every run, so a valid configuration is used from the next run
+// on, while an in-flight run keeps its configuration.
+func (a *App) Reload() error {
+	_, err := a.resolveConfig()
+	if err == nil {
+		_, err = a.newNotifiers()
+	}
+	if err == nil && a.cfg.remoteWriteConfigFile != "" {
+		_, err = loadRemoteWriteConfigFile(a.cfg.remoteWriteConfigFile)
+	}
+	a.metrics.observeReload(err)
+	if err != nil {
+		_ = level.Error(a.logger).Log("msg", "error reloading configuration", "err", err)
+		return err
+	}
+	_ = level.Info(a.logger).Log("msg", "reloaded configuration")
+	return nil
+}
+
+// configFiles returns the config files, which are read by every run.
+func (a *App) configFiles() []string {
+	var files []string
+	for _, path := range append([]string{
+		a.cfg.externalLabelsFile,
+		a.cfg.tariffFile,
+		a.cfg.emissionFactorFile,
+		a.cfg.notificationConfigFile,
+		a.cfg.remoteWriteConfigFile,
+		a.cfg.thanosBucketConfigFile,
+	}, a.cfg.mirrorBucketConfigFiles...) {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// watchConfigFiles reloads the configuration, whenever one of the config
+// files changes, until the context is done. The files are polled, as
+// editors and Kubernetes replace rather than write config files.
+func (a *App) watchConfigFiles(ctx context.Context, interval time.Duration) {
+	files := a.configFiles()
+	if len(files) == 0 {
+		return
+	}
+
+	stat := func() map[string]string {
+		versions := make(map[string]string, len(files))
+		for _, path := range files {
+			fi, err := os.Stat(path)
+			if err != nil {
+				versions[path] = err.Error()
+				continue
+			}
+			versions[path] = fmt.Sprintf("%d-%d", fi.ModTime().UnixNano(), fi.Size())
+		}
+		return versions
+	}
+
+	last := stat()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := stat()
+		for _, path := range files {
+			if current[path] != last[path] {
+				_ = level.Info(a.logger).Log("msg", "config file changed", "path", path)
+				_ = a.Reload()
+				break
+			}
+		}
+		last = current
+	}
+}
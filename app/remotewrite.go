@@ -0,0 +1,259 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"gopkg.in/yaml.v2"
+)
+
+// RemoteWrite configures a Prometheus remote write endpoint, which receives
+// all samples imported by a run.
+type RemoteWrite struct {
+	URL string `yaml:"url"`
+	// Timeout of a single request.
+	Timeout time.Duration `yaml:"timeout"`
+	// BatchSize is the maximum number of samples sent per request.
+	BatchSize int `yaml:"batch_size"`
+	// Retries of a failed request, which are delayed with an exponential
+	// backoff. Client errors other than 429 are not retried.
+	Retries int `yaml:"retries"`
+
+	// Headers are added to every request, e.g. X-Scope-OrgID to select the
+	// tenant of Mimir or Cortex.
+	Headers   map[string]string `yaml:"headers"`
+	BasicAuth *BasicAuth        `yaml:"basic_auth"`
+	// BearerToken is sent in the Authorization header.
+	BearerToken string `yaml:"bearer_token"`
+}
+
+// BasicAuth configures HTTP basic authentication.
+type BasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// WithRemoteWrite sends the imported samples to the remote write endpoint.
+// The local TSDB is still maintained, to keep track of the imported days.
+func WithRemoteWrite(rw RemoteWrite) NewOption {
+	return func(a *App) {
+		a.cfg.remoteWrites = append(a.cfg.remoteWrites, rw)
+	}
+}
+
+// grafanaCloudPushPath is the remote write path of Grafana Cloud's hosted
+// Prometheus.
+const grafanaCloudPushPath = "/api/prom/push"
+
+// GrafanaCloud configures the remote write to Grafana Cloud's hosted
+// Prometheus.
+type GrafanaCloud struct {
+	// URL of the Prometheus instance, either with or without the push path.
+	URL string
+	// InstanceID is the numeric user of the Prometheus instance.
+	InstanceID string
+	// APIKey needs the MetricsPublisher role.
+	APIKey string
+}
+
+// RemoteWrite returns the remote write configuration for Grafana Cloud. The
+// requests are kept small and retried patiently, as the free tier limits the
+// ingestion rate.
+func (g GrafanaCloud) RemoteWrite() (RemoteWrite, error) {
+	u, err := url.Parse(g.URL)
+	if err != nil {
+		return RemoteWrite{}, fmt.Errorf("invalid Grafana Cloud URL: %w", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return RemoteWrite{}, fmt.Errorf("invalid Grafana Cloud URL '%s', expected https://<host>", g.URL)
+	}
+	if !strings.HasSuffix(u.Path, grafanaCloudPushPath) {
+		u.Path = strings.TrimSuffix(u.Path, "/") + grafanaCloudPushPath
+	}
+	if g.InstanceID == "" || g.APIKey == "" {
+		return RemoteWrite{}, errors.New("both instance ID and API key are required for Grafana Cloud")
+	}
+
+	return RemoteWrite{
+		URL:       u.String(),
+		Timeout:   30 * time.Second,
+		BatchSize: 1000,
+		Retries:   5,
+		BasicAuth: &BasicAuth{
+			Username: g.InstanceID,
+			Password: g.APIKey,
+		},
+	}, nil
+}
+
+// WithRemoteWriteConfigFile reads a YAML list of remote write endpoints from
+// the file, which is read again on every run.
+func WithRemoteWriteConfigFile(path string) NewOption {
+	return func(a *App) {
+		a.cfg.remoteWriteConfigFile = path
+	}
+}
+
+// loadRemoteWriteConfigFile reads the remote write endpoints from the file.
+// Unset settings use the same defaults as the command line flags.
+func loadRemoteWriteConfigFile(path string) ([]RemoteWrite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rws []RemoteWrite
+	if err := yaml.UnmarshalStrict(data, &rws); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	for i := range rws {
+		if rws[i].URL == "" {
+			return nil, fmt.Errorf("error parsing %s: remote write endpoint %d has no url", path, i)
+		}
+		if rws[i].Timeout == 0 {
+			rws[i].Timeout = 30 * time.Second
+		}
+		if rws[i].Retries == 0 {
+			rws[i].Retries = 3
+		}
+	}
+	return rws, nil
+}
+
+type remoteWriteSink struct {
+	logger log.Logger
+	cfg    RemoteWrite
+	client *http.Client
+
+	series map[string]*prompb.TimeSeries
+}
+
+func newRemoteWriteSink(logger log.Logger, cfg RemoteWrite) *remoteWriteSink {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	return &remoteWriteSink{
+		logger: logger,
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		series: make(map[string]*prompb.TimeSeries),
+	}
+}
+
+func (s *remoteWriteSink) Name() string {
+	return "remote write " + s.cfg.URL
+}
+
+func (s *remoteWriteSink) Close() error {
+	return nil
+}
+
+func (s *remoteWriteSink) AppendSample(lbls labels.Labels, t int64, v float64) {
+	key := lbls.String()
+	ts, ok := s.series[key]
+	if !ok {
+		ts = &prompb.TimeSeries{Labels: make([]prompb.Label, 0, len(lbls))}
+		for _, l := range lbls {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: l.Name, Value: l.Value})
+		}
+		s.series[key] = ts
+	}
+	ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: t, Value: v})
+}
+
+// Flush sends the samples in batches, one request at a time, so a slow
+// endpoint slows down the sink instead of being overwhelmed.
+func (s *remoteWriteSink) Flush(ctx context.Context) error {
+	keys := make([]string, 0, len(s.series))
+	for k := range s.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var (
+		req     prompb.WriteRequest
+		samples int
+		total   int
+	)
+	send := func() error {
+		if samples == 0 {
+			return nil
+		}
+		if err := s.send(ctx, &req); err != nil {
+			return err
+		}
+		total += samples
+		req.Timeseries = req.Timeseries[:0]
+		samples = 0
+		return nil
+	}
+
+	for _, k := range keys {
+		ts := s.series[k]
+		sort.Slice(ts.Samples, func(i, j int) bool {
+			return ts.Samples[i].Timestamp < ts.Samples[j].Timestamp
+		})
+		for rest := ts.Samples; len(rest) > 0; {
+			n := s.cfg.BatchSize - samples
+			if n > len(rest) {
+				n = len(rest)
+			}
+			req.Timeseries = append(req.Timeseries, prompb.TimeSeries{Labels: ts.Labels, Samples: rest[:n]})
+			samples += n
+			rest = rest[n:]
+			if samples >= s.cfg.BatchSize {
+				if err := send(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := send(); err != nil {
+		return err
+	}
+
+	s.series = make(map[string]*prompb.TimeSeries)
+	_ = level.Info(s.logger).Log("msg", "sent samples via remote write", "url", s.cfg.URL, "samples", total)
+	return nil
+}
+
+func (s *remoteWriteSink) send(ctx context.Context, req *prompb.WriteRequest) error {
+	data, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+	body := snappy.Encode(nil, data)
+
+	return doWithRetries(ctx, log.With(s.logger, "url", s.cfg.URL), s.client, s.cfg.Retries, func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header.Set("Content-Encoding", "snappy")
+		httpReq.Header.Set("Content-Type", "application/x-protobuf")
+		httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+		for name, value := range s.cfg.Headers {
+			httpReq.Header.Set(name, value)
+		}
+		if s.cfg.BasicAuth != nil {
+			httpReq.SetBasicAuth(s.cfg.BasicAuth.Username, s.cfg.BasicAuth.Password)
+		}
+		if s.cfg.BearerToken != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+s.cfg.BearerToken)
+		}
+		return httpReq, nil
+	})
+}
@@ -0,0 +1,240 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/go-kit/log/level"
+)
+
+// Phases of the pipeline, which are retried by their own RetryPolicy.
+const (
+	// PhaseLogin is the login into the account of the provider.
+	PhaseLogin = "login"
+	// PhaseFetch are the requests for the meters and their readings.
+	PhaseFetch = "fetch"
+	// PhaseWrite is the flush of the samples to each sink.
+	PhaseWrite = "write"
+	// PhaseUpload is the upload of the blocks to each bucket.
+	PhaseUpload = "upload"
+)
+
+// RetryPolicy configures how a phase of the pipeline is retried.
+type RetryPolicy struct {
+	// Retries after the first attempt. With a MaxElapsed, 0 retries until
+	// it has passed.
+	Retries int
+	// Delay before the first retry, which doubles with every further retry
+	// up to the MaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+	// MaxElapsed, if set, stops retrying once the time has passed since the
+	// first attempt.
+	MaxElapsed time.Duration
+	// CircuitBreakerThreshold, if set, opens the circuit after as many
+	// consecutive failures of the phase, despite its retries. While the
+	// circuit is open, the phase fails right away, until the
+	// CircuitBreakerCooldown has passed and an attempt is let through again.
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+}
+
+// defaultRetryPolicies keep the retries of the versions before the policies:
+// the login is retried quickly, the upload with a longer delay and fetches
+// and writes not at all.
+func defaultRetryPolicies() map[string]RetryPolicy {
+	return map[string]RetryPolicy{
+		PhaseLogin:  {Retries: 9, Delay: 100 * time.Millisecond},
+		PhaseFetch:  {},
+		PhaseWrite:  {},
+		PhaseUpload: {Retries: 3, Delay: 30 * time.Second},
+	}
+}
+
+// WithRetryPolicy sets the retries of a phase, one of PhaseLogin, PhaseFetch,
+// PhaseWrite or PhaseUpload.
+func WithRetryPolicy(phase string, p RetryPolicy) NewOption {
+	return func(a *App) {
+		a.cfg.retryPolicies[phase] = p
+	}
+}
+
+// ParseRetryPolicy parses a policy in the form <phase>:<key>=<value>,...,
+// e.g. upload:max-elapsed=1h,max-delay=5m or
+// fetch:retries=3,delay=1s,circuit-breaker-threshold=5,circuit-breaker-cooldown=6h.
+// Keys not given keep the default of the phase.
+func ParseRetryPolicy(s string) (string, RetryPolicy, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return "", RetryPolicy{}, fmt.Errorf("invalid retry policy '%s', expected <phase>:<key>=<value>,...", s)
+	}
+	phase := strings.TrimSpace(parts[0])
+	p, ok := defaultRetryPolicies()[phase]
+	if !ok {
+		return "", RetryPolicy{}, fmt.Errorf("invalid retry policy '%s', the phase must be one of %s, %s, %s or %s", s, PhaseLogin, PhaseFetch, PhaseWrite, PhaseUpload)
+	}
+
+	for _, setting := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(setting, "=", 2)
+		if len(kv) != 2 {
+			return "", RetryPolicy{}, fmt.Errorf("invalid retry policy '%s', expected <key>=<value> in '%s'", s, setting)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+
+		var err error
+		switch key {
+		case "retries":
+			p.Retries, err = strconv.Atoi(value)
+		case "circuit-breaker-threshold":
+			p.CircuitBreakerThreshold, err = strconv.Atoi(value)
+		case "delay":
+			p.Delay, err = time.ParseDuration(value)
+		case "max-delay":
+			p.MaxDelay, err = time.ParseDuration(value)
+		case "max-elapsed":
+			p.MaxElapsed, err = time.ParseDuration(value)
+		case "circuit-breaker-cooldown":
+			p.CircuitBreakerCooldown, err = time.ParseDuration(value)
+		default:
+			return "", RetryPolicy{}, fmt.Errorf("invalid retry policy '%s', unknown key '%s'", s, key)
+		}
+		if err != nil {
+			return "", RetryPolicy{}, fmt.Errorf("invalid retry policy '%s': %w", s, err)
+		}
+	}
+
+	if p.Retries < 0 || p.CircuitBreakerThreshold < 0 {
+		return "", RetryPolicy{}, fmt.Errorf("invalid retry policy '%s', counts can't be negative", s)
+	}
+	if p.CircuitBreakerThreshold > 0 && p.CircuitBreakerCooldown <= 0 {
+		return "", RetryPolicy{}, fmt.Errorf("invalid retry policy '%s', the circuit breaker needs a cooldown", s)
+	}
+	return phase, p, nil
+}
+
+// listMeters lists the meters of the provider, retrying like a fetch.
+func (a *App) listMeters(ctx context.Context, provider Provider) (resp *MeterList, err error) {
+	err = a.retry(ctx, PhaseFetch, func() error {
+		if err := a.waitRequest(ctx); err != nil {
+			return err
+		}
+		resp, err = provider.ListMeters(ctx)
+		return err
+	})
+	return resp, err
+}
+
+// getConsumption fetches the readings of the meter on the day, retrying like
+// a fetch. The readings are validated against the granularity and their
+// timestamps aligned.
+func (a *App) getConsumption(ctx context.Context, provider Provider, meter string, day time.Time) (readings []Reading, err error) {
+	err = a.retry(ctx, PhaseFetch, func() error {
+		if err := a.waitRequest(ctx); err != nil {
+			return err
+		}
+		readings, err = provider.GetConsumption(ctx, meter, day)
+		return err
+	}, "meter", meter, "date", day.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	readings, err = a.checkIntervals(meter, day, readings)
+	if err != nil {
+		return nil, err
+	}
+	return a.alignReadings(readings), nil
+}
+
+// circuitBreaker keeps track of the consecutive failures of a phase across
+// runs.
+type circuitBreaker struct {
+	mtx       sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// circuit returns the circuit breaker of the phase.
+func (a *App) circuit(phase string) *circuitBreaker {
+	a.circuitsMtx.Lock()
+	defer a.circuitsMtx.Unlock()
+	if a.circuits == nil {
+		a.circuits = make(map[string]*circuitBreaker)
+	}
+	c, ok := a.circuits[phase]
+	if !ok {
+		c = &circuitBreaker{}
+		a.circuits[phase] = c
+	}
+	return c
+}
+
+// retry calls f until it succeeds, following the retry policy of the phase.
+// The key value pairs are added to the logs of failed attempts.
+func (a *App) retry(ctx context.Context, phase string, f func() error, keyvals ...interface{}) error {
+	p := a.cfg.retryPolicies[phase]
+	c := a.circuit(phase)
+
+	if p.CircuitBreakerThreshold > 0 {
+		c.mtx.Lock()
+		openUntil := c.openUntil
+		c.mtx.Unlock()
+		if time.Now().Before(openUntil) {
+			return fmt.Errorf("circuit breaker of the %s phase is open until %s", phase, openUntil.UTC().Format(time.RFC3339))
+		}
+	}
+
+	start := time.Now()
+	opts := []retry.Option{
+		retry.Context(ctx),
+		retry.Attempts(uint(p.Retries) + 1),
+		retry.Delay(p.Delay),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+		retry.OnRetry(func(n uint, err error) {
+			_ = level.Warn(a.logger).Log(append([]interface{}{"msg", phase + " failed", "err", err, "try", n + 1}, keyvals...)...)
+		}),
+	}
+	if p.MaxDelay > 0 {
+		opts = append(opts, retry.MaxDelay(p.MaxDelay))
+	}
+	if p.MaxElapsed > 0 {
+		if p.Retries == 0 {
+			opts = append(opts, retry.Attempts(math.MaxUint32))
+		}
+		opts = append(opts, retry.RetryIf(func(err error) bool {
+			return retry.IsRecoverable(err) && time.Since(start) < p.MaxElapsed
+		}))
+	}
+
+	err := retry.Do(f, opts...)
+	// cancelled runs say nothing about the health of the phase
+	if p.CircuitBreakerThreshold > 0 && ctx.Err() == nil {
+		c.record(a, phase, p, err)
+	}
+	return err
+}
+
+// record counts the outcome of the phase and opens the circuit, once the
+// threshold of consecutive failures is reached.
+func (c *circuitBreaker) record(a *App, phase string, p RetryPolicy, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err == nil {
+		c.failures = 0
+		a.metrics.circuitBreakerOpen.WithLabelValues(phase).Set(0)
+		return
+	}
+	c.failures++
+	if c.failures >= p.CircuitBreakerThreshold {
+		c.openUntil = time.Now().Add(p.CircuitBreakerCooldown)
+		a.metrics.circuitBreakerOpen.WithLabelValues(phase).Set(1)
+		_ = level.Warn(a.logger).Log("msg", "opened circuit breaker", "phase", phase, "failures", c.failures, "until", c.openUntil)
+	}
+}
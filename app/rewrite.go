@@ -0,0 +1,216 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// LabelRewrite describes how the labels of series are rewritten when migrating
+// blocks.
+type LabelRewrite struct {
+	// Set adds or overrides labels.
+	Set labels.Labels
+	// Drop removes labels.
+	Drop []string
+	// RenameMetric maps old to new metric names.
+	RenameMetric map[string]string
+}
+
+func (r LabelRewrite) apply(lbls labels.Labels) labels.Labels {
+	b := labels.NewBuilder(lbls)
+	if name, ok := r.RenameMetric[lbls.Get(labels.MetricName)]; ok {
+		b.Set(labels.MetricName, name)
+	}
+	b.Del(r.Drop...)
+	for _, l := range r.Set {
+		b.Set(l.Name, l.Value)
+	}
+	return b.Labels()
+}
+
+// sampleWriter appends the samples of a single series into a new block.
+type sampleWriter func(app storage.Appender, lbls labels.Labels, it chunkenc.Iterator) error
+
+// writeBlock writes the samples appended by fill into a new block covering
+// [mint, maxt) in dir.
+func (a *App) writeBlock(ctx context.Context, dir string, mint, maxt int64, parent *tsdb.BlockMeta, fill func(storage.Appender) error) (ulid.ULID, error) {
+	chunkDir, err := os.MkdirTemp("", "thames-water-importer-head")
+	if err != nil {
+		return ulid.ULID{}, err
+	}
+	defer os.RemoveAll(chunkDir)
+
+	opts := tsdb.DefaultHeadOptions()
+	opts.ChunkRange = maxt - mint
+	opts.ChunkDirRoot = chunkDir
+	head, err := tsdb.NewHead(nil, a.logger, nil, opts, tsdb.NewHeadStats())
+	if err != nil {
+		return ulid.ULID{}, err
+	}
+	defer head.Close()
+	if err := head.Init(math.MinInt64); err != nil {
+		return ulid.ULID{}, err
+	}
+
+	app := head.Appender(ctx)
+	if err := fill(app); err != nil {
+		_ = app.Rollback()
+		return ulid.ULID{}, err
+	}
+	if err := app.Commit(); err != nil {
+		return ulid.ULID{}, err
+	}
+
+	compactor, err := tsdb.NewLeveledCompactor(ctx, nil, a.logger, []int64{opts.ChunkRange}, chunkenc.NewPool(), nil)
+	if err != nil {
+		return ulid.ULID{}, err
+	}
+
+	return compactor.Write(dir, head, mint, maxt, parent)
+}
+
+// rewriteBlock writes the series of the source block through the writer into a
+// new block with the same time range in dir.
+func (a *App) rewriteBlock(ctx context.Context, src *metadata.Meta, srcDir, dir string, write sampleWriter) (ulid.ULID, error) {
+	pb, err := tsdb.OpenBlock(a.logger, srcDir, nil)
+	if err != nil {
+		return ulid.ULID{}, err
+	}
+	defer pb.Close()
+
+	q, err := tsdb.NewBlockQuerier(pb, src.MinTime, src.MaxTime-1)
+	if err != nil {
+		return ulid.ULID{}, err
+	}
+	defer q.Close()
+
+	return a.writeBlock(ctx, dir, src.MinTime, src.MaxTime, &src.BlockMeta, func(app storage.Appender) error {
+		ss := q.Select(true, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+		for ss.Next() {
+			s := ss.At()
+			if err := write(app, s.Labels(), s.Iterator()); err != nil {
+				return fmt.Errorf("error rewriting series %s: %w", s.Labels(), err)
+			}
+		}
+		return ss.Err()
+	})
+}
+
+// rewriteThanosLabels writes the Thanos external labels of the source block
+// with the label rewrite applied into the meta.json of the migrated block.
+// Local blocks have no Thanos labels, they get their external labels when
+// uploaded.
+func (a *App) rewriteThanosLabels(blockDir string, src *metadata.Meta, rewrite LabelRewrite) error {
+	if len(src.Thanos.Labels) == 0 {
+		return nil
+	}
+	meta, err := metadata.ReadFromDir(blockDir)
+	if err != nil {
+		return err
+	}
+	meta.Thanos = src.Thanos
+	meta.Thanos.Labels = rewrite.apply(labels.FromMap(src.Thanos.Labels)).Map()
+	meta.Thanos.Files = nil
+	meta.Thanos.SegmentFiles = block.GetSegmentFiles(blockDir)
+	return meta.WriteToDir(a.logger, blockDir)
+}
+
+// MigrateBlocks rewrites all blocks in srcDir with the label rewrite applied
+// into new blocks in dstDir. The rewrite is also applied to the Thanos
+// external labels of the blocks, which have any. If srcDir is empty, the
+// blocks of the local TSDB are migrated, the blocks of every stream into a
+// subdirectory of dstDir named like the stream's directory. If dstDir is
+// empty, the new blocks are written next to their source. Source blocks are
+// removed after a successful rewrite, unless keepSource is set, which requires
+// a separate dstDir.
+func (a *App) MigrateBlocks(ctx context.Context, srcDir, dstDir string, keepSource bool, rewrite LabelRewrite) error {
+	l, err := a.lock()
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	var dirs []string
+	if srcDir != "" {
+		dirs = []string{srcDir}
+	} else {
+		streams, err := a.localStreams()
+		if err != nil {
+			return err
+		}
+		for _, s := range streams {
+			dirs = append(dirs, s.path)
+		}
+	}
+
+	for _, dir := range dirs {
+		outDir := dstDir
+		if outDir == "" {
+			outDir = dir
+		} else if srcDir == "" {
+			// keep the streams apart, as their blocks overlap
+			rel, err := filepath.Rel(a.cfg.tsdbPath, dir)
+			if err != nil {
+				return err
+			}
+			outDir = filepath.Join(dstDir, rel)
+		}
+		if keepSource && filepath.Clean(outDir) == filepath.Clean(dir) {
+			return fmt.Errorf("keeping the source blocks requires a separate output directory, as the blocks would overlap")
+		}
+		if err := os.MkdirAll(outDir, 0o755); err != nil {
+			return err
+		}
+
+		metas, err := listBlocks(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range metas {
+			blockDir := filepath.Join(dir, m.ULID.String())
+			id, err := a.rewriteBlock(ctx, m, blockDir, outDir, func(app storage.Appender, lbls labels.Labels, it chunkenc.Iterator) error {
+				var (
+					ref       storage.SeriesRef
+					rewritten = rewrite.apply(lbls)
+				)
+				for it.Next() {
+					t, v := it.At()
+					var err error
+					if ref, err = app.Append(ref, rewritten, t, v); err != nil {
+						return err
+					}
+				}
+				return it.Err()
+			})
+			if err != nil {
+				return fmt.Errorf("error migrating block %s: %w", blockDir, err)
+			}
+			if err := a.rewriteThanosLabels(filepath.Join(outDir, id.String()), m, rewrite); err != nil {
+				return fmt.Errorf("error migrating block %s: %w", blockDir, err)
+			}
+			_ = level.Info(a.logger).Log("msg", "migrated block", "source", blockDir, "ulid", id)
+
+			if keepSource {
+				continue
+			}
+			if err := os.RemoveAll(blockDir); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"gopkg.in/yaml.v2"
+)
+
+// RulesConfig configures the generated Prometheus rules.
+type RulesConfig struct {
+	// LagDays is the number of days the imported readings lag behind. The
+	// readings are only imported once the provider publishes them, so the
+	// recording rules and the leak alert look back as many days.
+	LagDays int
+	// StaleAfter is the age of the newest imported sample, after which the
+	// data is alerted on as stale.
+	StaleAfter time.Duration
+	// FailingFor is the time the last run needs to have failed for, before
+	// it is alerted on.
+	FailingFor time.Duration
+	// Leak configures the night searched for continuous flow.
+	Leak LeakDetection
+}
+
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name     string `yaml:"name"`
+	Interval string `yaml:"interval,omitempty"`
+	Rules    []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// GenerateRules returns Prometheus recording rules of the rolling daily and
+// weekly totals and alerting rules for stale data, leaks and failing imports,
+// which select the series by the configured job and external labels.
+func (a *App) GenerateRules(cfg RulesConfig) ([]byte, error) {
+	if err := a.validateConfig(); err != nil {
+		return nil, err
+	}
+	if cfg.LagDays < 0 {
+		return nil, fmt.Errorf("invalid lag of %d days, can't be negative", cfg.LagDays)
+	}
+	nightHours := cfg.Leak.EndHour - cfg.Leak.StartHour
+	if cfg.Leak.StartHour < 0 || cfg.Leak.EndHour > 24 || nightHours < 2 {
+		return nil, fmt.Errorf("invalid leak detection night from %d to %d, it needs to span at least two hours of a day", cfg.Leak.StartHour, cfg.Leak.EndHour)
+	}
+
+	var offset string
+	if cfg.LagDays > 0 {
+		offset = fmt.Sprintf(" offset %dd", cfg.LagDays)
+	}
+	// the recorded series keep the labels the series are selected by
+	groupBy := []string{"meter"}
+	for _, l := range a.externalLabels() {
+		groupBy = append(groupBy, l.Name)
+	}
+	by := strings.Join(groupBy, ", ")
+
+	sumOver := func(metricName, window string) string {
+		return fmt.Sprintf("sum by (%s) (sum_over_time(%s[%s]%s))", by, a.seriesSelector(metricName), window, offset)
+	}
+	recording := ruleGroup{
+		Name:     "water-consumption",
+		Interval: "1h",
+		Rules: []rule{
+			{Record: "meter:" + consumptionMetricName + ":sum_1d", Expr: sumOver(consumptionMetricName, "1d")},
+			{Record: "meter:" + consumptionMetricName + ":sum_1w", Expr: sumOver(consumptionMetricName, "1w")},
+		},
+	}
+	if len(a.resolved.tariffs) > 0 {
+		recording.Rules = append(recording.Rules,
+			rule{Record: "meter:" + costMetricName + ":sum_1d", Expr: sumOver(costMetricName, "1d")},
+			rule{Record: "meter:" + costMetricName + ":sum_1w", Expr: sumOver(costMetricName, "1w")},
+		)
+	}
+	if len(a.resolved.emissions) > 0 {
+		recording.Rules = append(recording.Rules,
+			rule{Record: "meter:" + carbonMetricName + ":sum_1d", Expr: sumOver(carbonMetricName, "1d")},
+		)
+	}
+
+	// The window ends with the last reading of the night, so it is evaluated
+	// in the last hour of the night, shifted by the lag in whole days.
+	leak := rule{
+		Alert: "WaterLeak",
+		Expr: fmt.Sprintf("min by (%s) (min_over_time(%s[%dh]%s)) >= %g and on() hour() == %d",
+			by, a.seriesSelector(consumptionMetricName), nightHours-1, offset, cfg.Leak.MinLiters, cfg.Leak.EndHour-1),
+		Labels: map[string]string{"severity": "warning"},
+		Annotations: map[string]string{
+			"summary": fmt.Sprintf("Possible leak on meter {{ $labels.meter }}: continuous flow of at least %g l per reading between %02d:00 and %02d:00, %d days ago.",
+				cfg.Leak.MinLiters, cfg.Leak.StartHour, cfg.Leak.EndHour, cfg.LagDays),
+		},
+	}
+
+	alerting := ruleGroup{
+		Name: "water-importer",
+		Rules: []rule{
+			{
+				Alert:  "WaterDataStale",
+				Expr:   fmt.Sprintf("time() - max by (meter) (water_importer_latest_sample_timestamp_seconds) > %g", cfg.StaleAfter.Seconds()),
+				For:    "1h",
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary": fmt.Sprintf("The newest reading of meter {{ $labels.meter }} is older than %s.", model.Duration(cfg.StaleAfter)),
+				},
+			},
+			leak,
+			{
+				Alert:  "WaterImportFailing",
+				Expr:   "water_importer_last_run_success == 0",
+				For:    model.Duration(cfg.FailingFor).String(),
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary": fmt.Sprintf("The last run of the water importer {{ $labels.instance }} failed and no run succeeded for %s.", model.Duration(cfg.FailingFor)),
+				},
+			},
+			{
+				Alert:  "WaterImportCircuitBreakerOpen",
+				Expr:   "water_importer_circuit_breaker_open == 1",
+				For:    "5m",
+				Labels: map[string]string{"severity": "info"},
+				Annotations: map[string]string{
+					"summary": "The {{ $labels.phase }} phase of the water importer {{ $labels.instance }} is skipped after repeated failures.",
+				},
+			},
+		},
+	}
+
+	data, err := yaml.Marshal(ruleFile{Groups: []ruleGroup{recording, alerting}})
+	if err != nil {
+		return nil, err
+	}
+	// catch invalid expressions before they reach Prometheus
+	if _, errs := rulefmt.Parse(data); len(errs) > 0 {
+		return nil, fmt.Errorf("generated invalid rules: %w", errs[0])
+	}
+	return data, nil
+}
@@ -0,0 +1,338 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+)
+
+const (
+	RunTriggerSchedule = "schedule"
+	RunTriggerAPI      = "api"
+
+	RunStatusQueued  = "queued"
+	RunStatusRunning = "running"
+)
+
+// DayRange limits a run to the days from Start to End, both inclusive. Days
+// already in the local TSDB are skipped nonetheless.
+type DayRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (r DayRange) contains(day time.Time) bool {
+	return (r.Start.IsZero() || !day.Before(r.Start)) && (r.End.IsZero() || !day.After(r.End))
+}
+
+type dayRangeKey struct{}
+
+func withDayRange(ctx context.Context, r *DayRange) context.Context {
+	if r == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, dayRangeKey{}, *r)
+}
+
+// filterDays returns the days within the day range of the run, if any.
+func filterDays(ctx context.Context, days []time.Time) []time.Time {
+	r, ok := ctx.Value(dayRangeKey{}).(DayRange)
+	if !ok {
+		return days
+	}
+	var result []time.Time
+	for _, day := range days {
+		if r.contains(day) {
+			result = append(result, day)
+		}
+	}
+	return result
+}
+
+// RunInfo is the status of a run of the daemon.
+type RunInfo struct {
+	ID      string    `json:"id"`
+	Trigger string    `json:"trigger"`
+	Status  string    `json:"status"`
+	Queued  time.Time `json:"queued"`
+	// Start and End of the day range requested for the run.
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+	// Summary of the run, once finished.
+	Summary *RunSummary `json:"summary,omitempty"`
+
+	dayRange *DayRange
+}
+
+// runRegistry keeps the status of the recent runs of the daemon.
+type runRegistry struct {
+	size int
+
+	mtx     sync.Mutex
+	runs    map[string]*RunInfo
+	order   []string
+	entropy io.Reader
+}
+
+func newRunRegistry(size int) *runRegistry {
+	return &runRegistry{
+		size:    size,
+		runs:    make(map[string]*RunInfo),
+		entropy: ulid.Monotonic(rand.New(rand.NewSource(time.Now().UnixNano())), 0),
+	}
+}
+
+// newID returns a new ULID for a run.
+func (r *runRegistry) newID(t time.Time) string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return ulid.MustNew(ulid.Timestamp(t), r.entropy).String()
+}
+
+// add queues a new run and returns its ID.
+func (r *runRegistry) add(trigger string, dayRange *DayRange) string {
+	now := time.Now()
+	id := r.newID(now)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	info := &RunInfo{
+		ID:       id,
+		Trigger:  trigger,
+		Status:   RunStatusQueued,
+		Queued:   now.UTC(),
+		dayRange: dayRange,
+	}
+	if dayRange != nil {
+		if !dayRange.Start.IsZero() {
+			info.Start = &dayRange.Start
+		}
+		if !dayRange.End.IsZero() {
+			info.End = &dayRange.End
+		}
+	}
+
+	r.runs[info.ID] = info
+	r.order = append(r.order, info.ID)
+	if len(r.order) > r.size {
+		delete(r.runs, r.order[0])
+		r.order = r.order[1:]
+	}
+	return info.ID
+}
+
+func (r *runRegistry) update(id string, f func(*RunInfo)) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if info, ok := r.runs[id]; ok {
+		f(info)
+	}
+}
+
+// list returns the runs, newest first.
+func (r *runRegistry) list() []RunInfo {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	result := make([]RunInfo, 0, len(r.order))
+	for i := len(r.order) - 1; i >= 0; i-- {
+		result = append(result, *r.runs[r.order[i]])
+	}
+	return result
+}
+
+func (r *runRegistry) get(id string) (RunInfo, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	info, ok := r.runs[id]
+	if !ok {
+		return RunInfo{}, false
+	}
+	return *info, true
+}
+
+// runRecorded runs the queued run and records its status.
+func (a *App) runRecorded(ctx context.Context, id string) error {
+	info, _ := a.runs.get(id)
+	a.runs.update(id, func(info *RunInfo) {
+		info.Status = RunStatusRunning
+	})
+
+	summary, err := a.runWithSummary(withRun(withDayRange(ctx, info.dayRange), id, info.Trigger))
+	if summary.Status == "" {
+		// runs, which return without a summary, only failed with an error
+		summary = RunSummary{Status: RunStatusSuccess}
+		if err != nil {
+			summary = RunSummary{Status: RunStatusFailure, Error: err.Error(), ErrorCategory: errorCategory(err)}
+		}
+	}
+	a.runs.update(id, func(info *RunInfo) {
+		info.Status = summary.Status
+		info.Summary = &summary
+	})
+	return err
+}
+
+var (
+	errStandby           = errors.New("standing by for leader election, trigger the run on the leader")
+	errTooManyRunsQueued = errors.New("too many runs queued")
+)
+
+// triggerRun queues a run for the daemon.
+func (a *App) triggerRun(triggers chan<- string, dayRange *DayRange) (RunInfo, error) {
+	if a.standby() {
+		return RunInfo{}, errStandby
+	}
+
+	id := a.runs.add(RunTriggerAPI, dayRange)
+	select {
+	case triggers <- id:
+	default:
+		a.runs.update(id, func(info *RunInfo) {
+			info.Status = RunStatusFailure
+			info.Summary = &RunSummary{Status: RunStatusFailure, Error: errTooManyRunsQueued.Error()}
+		})
+		return RunInfo{}, errTooManyRunsQueued
+	}
+	_ = level.Info(a.logger).Log("msg", "run triggered by API", "id", id)
+
+	info, _ := a.runs.get(id)
+	return info, nil
+}
+
+// runAPIHandler serves the API to trigger runs, poll their status and list
+// the run history. It requires the token as bearer token.
+func (a *App) runAPIHandler(token string, triggers chan<- string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/run", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req dayRangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, fmt.Sprintf("invalid request body: %s", err), http.StatusBadRequest)
+			return
+		}
+		dayRange, err := req.parse()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		info, err := a.triggerRun(triggers, dayRange)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Location", "/api/v1/runs/"+info.ID)
+		writeJSON(w, http.StatusAccepted, info)
+	})
+	mux.HandleFunc("/api/v1/runs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		limit := 20
+		if s := r.URL.Query().Get("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+				http.Error(w, fmt.Sprintf("invalid limit '%s'", s), http.StatusBadRequest)
+				return
+			}
+		}
+		records, err := a.RunHistory(r.Context(), limit)
+		switch {
+		case errors.Is(err, errNoRunHistory):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if records == nil {
+			records = []RunRecord{}
+		}
+		writeJSON(w, http.StatusOK, records)
+	})
+	mux.HandleFunc("/api/v1/runs/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		info, ok := a.runs.get(strings.TrimPrefix(r.URL.Path, "/api/v1/runs/"))
+		if !ok {
+			http.Error(w, "run not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !validBearerToken(r.Header.Get("Authorization"), token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid or missing token", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// validBearerToken checks the value of an Authorization header.
+func validBearerToken(auth, token string) bool {
+	return strings.HasPrefix(auth, "Bearer ") && subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
+
+// dayRangeRequest is the optional day range of a trigger, e.g.
+// {"start": "2024-05-01", "end": "2024-05-03"}.
+type dayRangeRequest struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+func (req dayRangeRequest) parse() (*DayRange, error) {
+	if req.Start == "" && req.End == "" {
+		return nil, nil
+	}
+
+	var (
+		r   DayRange
+		err error
+	)
+	if req.Start != "" {
+		if r.Start, err = time.Parse("2006-01-02", req.Start); err != nil {
+			return nil, fmt.Errorf("invalid start date '%s', expected YYYY-MM-DD", req.Start)
+		}
+	}
+	if req.End != "" {
+		if r.End, err = time.Parse("2006-01-02", req.End); err != nil {
+			return nil, fmt.Errorf("invalid end date '%s', expected YYYY-MM-DD", req.End)
+		}
+	}
+	if !r.Start.IsZero() && !r.End.IsZero() && r.End.Before(r.Start) {
+		return nil, fmt.Errorf("end date %s is before start date %s", req.End, req.Start)
+	}
+	return &r, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
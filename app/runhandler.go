@@ -0,0 +1,73 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// RunHandler runs an import per POST request and responds with the JSON
+// summary of the run, e.g. to be triggered by Cloud Scheduler on Cloud Run or
+// as a Cloud Function. Failed runs respond with a server error, so the
+// trigger can retry them. The run is cancelled together with the request,
+// or after the timeout, if set below the platform's deadline, so there is
+// still time to respond with the summary.
+func (a *App) RunHandler(timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx := r.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		summary, err := a.runWithSummary(ctx)
+		status := http.StatusOK
+		if err != nil {
+			_ = level.Error(a.logger).Log("msg", "run triggered by request failed", "err", err)
+			status = http.StatusInternalServerError
+			// the run didn't start
+			if summary.Status == "" {
+				summary.Status = RunStatusFailure
+				summary.Error = err.Error()
+				summary.ErrorCategory = errorCategory(err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(summary); err != nil {
+			_ = level.Warn(a.logger).Log("msg", "error writing run summary", "err", err)
+		}
+	})
+}
+
+// ServeRuns serves the RunHandler on / of the listen address, next to the
+// importer's own metrics on /metrics and /healthz.
+func (a *App) ServeRuns(ctx context.Context, listenAddress string, timeout time.Duration) error {
+	if err := a.validateConfig(); err != nil {
+		return err
+	}
+
+	mux := a.httpHandler(a.reg)
+	runHandler := a.RunHandler(timeout)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		runHandler.ServeHTTP(w, r)
+	})
+
+	_ = level.Info(a.logger).Log("msg", "serving runs", "address", listenAddress)
+	return a.serveHTTP(ctx, listenAddress, mux)
+}
@@ -0,0 +1,172 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// sqliteRunsSchema keeps one row per run. Times are stored as RFC3339 text in
+// UTC, like the readings of the SQLite archive, and the phases as JSON.
+const sqliteRunsSchema = `CREATE TABLE IF NOT EXISTS runs (
+	id             TEXT    PRIMARY KEY,
+	trigger        TEXT    NOT NULL,
+	status         TEXT    NOT NULL,
+	started_at     TEXT    NOT NULL,
+	finished_at    TEXT    NOT NULL,
+	days_imported  INTEGER NOT NULL,
+	samples        INTEGER NOT NULL,
+	error          TEXT    NOT NULL,
+	error_category TEXT    NOT NULL,
+	login_phase    TEXT    NOT NULL,
+	phases         TEXT    NOT NULL
+)`
+
+// RunTriggerManual is the trigger of the runs not started by the daemon, e.g.
+// by the CLI, a Lambda invocation or a request to the run server.
+const RunTriggerManual = "manual"
+
+var errNoRunHistory = errors.New("the run history is not enabled")
+
+// WithRunHistory records the outcome of every run in a SQLite database.
+func WithRunHistory(path string) NewOption {
+	return func(a *App) {
+		a.cfg.runHistoryPath = path
+	}
+}
+
+// RunRecord is a run kept in the run history.
+type RunRecord struct {
+	ID            string     `json:"id"`
+	Trigger       string     `json:"trigger"`
+	Status        string     `json:"status"`
+	Start         time.Time  `json:"start"`
+	End           time.Time  `json:"end"`
+	DaysImported  int        `json:"days_imported"`
+	Samples       int        `json:"samples"`
+	Error         string     `json:"error,omitempty"`
+	ErrorCategory string     `json:"error_category,omitempty"`
+	LoginPhase    string     `json:"login_phase,omitempty"`
+	Phases        []RunPhase `json:"phases,omitempty"`
+}
+
+type runKey struct{}
+
+type runIdentity struct {
+	id      string
+	trigger string
+}
+
+// withRun identifies the run in the run history.
+func withRun(ctx context.Context, id, trigger string) context.Context {
+	return context.WithValue(ctx, runKey{}, runIdentity{id: id, trigger: trigger})
+}
+
+// runFromContext returns the identity of the run, or a new one for manual
+// runs.
+func (a *App) runFromContext(ctx context.Context) (string, string) {
+	if r, ok := ctx.Value(runKey{}).(runIdentity); ok {
+		return r.id, r.trigger
+	}
+	return a.runs.newID(time.Now()), RunTriggerManual
+}
+
+func (a *App) openRunHistory(ctx context.Context) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", a.cfg.runHistoryPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, sqliteRunsSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating table: %w", err)
+	}
+	return db, nil
+}
+
+// recordRun adds the run to the run history. Errors are only logged, as they
+// shouldn't fail the run.
+func (a *App) recordRun(ctx context.Context, id, trigger string, summary RunSummary) {
+	if a.cfg.runHistoryPath == "" {
+		return
+	}
+
+	if err := func() error {
+		db, err := a.openRunHistory(ctx)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		phases, err := json.Marshal(summary.Phases)
+		if err != nil {
+			return err
+		}
+
+		end := summary.Start.Add(time.Duration(summary.DurationSeconds * float64(time.Second)))
+		_, err = db.ExecContext(ctx, `INSERT OR REPLACE INTO runs (id, trigger, status, started_at, finished_at, days_imported, samples, error, error_category, login_phase, phases)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id,
+			trigger,
+			summary.Status,
+			summary.Start.UTC().Format(time.RFC3339),
+			end.UTC().Format(time.RFC3339),
+			summary.DaysImported,
+			summary.Samples,
+			summary.Error,
+			summary.ErrorCategory,
+			summary.LoginPhase,
+			string(phases),
+		)
+		return err
+	}(); err != nil {
+		_ = level.Warn(a.logger).Log("msg", "error recording run history", "path", a.cfg.runHistoryPath, "err", err)
+	}
+}
+
+// RunHistory returns the most recent runs, newest first.
+func (a *App) RunHistory(ctx context.Context, limit int) ([]RunRecord, error) {
+	if a.cfg.runHistoryPath == "" {
+		return nil, errNoRunHistory
+	}
+
+	db, err := a.openRunHistory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, `SELECT id, trigger, status, started_at, finished_at, days_imported, samples, error, error_category, login_phase, phases
+FROM runs ORDER BY started_at DESC, id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []RunRecord
+	for rows.Next() {
+		var (
+			r          RunRecord
+			start, end string
+			phases     string
+		)
+		if err := rows.Scan(&r.ID, &r.Trigger, &r.Status, &start, &end, &r.DaysImported, &r.Samples, &r.Error, &r.ErrorCategory, &r.LoginPhase, &phases); err != nil {
+			return nil, err
+		}
+		if r.Start, err = time.Parse(time.RFC3339, start); err != nil {
+			return nil, fmt.Errorf("invalid start of run %s: %w", r.ID, err)
+		}
+		if r.End, err = time.Parse(time.RFC3339, end); err != nil {
+			return nil, fmt.Errorf("invalid end of run %s: %w", r.ID, err)
+		}
+		if err := json.Unmarshal([]byte(phases), &r.Phases); err != nil {
+			return nil, fmt.Errorf("invalid phases of run %s: %w", r.ID, err)
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
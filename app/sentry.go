@@ -0,0 +1,112 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/go-kit/log/level"
+)
+
+// Sentry configures the reporting of failed runs to Sentry or a compatible
+// service like GlitchTip.
+type Sentry struct {
+	DSN string
+	// Environment groups the reports of a fleet of importers, which are told
+	// apart by their hostname.
+	Environment string
+}
+
+// WithSentry reports failed runs to Sentry.
+func WithSentry(s Sentry) NewOption {
+	return func(a *App) {
+		a.cfg.sentry = &s
+	}
+}
+
+// sanitizedConfig returns the settings of the importer, which help to debug a
+// failure. Credentials and URLs, which might contain secrets, are left out.
+func (c *config) sanitizedConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"login_timeout":        c.thamesWaterLoginTimeout.String(),
+		"chrome_headless":      c.chromeHeadless,
+		"chrome_sandbox":       c.chromeSandbox,
+		"tsdb_path":            c.tsdbPath,
+		"tsdb_block_duration":  c.tsdbBlockDuration.String(),
+		"meter_streams":        c.meterStreams,
+		"job_label":            c.jobLabel,
+		"aggregate_series":     c.aggregateSeries,
+		"no_upload":            c.noUpload,
+		"bucket_prefix":        c.thanosBucketPrefix,
+		"mirror_buckets":       len(c.mirrorBucketConfigFiles),
+		"upload_concurrency":   c.uploadConcurrency,
+		"upload_retries":       c.retryPolicies[PhaseUpload].Retries,
+		"remote_writes":        len(c.remoteWrites),
+		"remote_write_file":    c.remoteWriteConfigFile != "",
+		"victoriametrics":      len(c.victoriaMetrics),
+		"influxdb":             len(c.influxDBs),
+		"postgres":             len(c.postgres),
+		"sqlite_archive":       c.sqliteArchive != "",
+		"clickhouse":           len(c.clickHouses),
+		"questdb":              len(c.questDBs),
+		"bigquery":             len(c.bigQueries),
+		"timestream":           len(c.timestreams),
+		"mqtt":                 len(c.mqtts),
+		"homeassistant":        len(c.homeAssistants),
+		"nats":                 len(c.natsServers),
+		"graphite":             len(c.graphites),
+		"notification_file":    c.notificationConfigFile != "",
+		"relabel_configs":      len(c.relabelConfigs) > 0 || c.relabelConfigFile != "",
+		"sample_filter":        c.sampleFilter != nil,
+		"timestamp_alignment":  string(c.timestampAlignment),
+		"granularity":          string(c.granularity),
+		"healthcheck":          c.healthcheckPingURL != "",
+		"pushgateway":          c.pushgatewayURL != "",
+		"login_screenshot_dir": c.loginScreenshotDir,
+	}
+}
+
+// reportError sends the error of the failed run to Sentry together with the
+// run's context. Failures to report are only logged.
+func (a *App) reportError(err error, summary RunSummary) {
+	if a.cfg.sentry == nil || err == nil {
+		return
+	}
+
+	client, clientErr := sentry.NewClient(sentry.ClientOptions{
+		Dsn:         a.cfg.sentry.DSN,
+		Environment: a.cfg.sentry.Environment,
+	})
+	if clientErr != nil {
+		_ = level.Warn(a.logger).Log("msg", "error creating Sentry client", "err", clientErr)
+		return
+	}
+	hub := sentry.NewHub(client, sentry.NewScope())
+
+	var eventID *sentry.EventID
+	hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.LevelError)
+		scope.SetTag("error_category", summary.ErrorCategory)
+		if summary.LoginPhase != "" {
+			scope.SetTag("login_phase", summary.LoginPhase)
+		}
+		scope.SetContext("run", map[string]interface{}{
+			"start":            summary.Start.Format(time.RFC3339),
+			"duration_seconds": summary.DurationSeconds,
+			"days_imported":    summary.DaysImported,
+			"samples":          summary.Samples,
+			"screenshot":       summary.Screenshot,
+		})
+		scope.SetContext("config", a.cfg.sanitizedConfig())
+		scope.SetExtra("last_log_lines", summary.LastLogLines)
+		eventID = hub.CaptureException(err)
+	})
+
+	if !hub.Flush(10 * time.Second) {
+		_ = level.Warn(a.logger).Log("msg", "timed out sending error to Sentry")
+		return
+	}
+	if eventID != nil {
+		_ = level.Info(a.logger).Log("msg", "reported error to Sentry", "event_id", fmt.Sprint(*eventID))
+	}
+}
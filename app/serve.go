@@ -0,0 +1,196 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// latestSample is the newest sample of a series in the local TSDB.
+type latestSample struct {
+	lbls labels.Labels
+	t    time.Time
+	v    float64
+}
+
+// latestCollector exposes the newest sample of every series in the local TSDB.
+// A scrape triggers a run in the background, once the last run is older than
+// the minimum refresh interval. The scrape itself is answered from the cache,
+// as a run takes longer than Prometheus waits for a scrape.
+type latestCollector struct {
+	a          *App
+	ctx        context.Context
+	minRefresh time.Duration
+
+	mtx         sync.Mutex
+	running     bool
+	lastRefresh time.Time
+	latest      []latestSample
+}
+
+// Describe sends no descriptions, as the series are only known once read from
+// the TSDB.
+func (c *latestCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *latestCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mtx.Lock()
+	if !c.running && time.Since(c.lastRefresh) >= c.minRefresh {
+		c.running = true
+		go c.refresh()
+	}
+	latest := c.latest
+	c.mtx.Unlock()
+
+	for _, s := range latest {
+		constLabels := make(prometheus.Labels, len(s.lbls))
+		for _, l := range s.lbls {
+			if l.Name != labels.MetricName {
+				constLabels[l.Name] = l.Value
+			}
+		}
+		desc := prometheus.NewDesc(s.lbls.Get(labels.MetricName), "Newest value imported from Thames Water.", nil, constLabels)
+		m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, s.v)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(desc, err)
+			continue
+		}
+		ch <- m
+	}
+}
+
+// refresh runs an import and reads the newest samples afterwards. The samples
+// are read even after a failed run, as the local TSDB may still hold data of
+// previous runs.
+func (c *latestCollector) refresh() {
+	defer func() {
+		c.mtx.Lock()
+		c.running = false
+		c.lastRefresh = time.Now()
+		c.mtx.Unlock()
+	}()
+
+	if err := c.a.Run(c.ctx); err != nil {
+		_ = level.Error(c.a.logger).Log("msg", "run triggered by scrape failed", "err", err)
+	}
+
+	latest, err := c.a.latestSamples(c.ctx)
+	if err != nil {
+		_ = level.Error(c.a.logger).Log("msg", "error reading newest samples", "err", err)
+		return
+	}
+	c.mtx.Lock()
+	c.latest = latest
+	c.mtx.Unlock()
+}
+
+// latestSamples returns the newest sample of every series in the local TSDB.
+func (a *App) latestSamples(ctx context.Context) ([]latestSample, error) {
+	latest := make(map[string]*latestSample)
+	var order []string
+	if err := a.querySamples(
+		ctx,
+		time.Unix(0, 0),
+		time.Unix(math.MaxInt64/1000, 0),
+		[]*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")},
+		func(lbls labels.Labels, t time.Time, v float64) {
+			key := lbls.String()
+			s, ok := latest[key]
+			if !ok {
+				latest[key] = &latestSample{lbls: lbls.Copy(), t: t, v: v}
+				order = append(order, key)
+				return
+			}
+			if t.After(s.t) {
+				s.t, s.v = t, v
+			}
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	result := make([]latestSample, 0, len(order))
+	for _, key := range order {
+		result = append(result, *latest[key])
+	}
+	return result, nil
+}
+
+// Serve exposes the newest imported samples together with the importer's own
+// metrics on /metrics, for Prometheus to scrape. Imports are triggered by
+// scrapes, at most once per minRefresh.
+func (a *App) Serve(ctx context.Context, listenAddress string, minRefresh time.Duration) error {
+	if err := a.validateConfig(); err != nil {
+		return err
+	}
+
+	collector := &latestCollector{
+		a:          a,
+		ctx:        ctx,
+		minRefresh: minRefresh,
+	}
+	// read the samples of previous runs, so they are available right away
+	latest, err := a.latestSamples(ctx)
+	if err != nil {
+		return err
+	}
+	collector.latest = latest
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collector)
+
+	_ = level.Info(a.logger).Log("msg", "serving metrics", "address", listenAddress, "min_refresh_interval", minRefresh)
+	return a.serveHTTP(ctx, listenAddress, a.httpHandler(prometheus.Gatherers{a.reg, reg}))
+}
+
+// httpHandler returns the handler of the HTTP listener of long running modes,
+// which serves the metrics of the gatherer and /healthz.
+func (a *App) httpHandler(gatherer prometheus.Gatherer) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok\n")
+	})
+	return mux
+}
+
+// readyz reports ready, if the last successful run finished within maxAge.
+// Replicas standing by for the leader election are always ready.
+func (a *App) readyz(w http.ResponseWriter, _ *http.Request, maxAge time.Duration) {
+	last := a.lastSuccess()
+	switch {
+	case a.standby():
+		_, _ = io.WriteString(w, "ok, standing by for leader election\n")
+	case last.IsZero():
+		http.Error(w, "no successful run yet", http.StatusServiceUnavailable)
+	case time.Since(last) > maxAge:
+		http.Error(w, fmt.Sprintf("last successful run finished at %s, more than %s ago", last.UTC().Format(time.RFC3339), maxAge), http.StatusServiceUnavailable)
+	default:
+		_, _ = fmt.Fprintf(w, "ok, last successful run finished at %s\n", last.UTC().Format(time.RFC3339))
+	}
+}
+
+// serveHTTP serves the handler until the context is done.
+func (a *App) serveHTTP(ctx context.Context, listenAddress string, handler http.Handler) error {
+	srv := &http.Server{Addr: listenAddress, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
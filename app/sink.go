@@ -0,0 +1,215 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Sink receives the samples imported during a run. Every configured sink
+// receives every sample. The sinks are flushed in order after all streams
+// have been imported successfully. The local TSDB, which also keeps track of
+// the imported days, is always flushed last, so a failing sink makes the next
+// run import the samples again.
+type Sink interface {
+	// Name identifies the sink in logs and errors.
+	Name() string
+	// AppendSample buffers a sample, once it has been committed to the local
+	// TSDB.
+	AppendSample(lbls labels.Labels, t int64, v float64)
+	// Flush writes the buffered samples.
+	Flush(ctx context.Context) error
+	// Close is called at the end of every run, even if it failed.
+	Close() error
+}
+
+// ReadingSink is implemented by sinks, which store the readings as returned by
+// Thames Water, rather than the samples derived from them.
+type ReadingSink interface {
+	Sink
+	AppendReadings(readings []Reading)
+}
+
+// WithSink adds a sink, which receives the samples of every run.
+func WithSink(s Sink) NewOption {
+	return func(a *App) {
+		a.cfg.sinks = append(a.cfg.sinks, s)
+	}
+}
+
+// newSinks creates the configured sinks for a single run.
+func (a *App) newSinks() ([]Sink, error) {
+	remoteWrites := a.cfg.remoteWrites
+	if a.cfg.remoteWriteConfigFile != "" {
+		rws, err := loadRemoteWriteConfigFile(a.cfg.remoteWriteConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading remote write config file: %w", err)
+		}
+		remoteWrites = append(remoteWrites[:len(remoteWrites):len(remoteWrites)], rws...)
+	}
+
+	sinks := append([]Sink(nil), a.cfg.sinks...)
+	for _, rw := range remoteWrites {
+		sinks = append(sinks, newRemoteWriteSink(a.logger, rw))
+	}
+	for _, vm := range a.cfg.victoriaMetrics {
+		sinks = append(sinks, newVictoriaMetricsSink(a.logger, vm))
+	}
+	for _, db := range a.cfg.influxDBs {
+		sinks = append(sinks, newInfluxDBSink(a.logger, db))
+	}
+	for _, pg := range a.cfg.postgres {
+		sinks = append(sinks, newPostgresSink(a.logger, pg))
+	}
+	for _, ch := range a.cfg.clickHouses {
+		sinks = append(sinks, newClickHouseSink(a.logger, ch))
+	}
+	for _, q := range a.cfg.questDBs {
+		s, err := newQuestDBSink(a.logger, q)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	for _, bq := range a.cfg.bigQueries {
+		sinks = append(sinks, newBigQuerySink(a.logger, bq))
+	}
+	for _, ts := range a.cfg.timestreams {
+		sinks = append(sinks, newTimestreamSink(a.logger, ts))
+	}
+	for _, m := range a.cfg.mqtts {
+		s, err := newMQTTSink(a.logger, m)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	for _, ha := range a.cfg.homeAssistants {
+		sinks = append(sinks, newHomeAssistantStatisticsSink(a.logger, ha))
+	}
+	for _, n := range a.cfg.natsServers {
+		s, err := newNATSSink(a.logger, n)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	for _, g := range a.cfg.graphites {
+		s, err := newGraphiteSink(a.logger, g)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if a.cfg.sqliteArchive != "" {
+		sinks = append(sinks, newSQLiteSink(a.logger, a.cfg.sqliteArchive))
+	}
+	return sinks, nil
+}
+
+// flushSinks flushes all samples of the run to the sinks. Failed flushes are
+// retried by the write policy, apart from the local TSDB's.
+func (a *App) flushSinks(ctx context.Context) error {
+	for _, s := range a.sinks {
+		flushCtx, span := a.startSpan(ctx, "flush sink", attribute.String("sink", s.Name()))
+		var err error
+		if _, ok := s.(*localTSDBSink); ok {
+			// the upload is retried by its own policy, committing the
+			// workspaces can't be retried
+			err = s.Flush(flushCtx)
+		} else {
+			err = a.retry(flushCtx, PhaseWrite, func() error {
+				return s.Flush(flushCtx)
+			}, "sink", s.Name())
+		}
+		endSpan(span, err)
+		if err != nil {
+			return withCategory(ErrorCategorySink, fmt.Errorf("error flushing samples to %s: %w", s.Name(), err))
+		}
+	}
+	return nil
+}
+
+// closeSinks closes the sinks of the run.
+func (a *App) closeSinks() {
+	for _, s := range a.sinks {
+		if err := s.Close(); err != nil {
+			_ = level.Warn(a.logger).Log("msg", "error closing sink", "sink", s.Name(), "err", err)
+		}
+	}
+	a.sinks = nil
+}
+
+// appendReadingsToSinks passes the readings to the sinks, which store them
+// as is. It is called once the derived samples have been committed.
+func (a *App) appendReadingsToSinks(readings []Reading) {
+	for _, s := range a.sinks {
+		if rs, ok := s.(ReadingSink); ok {
+			rs.AppendReadings(readings)
+		}
+	}
+}
+
+// appender returns an appender to the TSDB, which forwards the committed
+// samples to the sinks and counts them.
+func (a *App) appender(ctx context.Context, db *tsdb.DB) storage.Appender {
+	return &sinkAppender{Appender: db.Appender(ctx), sinks: a.sinks, stats: a.stats, samplesAppended: a.metrics.samplesAppended}
+}
+
+type sinkSample struct {
+	lbls labels.Labels
+	t    int64
+	v    float64
+}
+
+type sinkAppender struct {
+	storage.Appender
+	sinks    []Sink
+	stats    *runStats
+	pending  []sinkSample
+	appended int
+
+	samplesAppended prometheus.Counter
+}
+
+func (s *sinkAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	ref, err := s.Appender.Append(ref, l, t, v)
+	if err != nil {
+		return ref, err
+	}
+	s.appended++
+	if len(s.sinks) > 0 {
+		s.pending = append(s.pending, sinkSample{lbls: l, t: t, v: v})
+	}
+	return ref, nil
+}
+
+func (s *sinkAppender) Commit() error {
+	if err := s.Appender.Commit(); err != nil {
+		return err
+	}
+	for _, p := range s.pending {
+		for _, sink := range s.sinks {
+			sink.AppendSample(p.lbls, p.t, p.v)
+		}
+	}
+	s.pending = nil
+	if s.stats != nil {
+		s.stats.samples += s.appended
+	}
+	s.samplesAppended.Add(float64(s.appended))
+	s.appended = 0
+	return nil
+}
+
+func (s *sinkAppender) Rollback() error {
+	s.pending = nil
+	s.appended = 0
+	return s.Appender.Rollback()
+}
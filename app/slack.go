@@ -0,0 +1,82 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+// Slack configures an incoming webhook of Slack, which is notified about
+// failed runs and alerts.
+type Slack struct {
+	WebhookURL string        `yaml:"webhook_url"`
+	Timeout    time.Duration `yaml:"timeout"`
+	Retries    int           `yaml:"retries"`
+}
+
+// WithSlack notifies the Slack incoming webhook about failed runs and alerts.
+func WithSlack(s Slack) NewOption {
+	return func(a *App) {
+		a.cfg.slacks = append(a.cfg.slacks, s)
+	}
+}
+
+type slackNotifier struct {
+	logger log.Logger
+	cfg    Slack
+}
+
+func (n *slackNotifier) name() string {
+	// the webhook URL contains the secret
+	return "slack"
+}
+
+// text formats the failure and the alerts of the run, using Slack's mrkdwn.
+func (n *slackNotifier) text(summary RunSummary) string {
+	var b strings.Builder
+	for _, alert := range summary.Alerts {
+		fmt.Fprintf(&b, ":droplet: *%s:* %s\n", alert.Name, alert.Summary)
+	}
+	if summary.Status != RunStatusFailure {
+		return b.String()
+	}
+	fmt.Fprintf(&b, ":warning: *Thames Water import failed* after %.0fs\n", summary.DurationSeconds)
+	fmt.Fprintf(&b, "*Category:* `%s`\n", summary.ErrorCategory)
+	fmt.Fprintf(&b, "*Error:* %s\n", summary.Error)
+	if summary.Screenshot != "" {
+		fmt.Fprintf(&b, "*Screenshot:* `%s`\n", summary.Screenshot)
+	}
+	if len(summary.LastLogLines) > 0 {
+		fmt.Fprintf(&b, "*Last log lines:*\n```\n%s\n```", strings.Join(summary.LastLogLines, "\n"))
+	}
+	return b.String()
+}
+
+func (n *slackNotifier) notify(ctx context.Context, summary RunSummary) error {
+	if !summary.needsAttention() {
+		return nil
+	}
+
+	body, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{Text: n.text(summary)})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: n.cfg.Timeout}
+	return doWithRetries(ctx, log.With(n.logger, "notifier", n.name()), client, n.cfg.Retries, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.cfg.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+
+	// pure Go driver, so the importer can still be built without cgo
+	_ "modernc.org/sqlite"
+)
+
+// sqliteReadingsSchema keeps one row per meter and interval. Times are stored
+// as RFC3339 text in UTC, which sorts correctly and works with SQLite's date
+// functions.
+const sqliteReadingsSchema = `CREATE TABLE IF NOT EXISTS readings (
+	time        TEXT    NOT NULL,
+	meter       TEXT    NOT NULL,
+	usage       REAL,
+	read        REAL    NOT NULL,
+	estimated   INTEGER NOT NULL,
+	imported_at TEXT    NOT NULL,
+	PRIMARY KEY (meter, time)
+)`
+
+// WithSQLiteArchive stores every imported reading, including whether it was
+// estimated and when it was imported, in a SQLite database. Unlike the
+// TSDB, the archive is never cleaned up.
+func WithSQLiteArchive(path string) NewOption {
+	return func(a *App) {
+		a.cfg.sqliteArchive = path
+	}
+}
+
+type sqliteReading struct {
+	Reading
+	importedAt time.Time
+}
+
+type sqliteSink struct {
+	logger log.Logger
+	path   string
+
+	readings []sqliteReading
+}
+
+func newSQLiteSink(logger log.Logger, path string) *sqliteSink {
+	return &sqliteSink{
+		logger: logger,
+		path:   path,
+	}
+}
+
+func (s *sqliteSink) Name() string {
+	return "SQLite archive " + s.path
+}
+
+func (s *sqliteSink) Close() error {
+	return nil
+}
+
+// AppendSample ignores the samples, the readings are received by
+// AppendReadings.
+func (s *sqliteSink) AppendSample(labels.Labels, int64, float64) {}
+
+func (s *sqliteSink) AppendReadings(readings []Reading) {
+	now := time.Now().UTC()
+	for _, r := range readings {
+		s.readings = append(s.readings, sqliteReading{Reading: r, importedAt: now})
+	}
+}
+
+func (s *sqliteSink) Flush(ctx context.Context) error {
+	if len(s.readings) == 0 {
+		return nil
+	}
+
+	db, err := sql.Open("sqlite", s.path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, sqliteReadingsSchema); err != nil {
+		return fmt.Errorf("error creating table: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO readings (time, meter, usage, read, estimated, imported_at)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (meter, time) DO UPDATE SET
+	usage = excluded.usage,
+	read = excluded.read,
+	estimated = excluded.estimated,
+	imported_at = excluded.imported_at`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range s.readings {
+		var usage interface{}
+		if !math.IsNaN(r.Usage) {
+			usage = r.Usage
+		}
+		if _, err := stmt.ExecContext(ctx,
+			r.Time.UTC().Format(time.RFC3339),
+			r.Meter,
+			usage,
+			r.Read,
+			r.Estimated,
+			r.importedAt.Format(time.RFC3339),
+		); err != nil {
+			return fmt.Errorf("error inserting reading: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	_ = level.Info(s.logger).Log("msg", "archived readings in SQLite", "path", s.path, "readings", len(s.readings))
+	s.readings = nil
+	return nil
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// stream is a set of blocks, which is stored in its own directory and shipped
+// with its own external labels.
+type stream struct {
+	path string
+	// labels are added to the external labels of the stream
+	labels labels.Labels
+	meters []string
+}
+
+func (a *App) meterStream(meter string) stream {
+	return stream{
+		path:   filepath.Join(a.cfg.tsdbPath, meter),
+		labels: labels.FromStrings("meter", meter),
+		meters: []string{meter},
+	}
+}
+
+// importMeters returns the meters of the account, which are imported. Unless
+// per-meter streams are enabled, only the first meter is imported, like
+// before the streams were introduced.
+func (a *App) importMeters(meters []string) []string {
+	if !a.cfg.meterStreams && len(meters) > 1 {
+		return meters[:1]
+	}
+	return meters
+}
+
+// importStreams returns the streams the imported meters of the given meters
+// are written into. Unless per-meter streams are enabled, the single imported
+// meter is written into the local TSDB path.
+func (a *App) importStreams(meters []string) []stream {
+	meters = a.importMeters(meters)
+	if !a.cfg.meterStreams {
+		return []stream{{path: a.cfg.tsdbPath, meters: meters}}
+	}
+
+	streams := make([]stream, len(meters))
+	for pos := range meters {
+		streams[pos] = a.meterStream(meters[pos])
+	}
+	return streams
+}
+
+// localStreams discovers the streams present in the local TSDB path.
+func (a *App) localStreams() ([]stream, error) {
+	if !a.cfg.meterStreams {
+		return []stream{{path: a.cfg.tsdbPath}}, nil
+	}
+
+	entries, err := os.ReadDir(a.cfg.tsdbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var streams []stream
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		streams = append(streams, a.meterStream(e.Name()))
+	}
+	return streams, nil
+}
+
+// streamExternalLabels returns the external labels of the blocks in the stream.
+func (a *App) streamExternalLabels(s stream) func() labels.Labels {
+	return func() labels.Labels {
+		lbls := labels.NewBuilder(a.externalLabels())
+		for _, l := range s.labels {
+			lbls.Set(l.Name, l.Value)
+		}
+		return lbls.Labels()
+	}
+}
+
+// streamRegisterer returns the registerer for the TSDB and shipper metrics of
+// the stream, which are distinguished by the stream's labels.
+func (a *App) streamRegisterer(s stream) prometheus.Registerer {
+	lbls := make(prometheus.Labels, len(s.labels))
+	for _, l := range s.labels {
+		lbls[l.Name] = l.Value
+	}
+	return reregisterer{prometheus.WrapRegistererWith(lbls, a.reg)}
+}
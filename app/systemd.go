@@ -0,0 +1,56 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/go-kit/log/level"
+)
+
+// sdNotify sends the state to systemd, if the importer runs as a service of
+// Type=notify. Otherwise it does nothing.
+func (a *App) sdNotify(state string) {
+	if a.cfg.noSystemdNotify {
+		return
+	}
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		_ = level.Debug(a.logger).Log("msg", "error notifying systemd", "state", state, "err", err)
+	}
+}
+
+// sdStatus reports the status shown by systemctl status. The status is kept
+// on a single line, as newlines separate the variables of a notification.
+func (a *App) sdStatus(status string) {
+	a.sdNotify("STATUS=" + strings.Join(strings.Fields(status), " "))
+}
+
+// sdWatchdog pets the systemd watchdog at half its interval, until the
+// context is done. It returns right away, if the watchdog isn't enabled for
+// the service.
+func (a *App) sdWatchdog(ctx context.Context) {
+	if a.cfg.noSystemdNotify {
+		return
+	}
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		_ = level.Warn(a.logger).Log("msg", "invalid systemd watchdog configuration", "err", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+	_ = level.Debug(a.logger).Log("msg", "petting systemd watchdog", "interval", interval)
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		a.sdNotify(daemon.SdNotifyWatchdog)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"gopkg.in/yaml.v2"
+)
+
+const costMetricName = "water_cost_gbp"
+
+// Tariff is the price of water from its effective date until the next
+// tariff takes effect.
+type Tariff struct {
+	// From is the date the tariff takes effect, in the form 2006-01-02.
+	From string `yaml:"from"`
+	// WaterRate is the price of the supplied water in GBP per m³.
+	WaterRate float64 `yaml:"water_rate"`
+	// WastewaterRate is the price of the wastewater in GBP per m³, which is
+	// charged for WastewaterPercent of the supplied water.
+	WastewaterRate    float64 `yaml:"wastewater_rate"`
+	WastewaterPercent float64 `yaml:"wastewater_percent"`
+	// StandingCharge is the fixed price in GBP per day and meter.
+	StandingCharge float64 `yaml:"standing_charge"`
+
+	from time.Time
+}
+
+// WithTariff computes the water_cost_gbp series using the tariff.
+func WithTariff(t Tariff) NewOption {
+	return func(a *App) {
+		a.cfg.tariffs = append(a.cfg.tariffs, t)
+	}
+}
+
+// WithTariffFile reads a YAML list of tariffs from the file, which is read
+// again on every run.
+func WithTariffFile(path string) NewOption {
+	return func(a *App) {
+		a.cfg.tariffFile = path
+	}
+}
+
+func loadTariffFile(path string) ([]Tariff, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tariffs []Tariff
+	if err := yaml.UnmarshalStrict(data, &tariffs); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return tariffs, nil
+}
+
+// tariffSchedule are the tariffs sorted by their effective date.
+type tariffSchedule []Tariff
+
+func newTariffSchedule(tariffs []Tariff) (tariffSchedule, error) {
+	s := make(tariffSchedule, len(tariffs))
+	for i, t := range tariffs {
+		from, err := time.Parse("2006-01-02", t.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid effective date of tariff %d: %w", i, err)
+		}
+		if t.WastewaterPercent < 0 || t.WastewaterPercent > 100 {
+			return nil, fmt.Errorf("invalid wastewater percentage %g of tariff %d, must be between 0 and 100", t.WastewaterPercent, i)
+		}
+		t.from = from
+		s[i] = t
+	}
+	sort.SliceStable(s, func(i, j int) bool {
+		return s[i].from.Before(s[j].from)
+	})
+	for i := 1; i < len(s); i++ {
+		if s[i].from.Equal(s[i-1].from) {
+			return nil, fmt.Errorf("multiple tariffs take effect on %s", s[i].From)
+		}
+	}
+	return s, nil
+}
+
+// at returns the tariff in effect at t, nil if there is none.
+func (s tariffSchedule) at(t time.Time) *Tariff {
+	i := sort.Search(len(s), func(i int) bool {
+		return s[i].from.After(t)
+	})
+	if i == 0 {
+		return nil
+	}
+	return &s[i-1]
+}
+
+// appendCosts appends the water_cost_gbp samples for the reading, split by
+// the component label into water and wastewater. On the first reading of a
+// day the standing charge is added as well.
+func (s tariffSchedule) appendCosts(app storage.Appender, lbls labels.Labels, r Reading, firstOfDay bool) error {
+	t := s.at(r.Time)
+	if t == nil {
+		return nil
+	}
+
+	m3 := r.Read / 1000
+	costs := []struct {
+		component string
+		value     float64
+	}{
+		{component: "water", value: m3 * t.WaterRate},
+		{component: "wastewater", value: m3 * t.WastewaterPercent / 100 * t.WastewaterRate},
+	}
+	if firstOfDay {
+		costs = append(costs, struct {
+			component string
+			value     float64
+		}{component: "standing_charge", value: t.StandingCharge})
+	}
+
+	b := labels.NewBuilder(lbls)
+	b.Set(labels.MetricName, costMetricName)
+	for _, c := range costs {
+		b.Set("component", c.component)
+		if _, err := app.Append(0, b.Labels(), timestamp.FromTime(r.Time), c.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+const (
+	telegramAPIURL = "https://api.telegram.org"
+
+	// telegramMessageLimit is the maximum length of a Telegram message.
+	telegramMessageLimit = 4096
+)
+
+// Telegram configures a Telegram bot, which sends the run results to a chat.
+type Telegram struct {
+	BotToken string `yaml:"bot_token"`
+	// ChatID is either the numeric ID of the chat or the @username of a
+	// channel.
+	ChatID string `yaml:"chat_id"`
+	// OnlyFailures skips the messages about successful runs without alerts.
+	OnlyFailures bool          `yaml:"only_failures"`
+	Timeout      time.Duration `yaml:"timeout"`
+	Retries      int           `yaml:"retries"`
+}
+
+// WithTelegram sends the run results to the Telegram chat.
+func WithTelegram(t Telegram) NewOption {
+	return func(a *App) {
+		a.cfg.telegrams = append(a.cfg.telegrams, t)
+	}
+}
+
+type telegramNotifier struct {
+	logger log.Logger
+	cfg    Telegram
+}
+
+func (n *telegramNotifier) name() string {
+	return "telegram " + n.cfg.ChatID
+}
+
+func (n *telegramNotifier) text(summary RunSummary) string {
+	text := summaryText(summary)
+	if summary.Status == RunStatusFailure && len(summary.LastLogLines) > 0 {
+		text += "\nLast log lines:\n" + strings.Join(summary.LastLogLines, "\n")
+	}
+	return truncateText(text, telegramMessageLimit)
+}
+
+func (n *telegramNotifier) notify(ctx context.Context, summary RunSummary) error {
+	if n.cfg.OnlyFailures && !summary.needsAttention() {
+		return nil
+	}
+
+	body, err := json.Marshal(struct {
+		ChatID string `json:"chat_id"`
+		Text   string `json:"text"`
+	}{
+		ChatID: n.cfg.ChatID,
+		Text:   n.text(summary),
+	})
+	if err != nil {
+		return err
+	}
+
+	// the URL contains the bot token, so it is not logged
+	u := fmt.Sprintf("%s/bot%s/sendMessage", telegramAPIURL, n.cfg.BotToken)
+	client := &http.Client{Timeout: n.cfg.Timeout}
+	return doWithRetries(ctx, log.With(n.logger, "notifier", n.name()), client, n.cfg.Retries, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+}
@@ -0,0 +1,125 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/simonswine/thames-water-importer/api"
+)
+
+// thamesWaterProvider imports the readings of smart meters from the Thames
+// Water account. The login runs in a browser, the readings are fetched from
+// the API used by the account's dashboard.
+type thamesWaterProvider struct {
+	app    *App
+	client *api.Client
+}
+
+func (p *thamesWaterProvider) Name() string {
+	return "Thames Water"
+}
+
+func (p *thamesWaterProvider) Login(ctx context.Context) (string, error) {
+	cookies, accountNumber, err := p.app.getLoginCookies(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	p.client, err = api.New(cookies, api.WithTransport(&apiRoundTripper{
+		next:     p.app.audit.transport(http.DefaultTransport),
+		duration: p.app.metrics.apiRequestDuration,
+	}))
+	if err != nil {
+		return "", err
+	}
+	return accountNumber, nil
+}
+
+func (p *thamesWaterProvider) ListMeters(ctx context.Context) (*MeterList, error) {
+	resp, err := p.client.GetMeters(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	days, err := availableDays(resp)
+	if err != nil {
+		return nil, err
+	}
+	return &MeterList{Meters: resp.Meters, Days: days}, nil
+}
+
+func (p *thamesWaterProvider) GetConsumption(ctx context.Context, meter string, day time.Time) ([]Reading, error) {
+	granularity, err := thamesWaterGranularity(p.app.cfg.granularity)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.GetSmartWaterMeterConsumptions(ctx, api.GetSmartWaterMeterConsumptionsRequest{
+		Meter:       meter,
+		StartDate:   day,
+		EndDate:     day,
+		Granularity: granularity,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseReadings(day, resp)
+}
+
+// availableDays returns the days with readings available, oldest first.
+func availableDays(resp *api.GetMetersResponse) ([]time.Time, error) {
+	var days = make([]time.Time, len(resp.Daily))
+	for pos := range resp.Daily {
+		ts, err := time.Parse("02-01-2006", resp.Daily[pos].Value)
+		if err != nil {
+			return nil, err
+		}
+		days[pos] = ts
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Before(days[j])
+	})
+	return days, nil
+}
+
+// parseReadings converts the lines of a daily consumption response into
+// readings.
+func parseReadings(day time.Time, resp *api.GetSmartWaterMeterConsumptionsResponse) ([]Reading, error) {
+	readings := make([]Reading, len(resp.Lines))
+	for pos := range resp.Lines {
+		timeParts := strings.Split(resp.Lines[pos].Label, ":")
+		if len(timeParts) != 2 {
+			return nil, fmt.Errorf("unexpected label split count: %d", len(timeParts))
+		}
+		hours, err := strconv.ParseInt(timeParts[0], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		minutes, err := strconv.ParseInt(timeParts[1], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		readings[pos] = Reading{
+			Time: time.Date(
+				day.Year(),
+				day.Month(),
+				day.Day(),
+				int(hours),
+				int(minutes),
+				0,
+				0,
+				time.UTC,
+			),
+			Meter:     resp.Lines[pos].MeterSerialNumberHis,
+			Usage:     resp.Lines[pos].Usage,
+			Read:      resp.Lines[pos].Read,
+			Estimated: resp.Lines[pos].IsEstimated,
+		}
+	}
+	return readings, nil
+}
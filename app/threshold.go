@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ThresholdRule raises a UsageThreshold alert, when the consumption of a meter
+// within a single period exceeds MaxLiters.
+type ThresholdRule struct {
+	// Period is either hour or day.
+	Period    string
+	MaxLiters float64
+}
+
+// ParseThresholdRule parses a rule in the form <period>><liters>, e.g.
+// day>600 or hour>200.
+func ParseThresholdRule(s string) (ThresholdRule, error) {
+	parts := strings.SplitN(s, ">", 2)
+	if len(parts) != 2 {
+		return ThresholdRule{}, fmt.Errorf("invalid threshold rule '%s', expected <period>><liters>", s)
+	}
+	r := ThresholdRule{Period: strings.TrimSpace(parts[0])}
+	if r.Period != "hour" && r.Period != "day" {
+		return ThresholdRule{}, fmt.Errorf("invalid threshold rule '%s', the period must be either hour or day", s)
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(parts[1]), "l")), 64)
+	if err != nil {
+		return ThresholdRule{}, fmt.Errorf("invalid threshold rule '%s': %w", s, err)
+	}
+	r.MaxLiters = v
+	return r, nil
+}
+
+// WithThresholdRule evaluates the rule against the readings of every run.
+func WithThresholdRule(r ThresholdRule) NewOption {
+	return func(a *App) {
+		a.cfg.thresholdRules = append(a.cfg.thresholdRules, r)
+	}
+}
+
+type thresholdRule struct {
+	cfg ThresholdRule
+}
+
+func (r thresholdRule) truncate(t time.Time) time.Time {
+	if r.cfg.Period == "hour" {
+		return t.Truncate(time.Hour)
+	}
+	return truncateDay(t)
+}
+
+func (r thresholdRule) name() string {
+	return "threshold " + r.cfg.Period + ">" + strconv.FormatFloat(r.cfg.MaxLiters, 'f', -1, 64)
+}
+
+func (r thresholdRule) evaluate(_ context.Context, readings []Reading) ([]Alert, error) {
+	type key struct {
+		meter string
+		start time.Time
+	}
+	totals := make(map[key]float64)
+	for _, reading := range readings {
+		if math.IsNaN(reading.Read) {
+			continue
+		}
+		totals[key{meter: reading.Meter, start: r.truncate(reading.Time)}] += reading.Read
+	}
+
+	keys := make([]key, 0, len(totals))
+	for k, v := range totals {
+		if v > r.cfg.MaxLiters {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].start.Equal(keys[j].start) {
+			return keys[i].start.Before(keys[j].start)
+		}
+		return keys[i].meter < keys[j].meter
+	})
+
+	alerts := make([]Alert, 0, len(keys))
+	for _, k := range keys {
+		end := k.start.Add(time.Hour)
+		when, threshold := "in the hour from "+k.start.Format("2006-01-02 15:04"), "hourly"
+		if r.cfg.Period == "day" {
+			end = k.start.AddDate(0, 0, 1)
+			when, threshold = "on "+k.start.Format("2006-01-02"), "daily"
+		}
+		alerts = append(alerts, Alert{
+			Name:  "UsageThreshold",
+			Meter: k.meter,
+			Summary: fmt.Sprintf(
+				"Meter %s used %s %s, exceeding the %s threshold of %s.",
+				k.meter, formatLiters(totals[k]), when, threshold, formatLiters(r.cfg.MaxLiters),
+			),
+			StartsAt: k.start,
+			EndsAt:   end,
+		})
+	}
+	return alerts, nil
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/timestreamwrite"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// timestreamMaxRecords is the maximum number of records per WriteRecords
+// request.
+const timestreamMaxRecords = 100
+
+// Timestream configures an Amazon Timestream table, which receives all samples
+// imported by a run. The labels become dimensions and the metric name the
+// measure name. Database and table need to exist already and the table's
+// memory store retention limits how old the accepted samples can be.
+// Credentials are found through the default AWS SDK credential chain.
+type Timestream struct {
+	Database string
+	Table    string
+	// Region overrides the region of the AWS configuration.
+	Region string
+}
+
+// WithTimestream writes the imported samples into Amazon Timestream.
+func WithTimestream(ts Timestream) NewOption {
+	return func(a *App) {
+		a.cfg.timestreams = append(a.cfg.timestreams, ts)
+	}
+}
+
+type timestreamSink struct {
+	logger log.Logger
+	cfg    Timestream
+
+	records []*timestreamwrite.Record
+}
+
+func newTimestreamSink(logger log.Logger, cfg Timestream) *timestreamSink {
+	return &timestreamSink{
+		logger: logger,
+		cfg:    cfg,
+	}
+}
+
+func (s *timestreamSink) Name() string {
+	return fmt.Sprintf("Timestream table %s.%s", s.cfg.Database, s.cfg.Table)
+}
+
+func (s *timestreamSink) Close() error {
+	return nil
+}
+
+// AppendSample skips NaN values, which Timestream doesn't support.
+func (s *timestreamSink) AppendSample(lbls labels.Labels, t int64, v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+
+	r := &timestreamwrite.Record{
+		MeasureName:      aws.String(lbls.Get(labels.MetricName)),
+		MeasureValue:     aws.String(strconv.FormatFloat(v, 'g', -1, 64)),
+		MeasureValueType: aws.String(timestreamwrite.MeasureValueTypeDouble),
+		Time:             aws.String(strconv.FormatInt(t, 10)),
+		TimeUnit:         aws.String(timestreamwrite.TimeUnitMilliseconds),
+	}
+	for _, l := range lbls {
+		if l.Name == labels.MetricName || l.Value == "" {
+			continue
+		}
+		r.Dimensions = append(r.Dimensions, &timestreamwrite.Dimension{
+			Name:  aws.String(l.Name),
+			Value: aws.String(l.Value),
+		})
+	}
+	s.records = append(s.records, r)
+}
+
+func (s *timestreamSink) Flush(ctx context.Context) error {
+	if len(s.records) == 0 {
+		return nil
+	}
+
+	cfg := aws.NewConfig()
+	if s.cfg.Region != "" {
+		cfg = cfg.WithRegion(s.cfg.Region)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return err
+	}
+	svc := timestreamwrite.New(sess)
+
+	// a newer version makes Timestream overwrite records imported before
+	version := time.Now().UnixNano() / int64(time.Millisecond)
+	for pos := 0; pos < len(s.records); pos += timestreamMaxRecords {
+		end := pos + timestreamMaxRecords
+		if end > len(s.records) {
+			end = len(s.records)
+		}
+		for _, r := range s.records[pos:end] {
+			r.Version = aws.Int64(version)
+		}
+
+		if _, err := svc.WriteRecordsWithContext(ctx, &timestreamwrite.WriteRecordsInput{
+			DatabaseName: aws.String(s.cfg.Database),
+			TableName:    aws.String(s.cfg.Table),
+			Records:      s.records[pos:end],
+		}); err != nil {
+			return fmt.Errorf("error writing records: %w", err)
+		}
+	}
+
+	_ = level.Info(s.logger).Log("msg", "wrote samples to Timestream", "table", s.Name(), "samples", len(s.records))
+	s.records = nil
+	return nil
+}
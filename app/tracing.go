@@ -0,0 +1,97 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing configures the export of the spans of every run via OTLP over
+// HTTP.
+type Tracing struct {
+	// Endpoint is the host and port of the OTLP receiver, e.g.
+	// tempo:4318, or its URL, e.g. http://tempo:4318.
+	Endpoint string
+	// Insecure disables TLS.
+	Insecure    bool
+	ServiceName string
+}
+
+// WithTracing exports the spans of the run phases: login, the fetch of every
+// day, the appends, compaction, flushing the sinks and upload.
+func WithTracing(t Tracing) NewOption {
+	return func(a *App) {
+		a.cfg.tracing = &t
+	}
+}
+
+// initTracing creates the tracer provider once.
+func (a *App) initTracing(ctx context.Context) error {
+	if a.cfg.tracing == nil || a.tracerProvider != nil {
+		return nil
+	}
+
+	endpoint, insecure := a.cfg.tracing.Endpoint, a.cfg.tracing.Insecure
+	if strings.Contains(endpoint, "://") {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid OTLP endpoint: %w", err)
+		}
+		endpoint = u.Host
+		insecure = insecure || u.Scheme == "http"
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("error creating OTLP exporter: %w", err)
+	}
+
+	serviceName := a.cfg.tracing.ServiceName
+	if serviceName == "" {
+		serviceName = "thames-water-importer"
+	}
+	a.tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+		)),
+	)
+	a.tracer = a.tracerProvider.Tracer("github.com/simonswine/thames-water-importer/app")
+	return nil
+}
+
+// flushTracing exports the spans of the run, so they show up even if the
+// process exits right after.
+func (a *App) flushTracing(ctx context.Context) error {
+	if a.tracerProvider == nil {
+		return nil
+	}
+	return a.tracerProvider.ForceFlush(ctx)
+}
+
+func (a *App) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return a.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span, marking it as failed if there is an error.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
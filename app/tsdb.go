@@ -0,0 +1,335 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/shipper"
+)
+
+// BlockInfo describes a block in the local TSDB.
+type BlockInfo struct {
+	Path       string
+	ULID       ulid.ULID
+	MinTime    time.Time
+	MaxTime    time.Time
+	NumSamples uint64
+	NumSeries  uint64
+	NumChunks  uint64
+	// Uploaded is set, once the shipper has uploaded the block.
+	Uploaded bool
+}
+
+// listBlocks reads the metadata of all blocks in the directory, ordered by
+// their min time.
+func listBlocks(dir string) ([]*metadata.Meta, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var metas []*metadata.Meta
+	for _, e := range entries {
+		if _, err := ulid.Parse(e.Name()); err != nil || !e.IsDir() {
+			continue
+		}
+		meta, err := metadata.ReadFromDir(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading meta of block %s: %w", e.Name(), err)
+		}
+		metas = append(metas, meta)
+	}
+
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].MinTime < metas[j].MinTime
+	})
+	return metas, nil
+}
+
+// uploadedBlocks returns the blocks the shipper has uploaded from the
+// directory.
+func uploadedBlocks(dir string) (map[ulid.ULID]struct{}, error) {
+	uploaded := make(map[ulid.ULID]struct{})
+
+	meta, err := shipper.ReadMetaFile(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return uploaded, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range meta.Uploaded {
+		uploaded[id] = struct{}{}
+	}
+	return uploaded, nil
+}
+
+// Blocks lists all blocks in the local TSDB.
+func (a *App) Blocks(ctx context.Context) ([]BlockInfo, error) {
+	streams, err := a.localStreams()
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []BlockInfo
+	for _, s := range streams {
+		metas, err := listBlocks(s.path)
+		if err != nil {
+			return nil, err
+		}
+
+		uploaded, err := a.uploadedToAll(s)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range metas {
+			_, isUploaded := uploaded[m.ULID]
+			blocks = append(blocks, BlockInfo{
+				Path:       filepath.Join(s.path, m.ULID.String()),
+				ULID:       m.ULID,
+				MinTime:    timestamp.Time(m.MinTime),
+				MaxTime:    timestamp.Time(m.MaxTime),
+				NumSamples: m.Stats.NumSamples,
+				NumSeries:  m.Stats.NumSeries,
+				NumChunks:  m.Stats.NumChunks,
+				Uploaded:   isUploaded,
+			})
+		}
+	}
+
+	return blocks, nil
+}
+
+// uploadedBlocksMatching returns the uploaded blocks of the stream, which
+// contain samples of series matching the matchers within [mint, maxt].
+func (a *App) uploadedBlocksMatching(s stream, mint, maxt int64, matchers []*labels.Matcher) ([]ulid.ULID, error) {
+	uploaded, err := a.uploadedToAny(s)
+	if err != nil {
+		return nil, err
+	}
+	metas, err := listBlocks(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []ulid.ULID
+	for _, m := range metas {
+		if _, ok := uploaded[m.ULID]; !ok || m.MaxTime <= mint || m.MinTime > maxt {
+			continue
+		}
+		found, err := a.blockHasSamples(filepath.Join(s.path, m.ULID.String()), mint, maxt, matchers)
+		if err != nil {
+			return nil, fmt.Errorf("error reading block %s: %w", m.ULID, err)
+		}
+		if found {
+			ids = append(ids, m.ULID)
+		}
+	}
+	return ids, nil
+}
+
+// blockHasSamples returns whether the block contains samples of series
+// matching the matchers within [mint, maxt].
+func (a *App) blockHasSamples(dir string, mint, maxt int64, matchers []*labels.Matcher) (bool, error) {
+	pb, err := tsdb.OpenBlock(a.logger, dir, nil)
+	if err != nil {
+		return false, err
+	}
+	defer pb.Close()
+
+	q, err := tsdb.NewBlockQuerier(pb, mint, maxt)
+	if err != nil {
+		return false, err
+	}
+	defer q.Close()
+
+	ss := q.Select(false, nil, matchers...)
+	for ss.Next() {
+		it := ss.At().Iterator()
+		if it.Next() {
+			return true, nil
+		}
+		if err := it.Err(); err != nil {
+			return false, err
+		}
+	}
+	return false, ss.Err()
+}
+
+// DeleteSeries writes tombstones for all samples matching the matchers within
+// the time range into the local TSDB and cleans them up afterwards. A zero from
+// or to time leaves the range open on that side. Blocks with matching samples
+// are rewritten under new ULIDs, so it refuses to delete samples of blocks
+// uploaded already, whose copies in the buckets would still contain them.
+func (a *App) DeleteSeries(ctx context.Context, matchers []*labels.Matcher, from, to time.Time) error {
+	l, err := a.lock()
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	mint, maxt := int64(math.MinInt64), int64(math.MaxInt64)
+	if !from.IsZero() {
+		mint = timestamp.FromTime(from)
+	}
+	if !to.IsZero() {
+		maxt = timestamp.FromTime(to)
+	}
+
+	streams, err := a.localStreams()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range streams {
+		ids, err := a.uploadedBlocksMatching(s, mint, maxt, matchers)
+		if err != nil {
+			return err
+		}
+		if len(ids) > 0 {
+			return fmt.Errorf("matching samples are stored in the blocks %v of %s, which have been uploaded already, their copies in the buckets would keep the samples", ids, s.path)
+		}
+	}
+
+	for _, s := range streams {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		db, err := a.openTSDB(s)
+		if err != nil {
+			return err
+		}
+
+		if err := db.Delete(mint, maxt, matchers...); err != nil {
+			_ = db.Close()
+			return fmt.Errorf("error deleting series in %s: %w", s.path, err)
+		}
+
+		if err := db.CleanTombstones(); err != nil {
+			_ = db.Close()
+			return fmt.Errorf("error cleaning tombstones in %s: %w", s.path, err)
+		}
+
+		if err := db.Close(); err != nil {
+			return err
+		}
+		_ = level.Info(a.logger).Log("msg", "deleted series", "path", s.path, "matchers", fmt.Sprintf("%v", matchers))
+	}
+
+	return nil
+}
+
+// BlockVerification is the result of verifying a single block.
+type BlockVerification struct {
+	BlockInfo
+	Err error
+}
+
+// verifyBlock checks the index of the block and reads back every sample, so
+// unreadable chunks and samples outside of the block's time range are found.
+func (a *App) verifyBlock(ctx context.Context, b BlockInfo) error {
+	mint, maxt := timestamp.FromTime(b.MinTime), timestamp.FromTime(b.MaxTime)
+
+	if err := block.VerifyIndex(a.logger, filepath.Join(b.Path, block.IndexFilename), mint, maxt); err != nil {
+		return fmt.Errorf("index verification failed: %w", err)
+	}
+
+	pb, err := tsdb.OpenBlock(a.logger, b.Path, nil)
+	if err != nil {
+		return err
+	}
+	defer pb.Close()
+
+	// query beyond the block's range, to find misplaced samples
+	q, err := tsdb.NewBlockQuerier(pb, math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	var numSamples uint64
+	ss := q.Select(false, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+	for ss.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s := ss.At()
+		it := s.Iterator()
+		for it.Next() {
+			t, _ := it.At()
+			if t < mint || t >= maxt {
+				return fmt.Errorf("sample of series %s at %s outside of block range", s.Labels(), timestamp.Time(t).UTC().Format(time.RFC3339))
+			}
+			numSamples++
+		}
+		if err := it.Err(); err != nil {
+			return fmt.Errorf("error reading chunks of series %s: %w", s.Labels(), err)
+		}
+	}
+	if err := ss.Err(); err != nil {
+		return err
+	}
+
+	if numSamples != b.NumSamples {
+		return fmt.Errorf("read %d samples, but block meta expects %d", numSamples, b.NumSamples)
+	}
+
+	return nil
+}
+
+// VerifyBlocks verifies the integrity of all blocks in the local TSDB.
+func (a *App) VerifyBlocks(ctx context.Context) ([]BlockVerification, error) {
+	blocks, err := a.Blocks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]BlockVerification, len(blocks))
+	for pos := range blocks {
+		result[pos].BlockInfo = blocks[pos]
+		result[pos].Err = a.verifyBlock(ctx, blocks[pos])
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// verifyPendingBlocks verifies all blocks, which have not been uploaded yet.
+func (a *App) verifyPendingBlocks(ctx context.Context) error {
+	blocks, err := a.Blocks(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range blocks {
+		if b.Uploaded {
+			continue
+		}
+		if err := a.verifyBlock(ctx, b); err != nil {
+			return fmt.Errorf("block %s failed verification: %w", b.Path, err)
+		}
+	}
+
+	return nil
+}
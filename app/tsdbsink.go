@@ -0,0 +1,57 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// localTSDBSink commits the workspaces of a run into the local TSDB and ships
+// the blocks to the buckets. The samples are appended to the workspaces
+// directly, as the local TSDB also keeps track of the imported days.
+type localTSDBSink struct {
+	app        *App
+	workspaces []*workspace
+}
+
+func (s *localTSDBSink) Name() string {
+	return "local TSDB"
+}
+
+// AppendSample ignores the samples, which are already in the workspaces.
+func (s *localTSDBSink) AppendSample(labels.Labels, int64, float64) {}
+
+// Flush commits the workspaces and uploads the blocks, unless uploads are
+// disabled.
+func (s *localTSDBSink) Flush(ctx context.Context) error {
+	for _, w := range s.workspaces {
+		if err := w.commit(); err != nil {
+			return withCategory(ErrorCategoryLocalTSDB, fmt.Errorf("error committing workspace of %s: %w", w.target, err))
+		}
+	}
+
+	if s.app.cfg.noUpload {
+		_ = level.Info(s.app.logger).Log("msg", "skipped upload of local TSDB")
+		return nil
+	}
+	s.app.sdStatus("uploading blocks")
+	s.app.startPhase("upload")
+	uploadCtx, span := s.app.startSpan(ctx, "upload")
+	err := s.app.upload(uploadCtx)
+	endSpan(span, err)
+	return withCategory(ErrorCategoryUpload, err)
+}
+
+// Close removes the workspaces, which are left over after a commit or failed
+// import.
+func (s *localTSDBSink) Close() error {
+	for _, w := range s.workspaces {
+		if err := w.discard(); err != nil {
+			_ = level.Warn(s.app.logger).Log("msg", "error removing workspace", "path", w.path, "err", err)
+		}
+	}
+	s.workspaces = nil
+	return nil
+}
@@ -0,0 +1,205 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+const (
+	uiRuns      = 20
+	uiErrors    = 5
+	uiChartDays = 30
+	// readings are published by Thames Water with a delay of a day or two,
+	// so only older ones are highlighted
+	uiStaleAge = 72 * time.Hour
+)
+
+//go:embed ui/index.html
+var uiIndex string
+
+var uiTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"since": func(t time.Time) string {
+		return time.Since(t).Round(time.Minute).String()
+	},
+}).Parse(uiIndex))
+
+type uiPage struct {
+	Now         time.Time
+	Standby     bool
+	LastSuccess time.Time
+	Problems    []string
+	Meters      []uiMeter
+	Chart       uiChart
+	Errors      []RunRecord
+	Runs        []RunRecord
+}
+
+type uiMeter struct {
+	Meter  string
+	Latest time.Time
+	Read   float64
+	Stale  bool
+}
+
+// uiChart is a bar chart of the daily consumption, rendered as SVG.
+type uiChart struct {
+	Width, Height int
+	LabelY        int
+	Max           float64
+	Bars          []uiBar
+}
+
+type uiBar struct {
+	Date       time.Time
+	Liters     float64
+	X, Y, W, H float64
+	Label      string
+}
+
+// uiHandler serves a page with the recent runs, their errors, the freshness
+// of the data per meter and a chart of the daily consumption, for quick
+// checks without Grafana.
+func (a *App) uiHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		page := a.uiPage(r.Context())
+		var buf bytes.Buffer
+		if err := uiTemplate.Execute(&buf, page); err != nil {
+			_ = level.Error(a.logger).Log("msg", "error rendering web UI", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = buf.WriteTo(w)
+	})
+}
+
+// uiPage collects the data of the page. Sections, which can't be read, are
+// reported as problems, so the rest of the page is still shown.
+func (a *App) uiPage(ctx context.Context) uiPage {
+	now := time.Now()
+	page := uiPage{
+		Now:         now,
+		Standby:     a.standby(),
+		LastSuccess: a.lastSuccess(),
+	}
+
+	runs, err := a.recentRuns(ctx, uiRuns)
+	if err != nil {
+		page.Problems = append(page.Problems, fmt.Sprintf("Error reading the run history: %s", err))
+	}
+	page.Runs = runs
+	for _, r := range runs {
+		if r.Status == RunStatusFailure && len(page.Errors) < uiErrors {
+			page.Errors = append(page.Errors, r)
+		}
+	}
+
+	latest, err := a.latestSamples(ctx)
+	if err != nil {
+		page.Problems = append(page.Problems, fmt.Sprintf("Error reading the newest readings: %s", err))
+	}
+	for _, s := range latest {
+		if s.lbls.Get(labels.MetricName) != consumptionMetricName {
+			continue
+		}
+		page.Meters = append(page.Meters, uiMeter{
+			Meter:  s.lbls.Get("meter"),
+			Latest: s.t,
+			Read:   s.v,
+			Stale:  now.Sub(s.t) > uiStaleAge,
+		})
+	}
+
+	from := truncateDay(now.UTC()).AddDate(0, 0, 1-uiChartDays)
+	days, err := a.dailyConsumption(ctx, from, now)
+	if err != nil {
+		page.Problems = append(page.Problems, fmt.Sprintf("Error reading the daily consumption: %s", err))
+	}
+	page.Chart = newUIChart(from, days)
+
+	return page
+}
+
+// recentRuns returns the runs of the run history, if enabled, or else those
+// of the daemon since its start, newest first.
+func (a *App) recentRuns(ctx context.Context, limit int) ([]RunRecord, error) {
+	if a.cfg.runHistoryPath != "" {
+		return a.RunHistory(ctx, limit)
+	}
+
+	var records []RunRecord
+	for _, info := range a.runs.list() {
+		if len(records) == limit {
+			break
+		}
+		r := RunRecord{
+			ID:      info.ID,
+			Trigger: info.Trigger,
+			Status:  info.Status,
+			Start:   info.Queued,
+		}
+		if s := info.Summary; s != nil && !s.Start.IsZero() {
+			r.Start = s.Start
+			r.End = s.Start.Add(time.Duration(s.DurationSeconds * float64(time.Second)).Round(time.Second))
+			r.DaysImported = s.DaysImported
+			r.Samples = s.Samples
+			r.Error = s.Error
+			r.ErrorCategory = s.ErrorCategory
+		} else if s != nil {
+			r.Error = s.Error
+			r.ErrorCategory = s.ErrorCategory
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// newUIChart sums up the consumption of all meters per day, for the days
+// from the start on.
+func newUIChart(from time.Time, days []DailyConsumption) uiChart {
+	c := uiChart{Width: 720, Height: 200}
+	c.LabelY = c.Height - 2
+	barsHeight := float64(c.Height - 14)
+
+	totals := make(map[time.Time]float64)
+	for _, d := range days {
+		totals[d.Date] += d.Liters
+		if totals[d.Date] > c.Max {
+			c.Max = totals[d.Date]
+		}
+	}
+
+	step := float64(c.Width) / uiChartDays
+	for i := 0; i < uiChartDays; i++ {
+		date := from.AddDate(0, 0, i)
+		b := uiBar{
+			Date:   date,
+			Liters: totals[date],
+			X:      float64(i) * step,
+			W:      step - 2,
+		}
+		if c.Max > 0 {
+			b.H = b.Liters / c.Max * barsHeight
+		}
+		b.Y = barsHeight - b.H
+		// label the start of every week
+		if i%7 == 0 {
+			b.Label = date.Format("Jan 2")
+		}
+		c.Bars = append(c.Bars, b)
+	}
+	return c
+}
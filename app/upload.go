@@ -0,0 +1,341 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/shipper"
+)
+
+// uploadLocalTSDB uploads the local TSDB blocks to all destinations.
+func (a *App) uploadLocalTSDB(ctx context.Context, dests []destination) error {
+	if a.cfg.verifyBlocksBeforeUpload {
+		if err := a.verifyPendingBlocks(ctx); err != nil {
+			return err
+		}
+	}
+
+	streams, err := a.localStreams()
+	if err != nil {
+		return err
+	}
+
+	for _, st := range streams {
+		for _, d := range dests {
+			st, d := st, d
+			// Blocks failing to upload or verify are not recorded as
+			// uploaded, so a retry uploads them again and overwrites any
+			// partially uploaded objects.
+			if err := a.retry(ctx, PhaseUpload, func() error {
+				return a.uploadStream(ctx, d, st)
+			}, "path", st.path, "destination", d); err != nil {
+				return fmt.Errorf("error uploading to %s bucket: %w", d, err)
+			}
+		}
+	}
+	return nil
+}
+
+// uploadStream uploads the new blocks of a stream to the destination and
+// verifies them.
+func (a *App) uploadStream(ctx context.Context, d destination, st stream) error {
+	ids, err := a.syncStream(ctx, d, st)
+	if err != nil {
+		return err
+	}
+
+	if err := a.verifyUploadedBlocks(ctx, d, st, ids); err != nil {
+		return err
+	}
+
+	a.metrics.blocksUploaded.WithLabelValues(d.String()).Add(float64(len(ids)))
+	_ = level.Info(a.logger).Log("msg", fmt.Sprintf("successfully uploaded and verified %d blocks", len(ids)), "path", st.path, "destination", d)
+	return nil
+}
+
+// syncStream uploads the blocks of the stream, which haven't been uploaded to
+// the destination yet, with up to the configured number of uploads running
+// concurrently. Like the Thanos shipper, it keeps track of the uploaded blocks
+// in the shipper's meta file, and returns the blocks it has uploaded.
+func (a *App) syncStream(ctx context.Context, d destination, st stream) ([]ulid.ULID, error) {
+	bkt := d.bkt
+	stateDir := d.stateDir(st)
+	meta, err := shipper.ReadMetaFile(stateDir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			_ = level.Warn(a.logger).Log("msg", "reading shipper meta file failed, will override it", "path", st.path, "err", err)
+		}
+		meta = &shipper.Meta{Version: shipper.MetaVersion1}
+	}
+	hasUploaded := make(map[ulid.ULID]struct{}, len(meta.Uploaded))
+	for _, id := range meta.Uploaded {
+		hasUploaded[id] = struct{}{}
+	}
+	// only keep blocks, which still exist locally
+	meta.Uploaded = nil
+
+	metas, err := listBlocks(st.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mtx      sync.Mutex
+		sem      = make(chan struct{}, a.uploadConcurrency())
+		uploaded []ulid.ULID
+		failed   int
+		firstErr error
+		checker  = &overlapChecker{bkt: bkt, labels: a.streamExternalLabels(st)()}
+	)
+	for _, m := range metas {
+		if _, ok := hasUploaded[m.ULID]; ok {
+			meta.Uploaded = append(meta.Uploaded, m.ULID)
+			continue
+		}
+		if m.Stats.NumSamples == 0 {
+			continue
+		}
+		// only ship compacted blocks, if enabled
+		if m.Compaction.Level > 1 && !a.cfg.uploadCompacted {
+			continue
+		}
+
+		// without out-of-order uploads, stop at the first failure
+		mtx.Lock()
+		stop := firstErr != nil && !a.cfg.allowOutOfOrderUploads
+		mtx.Unlock()
+		if stop {
+			break
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, ctx.Err()
+		}
+		wg.Add(1)
+		go func(m *metadata.Meta) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			// skip blocks, which are already present in the bucket
+			exists, err := bkt.Exists(ctx, path.Join(m.ULID.String(), metadata.MetaFilename))
+			if err == nil && !exists && m.Compaction.Level > 1 {
+				// compacted blocks must not overlap with blocks in the bucket
+				err = checker.check(ctx, m)
+			}
+			if err == nil && !exists {
+				err = a.uploadBlock(ctx, bkt, st, m)
+			}
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			if err != nil {
+				_ = level.Error(a.logger).Log("msg", "shipping failed", "block", m.ULID, "err", err)
+				failed++
+				if firstErr == nil {
+					firstErr = fmt.Errorf("upload %s: %w", m.ULID, err)
+				}
+				return
+			}
+			meta.Uploaded = append(meta.Uploaded, m.ULID)
+			if !exists {
+				uploaded = append(uploaded, m.ULID)
+			}
+		}(m)
+	}
+	wg.Wait()
+
+	if err := os.MkdirAll(stateDir, 0o750); err != nil {
+		return uploaded, err
+	}
+	if err := shipper.WriteMetaFile(a.logger, stateDir, meta); err != nil {
+		_ = level.Warn(a.logger).Log("msg", "updating shipper meta file failed", "path", stateDir, "err", err)
+	}
+	if failed > 0 && !a.cfg.allowOutOfOrderUploads {
+		return uploaded, firstErr
+	}
+	if failed > 0 {
+		return uploaded, fmt.Errorf("failed to sync %d blocks", failed)
+	}
+	return uploaded, nil
+}
+
+// overlapChecker lazily loads the metas of the blocks in the bucket with the
+// same external labels, to check compacted blocks for overlaps before they
+// are uploaded.
+type overlapChecker struct {
+	bkt    objstore.Bucket
+	labels labels.Labels
+
+	mtx    sync.Mutex
+	metas  []metadata.Meta
+	synced bool
+}
+
+func (c *overlapChecker) check(ctx context.Context, m *metadata.Meta) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.synced {
+		ids, err := bucketBlocks(ctx, c.bkt)
+		if err != nil {
+			return err
+		}
+		for id := range ids {
+			meta, err := block.DownloadMeta(ctx, log.NewNopLogger(), c.bkt, id)
+			if err != nil {
+				return err
+			}
+			if labels.Equal(labels.FromMap(meta.Thanos.Labels), c.labels) {
+				c.metas = append(c.metas, meta)
+			}
+		}
+		c.synced = true
+	}
+
+	for _, other := range c.metas {
+		if other.ULID != m.ULID && other.MinTime < m.MaxTime && m.MinTime < other.MaxTime {
+			return fmt.Errorf("compacted block overlaps with block %s in the bucket", other.ULID)
+		}
+	}
+	return nil
+}
+
+// uploadBlock uploads a single block with the stream's external labels.
+func (a *App) uploadBlock(ctx context.Context, bkt objstore.Bucket, st stream, meta *metadata.Meta) error {
+	_ = level.Info(a.logger).Log("msg", "upload new block", "id", meta.ULID)
+
+	// hard link the block into a separate directory, so the Thanos meta
+	// doesn't modify the local block
+	updir := filepath.Join(st.path, "thanos", "upload", meta.ULID.String())
+	if err := os.RemoveAll(updir); err != nil {
+		return fmt.Errorf("error cleaning upload directory: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(updir), 0o750); err != nil {
+		return fmt.Errorf("error creating upload directory: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(updir); err != nil {
+			_ = level.Error(a.logger).Log("msg", "failed to clean upload directory", "err", err)
+		}
+	}()
+	if err := linkDir(filepath.Join(st.path, meta.ULID.String()), updir); err != nil {
+		return fmt.Errorf("error linking block: %w", err)
+	}
+
+	meta.Thanos.Labels = a.streamExternalLabels(st)().Map()
+	meta.Thanos.Source = a.cfg.blockSource
+	meta.Thanos.SegmentFiles = block.GetSegmentFiles(updir)
+	if err := meta.WriteToDir(a.logger, updir); err != nil {
+		return fmt.Errorf("error writing meta file: %w", err)
+	}
+	return block.Upload(ctx, a.logger, bkt, updir, a.cfg.blockHashFunc)
+}
+
+// verifyUploadedBlock compares the uploaded block with its local copy. The
+// block's objects need to be present with the sizes and checksums recorded
+// in the uploaded meta.json, which need to match the local files.
+func (a *App) verifyUploadedBlock(ctx context.Context, bkt objstore.Bucket, dir string, id ulid.ULID) error {
+	localMeta, err := metadata.ReadFromDir(filepath.Join(dir, id.String()))
+	if err != nil {
+		return err
+	}
+
+	remoteMeta, err := block.DownloadMeta(ctx, a.logger, bkt, id)
+	if err != nil {
+		return err
+	}
+	if remoteMeta.ULID != localMeta.ULID || remoteMeta.MinTime != localMeta.MinTime || remoteMeta.MaxTime != localMeta.MaxTime || remoteMeta.Stats != localMeta.Stats {
+		return fmt.Errorf("uploaded meta.json doesn't match local block")
+	}
+	if len(remoteMeta.Thanos.Files) == 0 {
+		return fmt.Errorf("uploaded meta.json doesn't list the block's files")
+	}
+
+	for _, f := range remoteMeta.Thanos.Files {
+		if f.RelPath == metadata.MetaFilename {
+			continue
+		}
+
+		attrs, err := bkt.Attributes(ctx, path.Join(id.String(), f.RelPath))
+		if err != nil {
+			return fmt.Errorf("error checking uploaded %s: %w", f.RelPath, err)
+		}
+		if attrs.Size != f.SizeBytes {
+			return fmt.Errorf("uploaded %s has %d bytes, expected %d", f.RelPath, attrs.Size, f.SizeBytes)
+		}
+
+		localPath := filepath.Join(dir, id.String(), filepath.FromSlash(f.RelPath))
+		stat, err := os.Stat(localPath)
+		if err != nil {
+			return err
+		}
+		if stat.Size() != f.SizeBytes {
+			return fmt.Errorf("local %s has %d bytes, uploaded %d", f.RelPath, stat.Size(), f.SizeBytes)
+		}
+		if f.Hash == nil {
+			continue
+		}
+		hash, err := metadata.CalculateHash(localPath, f.Hash.Func, a.logger)
+		if err != nil {
+			return err
+		}
+		if !hash.Equal(f.Hash) {
+			return fmt.Errorf("checksum of uploaded %s doesn't match local file", f.RelPath)
+		}
+	}
+
+	return nil
+}
+
+// verifyUploadedBlocks verifies the given blocks uploaded from the stream to
+// the destination. Blocks failing the verification are removed from the
+// shipper's meta file, so they are uploaded again by the next run.
+func (a *App) verifyUploadedBlocks(ctx context.Context, d destination, st stream, ids []ulid.ULID) error {
+	failed := make(map[ulid.ULID]struct{})
+	for _, id := range ids {
+		if err := a.verifyUploadedBlock(ctx, d.bkt, st.path, id); err != nil {
+			_ = level.Error(a.logger).Log("msg", "uploaded block failed verification", "block", id, "destination", d, "err", err)
+			failed[id] = struct{}{}
+			continue
+		}
+		_ = level.Debug(a.logger).Log("msg", "verified uploaded block", "block", id)
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	meta, err := shipper.ReadMetaFile(d.stateDir(st))
+	if err != nil {
+		return err
+	}
+	uploaded := meta.Uploaded[:0]
+	for _, id := range meta.Uploaded {
+		if _, ok := failed[id]; !ok {
+			uploaded = append(uploaded, id)
+		}
+	}
+	meta.Uploaded = uploaded
+	if err := shipper.WriteMetaFile(a.logger, d.stateDir(st), meta); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%d uploaded blocks failed verification", len(failed))
+}
@@ -0,0 +1,376 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/runutil"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstore"
+)
+
+// Statuses of the days of a Verification.
+const (
+	// VerifyStatusOK days are stored like the API returns them.
+	VerifyStatusOK = "ok"
+	// VerifyStatusDrift days have been corrected retroactively and need to be
+	// imported again.
+	VerifyStatusDrift = "drift"
+	// VerifyStatusNotStored days are neither in the local TSDB nor in the
+	// bucket.
+	VerifyStatusNotStored = "not stored"
+)
+
+// Sources of the stored readings of a verified day.
+const (
+	VerifySourceTSDB   = "tsdb"
+	VerifySourceBucket = "bucket"
+)
+
+// VerifiedDay compares the readings of a meter on a day returned by the API
+// with the stored ones.
+type VerifiedDay struct {
+	Meter  string    `json:"meter"`
+	Day    time.Time `json:"day"`
+	Status string    `json:"status"`
+	// Source is where the stored readings have been read from, bucket if
+	// any of them is only in the bucket.
+	Source string `json:"source,omitempty"`
+
+	RemoteLiters float64 `json:"remote_liters"`
+	StoredLiters float64 `json:"stored_liters"`
+	// Changed readings differ by more than the tolerance, Missing readings are
+	// only returned by the API and Extra readings are only stored.
+	Changed int `json:"changed"`
+	Missing int `json:"missing"`
+	Extra   int `json:"extra"`
+}
+
+// Verification lists the verified days of every meter.
+type Verification struct {
+	Meters []string      `json:"meters"`
+	Days   []VerifiedDay `json:"days"`
+}
+
+// Drifted returns the number of days, which need to be imported again.
+func (v *Verification) Drifted() int {
+	var n int
+	for _, d := range v.Days {
+		if d.Status == VerifyStatusDrift {
+			n++
+		}
+	}
+	return n
+}
+
+// VerifyData fetches up to sample of the available days within [from, to)
+// again and compares their readings with the stored ones, to find days the
+// provider has corrected retroactively since they have been imported. The
+// sampled days are spread evenly across the range, including the first and
+// the last day. Samples of blocks, which have been deleted locally after their
+// upload, are read from the blocks in the bucket.
+func (a *App) VerifyData(ctx context.Context, from, to time.Time, sample int, tolerance float64) (*Verification, error) {
+	if err := a.validateConfig(); err != nil {
+		return nil, err
+	}
+
+	provider, _, err := a.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.closeProvider(provider)
+
+	resp, err := a.listMeters(ctx, provider)
+	if err != nil {
+		return nil, withCategory(ErrorCategoryThamesWaterAPI, err)
+	}
+	if len(resp.Meters) == 0 {
+		return nil, fmt.Errorf("no meters found")
+	}
+
+	var days []time.Time
+	for _, day := range resp.Days {
+		if !day.Before(truncateDay(from)) && day.Before(to) {
+			days = append(days, day)
+		}
+	}
+
+	bkt, err := a.newVerifyBucket()
+	if err != nil {
+		return nil, err
+	}
+	if bkt != nil {
+		defer bkt.close()
+	}
+	localMinTime, err := a.localMinTime()
+	if err != nil {
+		return nil, err
+	}
+
+	meters := a.importMeters(resp.Meters)
+	v := &Verification{Meters: meters}
+	for _, day := range sampleDays(days, sample) {
+		for _, meter := range meters {
+			readings, err := a.getConsumption(ctx, provider, meter, day)
+			if err != nil {
+				return nil, withCategory(ErrorCategoryThamesWaterAPI, err)
+			}
+			readings, err = a.dedupReadings(readings)
+			if err != nil {
+				return nil, err
+			}
+			// compare with the readings as they would have been written
+			readings = a.filterReadings(readings)
+
+			d := VerifiedDay{Meter: meter, Day: day, Status: VerifyStatusNotStored}
+			stored, err := a.storedDaySamples(ctx, meter, day)
+			if err != nil {
+				return nil, fmt.Errorf("error reading stored samples: %w", err)
+			}
+			if len(stored) > 0 {
+				d.Source = VerifySourceTSDB
+			}
+			// blocks are deleted locally oldest first, so only days before
+			// the oldest local block can be missing samples
+			if bkt != nil && day.Before(localMinTime) {
+				remote, err := bkt.daySamples(ctx, meter, day)
+				if err != nil {
+					return nil, fmt.Errorf("error reading samples from bucket: %w", err)
+				}
+				for t, v := range remote {
+					if _, ok := stored[t]; !ok {
+						stored[t] = v
+						d.Source = VerifySourceBucket
+					}
+				}
+			}
+			if len(stored) > 0 {
+				compareDay(&d, readings, stored, tolerance)
+			}
+			_ = level.Debug(a.logger).Log("msg", "verified daily reading", "meter", meter, "date", day.Format("2006-01-02"), "status", d.Status)
+			v.Days = append(v.Days, d)
+		}
+	}
+	return v, nil
+}
+
+// sampleDays picks up to n days spread evenly across the days, or all of them
+// if n isn't positive.
+func sampleDays(days []time.Time, n int) []time.Time {
+	if n <= 0 || n >= len(days) {
+		return days
+	}
+	if n == 1 {
+		return days[len(days)-1:]
+	}
+	sampled := make([]time.Time, 0, n)
+	for i := 0; i < n; i++ {
+		sampled = append(sampled, days[i*(len(days)-1)/(n-1)])
+	}
+	return sampled
+}
+
+// compareDay compares the readings with the stored samples by their
+// timestamps.
+func compareDay(d *VerifiedDay, readings []Reading, stored map[int64]float64, tolerance float64) {
+	seen := make(map[int64]struct{}, len(readings))
+	for _, r := range readings {
+		t := timestamp.FromTime(r.Time)
+		seen[t] = struct{}{}
+		d.RemoteLiters += r.Read
+
+		v, ok := stored[t]
+		if !ok {
+			d.Missing++
+			continue
+		}
+		if math.Abs(v-r.Read) > tolerance {
+			d.Changed++
+		}
+	}
+	for t, v := range stored {
+		d.StoredLiters += v
+		if _, ok := seen[t]; !ok {
+			d.Extra++
+		}
+	}
+
+	d.Status = VerifyStatusOK
+	if d.Changed+d.Missing+d.Extra > 0 {
+		d.Status = VerifyStatusDrift
+	}
+}
+
+func dayMatchers(meter string) []*labels.Matcher {
+	return []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, consumptionMetricName),
+		labels.MustNewMatcher(labels.MatchEqual, "meter", meter),
+	}
+}
+
+// storedDaySamples returns the consumption samples of the meter on the day
+// in the local TSDB by their timestamp.
+func (a *App) storedDaySamples(ctx context.Context, meter string, day time.Time) (map[int64]float64, error) {
+	samples := make(map[int64]float64)
+	if err := a.querySamples(ctx, day, day.AddDate(0, 0, 1), dayMatchers(meter), func(_ labels.Labels, t time.Time, v float64) {
+		samples[timestamp.FromTime(t)] = v
+	}); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// localMinTime returns the start of the oldest block in the local TSDB, or
+// the zero time, if there are none.
+func (a *App) localMinTime() (time.Time, error) {
+	streams, err := a.localStreams()
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var minTime time.Time
+	for _, s := range streams {
+		metas, err := listBlocks(s.path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		for _, m := range metas {
+			if t := timestamp.Time(m.MinTime); minTime.IsZero() || t.Before(minTime) {
+				minTime = t
+			}
+		}
+	}
+	return minTime, nil
+}
+
+// verifyBucket reads the samples of days, which are no longer stored locally,
+// from the blocks in the primary bucket. The blocks are downloaded into a
+// temporary directory, once they are needed.
+type verifyBucket struct {
+	a      *App
+	bkt    objstore.Bucket
+	dir    string
+	metas  []metadata.Meta
+	listed bool
+	blocks map[ulid.ULID]*tsdb.Block
+}
+
+// newVerifyBucket returns nil, if no bucket is configured.
+func (a *App) newVerifyBucket() (*verifyBucket, error) {
+	bktConfig, err := a.bucketConfig()
+	if err != nil {
+		return nil, err
+	}
+	if len(bktConfig) == 0 {
+		return nil, nil
+	}
+	bkt, err := a.newBucket(destination{}, bktConfig)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "verify-data-")
+	if err != nil {
+		runutil.CloseWithLogOnErr(a.logger, bkt, "bucket client")
+		return nil, err
+	}
+	return &verifyBucket{a: a, bkt: bkt, dir: dir, blocks: make(map[ulid.ULID]*tsdb.Block)}, nil
+}
+
+// daySamples returns the consumption samples of the meter on the day in the
+// blocks of the bucket, which carry the external labels of the importer.
+func (b *verifyBucket) daySamples(ctx context.Context, meter string, day time.Time) (map[int64]float64, error) {
+	if !b.listed {
+		ids, err := bucketBlocks(ctx, b.bkt)
+		if err != nil {
+			return nil, err
+		}
+		for id := range ids {
+			meta, err := block.DownloadMeta(ctx, b.a.logger, b.bkt, id)
+			if err != nil {
+				return nil, err
+			}
+			if hasLabels(meta.Thanos.Labels, b.a.externalLabels()) {
+				b.metas = append(b.metas, meta)
+			}
+		}
+		b.listed = true
+	}
+
+	mint, maxt := timestamp.FromTime(day), timestamp.FromTime(day.AddDate(0, 0, 1))-1
+	samples := make(map[int64]float64)
+	for _, meta := range b.metas {
+		if meta.MaxTime <= mint || meta.MinTime > maxt {
+			continue
+		}
+		blk, err := b.block(ctx, meta.ULID)
+		if err != nil {
+			return nil, err
+		}
+		q, err := tsdb.NewBlockQuerier(blk, mint, maxt)
+		if err != nil {
+			return nil, err
+		}
+		err = selectSamples(q, dayMatchers(meter), func(_ labels.Labels, t time.Time, v float64) {
+			samples[timestamp.FromTime(t)] = v
+		})
+		_ = q.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error querying block %s: %w", meta.ULID, err)
+		}
+	}
+	return samples, nil
+}
+
+// block downloads and opens the block, unless it has been already.
+func (b *verifyBucket) block(ctx context.Context, id ulid.ULID) (*tsdb.Block, error) {
+	if blk, ok := b.blocks[id]; ok {
+		return blk, nil
+	}
+	dir := filepath.Join(b.dir, id.String())
+	_ = level.Debug(b.a.logger).Log("msg", "downloading block to verify", "id", id)
+	if err := block.Download(ctx, b.a.logger, b.bkt, id, dir); err != nil {
+		return nil, fmt.Errorf("error downloading block %s: %w", id, err)
+	}
+	blk, err := tsdb.OpenBlock(&logLevelOverride{next: b.a.logger, level: level.DebugValue()}, dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	b.blocks[id] = blk
+	return blk, nil
+}
+
+func (b *verifyBucket) close() {
+	for id, blk := range b.blocks {
+		if err := blk.Close(); err != nil {
+			_ = level.Warn(b.a.logger).Log("msg", "failed to close block", "id", id, "err", err)
+		}
+	}
+	runutil.CloseWithLogOnErr(b.a.logger, b.bkt, "bucket client")
+	if err := os.RemoveAll(b.dir); err != nil {
+		_ = level.Warn(b.a.logger).Log("msg", "failed to remove downloaded blocks", "path", b.dir, "err", err)
+	}
+}
+
+// hasLabels returns true, if the block labels contain all of the labels.
+func hasLabels(blockLabels map[string]string, lbls labels.Labels) bool {
+	for _, l := range lbls {
+		if blockLabels[l.Name] != l.Value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// VictoriaMetrics configures a VictoriaMetrics instance, which receives all
+// samples imported by a run through its JSON line import API. Unlike remote
+// write, VictoriaMetrics accepts samples of any age, so backfilled days
+// arrive as well.
+type VictoriaMetrics struct {
+	// URL of the single node instance or of vminsert including the tenant
+	// path, e.g. http://vminsert:8480/insert/0/prometheus.
+	URL string
+	// Timeout of a single request.
+	Timeout time.Duration
+	// BatchSize is the maximum number of samples sent per request.
+	BatchSize int
+	// Retries of a failed request.
+	Retries   int
+	BasicAuth *BasicAuth
+}
+
+// WithVictoriaMetrics sends the imported samples to VictoriaMetrics.
+func WithVictoriaMetrics(vm VictoriaMetrics) NewOption {
+	return func(a *App) {
+		a.cfg.victoriaMetrics = append(a.cfg.victoriaMetrics, vm)
+	}
+}
+
+// victoriaMetricsSeries is a single line of the JSON line import format.
+type victoriaMetricsSeries struct {
+	Metric     map[string]string `json:"metric"`
+	Values     []float64         `json:"values"`
+	Timestamps []int64           `json:"timestamps"`
+}
+
+type victoriaMetricsSink struct {
+	logger log.Logger
+	cfg    VictoriaMetrics
+	client *http.Client
+
+	series map[string]*victoriaMetricsSeries
+}
+
+func newVictoriaMetricsSink(logger log.Logger, cfg VictoriaMetrics) *victoriaMetricsSink {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 10000
+	}
+	return &victoriaMetricsSink{
+		logger: logger,
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		series: make(map[string]*victoriaMetricsSeries),
+	}
+}
+
+func (s *victoriaMetricsSink) Name() string {
+	return "VictoriaMetrics " + s.cfg.URL
+}
+
+func (s *victoriaMetricsSink) Close() error {
+	return nil
+}
+
+func (s *victoriaMetricsSink) importURL() string {
+	return strings.TrimSuffix(s.cfg.URL, "/") + "/api/v1/import"
+}
+
+// AppendSample skips NaN values, which JSON can't represent.
+func (s *victoriaMetricsSink) AppendSample(lbls labels.Labels, t int64, v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	key := lbls.String()
+	vs, ok := s.series[key]
+	if !ok {
+		vs = &victoriaMetricsSeries{Metric: lbls.Map()}
+		s.series[key] = vs
+	}
+	vs.Values = append(vs.Values, v)
+	vs.Timestamps = append(vs.Timestamps, t)
+}
+
+// Flush sends the samples in batches of JSON lines, one request at a time.
+func (s *victoriaMetricsSink) Flush(ctx context.Context) error {
+	keys := make([]string, 0, len(s.series))
+	for k := range s.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var (
+		buf     bytes.Buffer
+		samples int
+		total   int
+	)
+	enc := json.NewEncoder(&buf)
+	send := func() error {
+		if samples == 0 {
+			return nil
+		}
+		if err := s.send(ctx, buf.Bytes()); err != nil {
+			return err
+		}
+		total += samples
+		buf.Reset()
+		samples = 0
+		return nil
+	}
+
+	for _, k := range keys {
+		vs := s.series[k]
+		// the samples are only dropped once all are sent, so a retry of the
+		// flush sends them again
+		for values, timestamps := vs.Values, vs.Timestamps; len(values) > 0; {
+			n := s.cfg.BatchSize - samples
+			if n > len(values) {
+				n = len(values)
+			}
+			if err := enc.Encode(victoriaMetricsSeries{
+				Metric:     vs.Metric,
+				Values:     values[:n],
+				Timestamps: timestamps[:n],
+			}); err != nil {
+				return err
+			}
+			samples += n
+			values, timestamps = values[n:], timestamps[n:]
+			if samples >= s.cfg.BatchSize {
+				if err := send(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := send(); err != nil {
+		return err
+	}
+
+	s.series = make(map[string]*victoriaMetricsSeries)
+	_ = level.Info(s.logger).Log("msg", "imported samples into VictoriaMetrics", "url", s.cfg.URL, "samples", total)
+	return nil
+}
+
+func (s *victoriaMetricsSink) send(ctx context.Context, body []byte) error {
+	return doWithRetries(ctx, log.With(s.logger, "url", s.cfg.URL), s.client, s.cfg.Retries, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.importURL(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if s.cfg.BasicAuth != nil {
+			req.SetBasicAuth(s.cfg.BasicAuth.Username, s.cfg.BasicAuth.Password)
+		}
+		return req, nil
+	})
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+// Webhook configures an URL, which receives a POST with the RunSummary at the
+// end of every run.
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Template of the request body, which has the RunSummary available. By
+	// default the summary is sent as JSON.
+	Template    string        `yaml:"template"`
+	ContentType string        `yaml:"content_type"`
+	Timeout     time.Duration `yaml:"timeout"`
+	Retries     int           `yaml:"retries"`
+}
+
+// WithWebhook posts the run summary to the webhook.
+func WithWebhook(w Webhook) NewOption {
+	return func(a *App) {
+		a.cfg.webhooks = append(a.cfg.webhooks, w)
+	}
+}
+
+type webhookNotifier struct {
+	logger log.Logger
+	cfg    Webhook
+}
+
+func (n *webhookNotifier) name() string {
+	return "webhook " + n.cfg.URL
+}
+
+func (n *webhookNotifier) body(summary RunSummary) ([]byte, error) {
+	if n.cfg.Template == "" {
+		return json.Marshal(summary)
+	}
+
+	tmpl, err := template.New("webhook").Parse(n.cfg.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, summary); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (n *webhookNotifier) notify(ctx context.Context, summary RunSummary) error {
+	body, err := n.body(summary)
+	if err != nil {
+		return err
+	}
+	contentType := n.cfg.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	client := &http.Client{Timeout: n.cfg.Timeout}
+	return doWithRetries(ctx, log.With(n.logger, "url", n.cfg.URL), client, n.cfg.Retries, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.cfg.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		return req, nil
+	})
+}
@@ -0,0 +1,207 @@
+package app
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+// workspaceDirname is the directory inside a stream, in which a run builds
+// its blocks.
+const workspaceDirname = ".workspace"
+
+// workspace is a per-run copy of a stream. The run imports into the
+// workspace, which is only merged back into the stream, once the whole run
+// succeeded. A failed run therefore never leaves partially imported days
+// behind.
+type workspace struct {
+	// stream points to the workspace directory
+	stream
+	target string
+	// committed is set once the workspace has been committed, so flushing
+	// again, e.g. after a failed upload, doesn't commit it twice
+	committed bool
+}
+
+// newWorkspace prepares the workspace of the stream, which starts out with
+// hard links to the stream's existing blocks.
+func (a *App) newWorkspace(s stream) (*workspace, error) {
+	if err := a.flushLegacyHead(s.path); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(s.path, workspaceDirname)
+	// remove leftovers of a failed run
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	metas, err := listBlocks(s.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range metas {
+		if err := linkDir(filepath.Join(s.path, m.ULID.String()), filepath.Join(dir, m.ULID.String())); err != nil {
+			_ = os.RemoveAll(dir)
+			return nil, fmt.Errorf("error linking block %s into workspace: %w", m.ULID, err)
+		}
+	}
+
+	ws := s
+	ws.path = dir
+	return &workspace{stream: ws, target: s.path}, nil
+}
+
+// commit moves the blocks created by the run into the stream and removes the
+// blocks the run has deleted, e.g. due to retention. Committing again is a
+// no-op. A missing workspace fails the commit, as it would otherwise remove
+// all blocks of the stream.
+func (w *workspace) commit() error {
+	if w.committed {
+		return nil
+	}
+	if _, err := os.Stat(w.path); err != nil {
+		return fmt.Errorf("error reading workspace: %w", err)
+	}
+
+	wsMetas, err := listBlocks(w.path)
+	if err != nil {
+		return err
+	}
+	targetMetas, err := listBlocks(w.target)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]struct{}, len(targetMetas))
+	for _, m := range targetMetas {
+		existing[m.ULID.String()] = struct{}{}
+	}
+	keep := make(map[string]struct{}, len(wsMetas))
+	for _, m := range wsMetas {
+		id := m.ULID.String()
+		keep[id] = struct{}{}
+		if _, ok := existing[id]; ok {
+			continue
+		}
+		if err := os.Rename(filepath.Join(w.path, id), filepath.Join(w.target, id)); err != nil {
+			return fmt.Errorf("error moving block %s out of workspace: %w", id, err)
+		}
+	}
+	for id := range existing {
+		if _, ok := keep[id]; ok {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(w.target, id)); err != nil {
+			return err
+		}
+	}
+
+	w.committed = true
+	return w.discard()
+}
+
+// discard removes the workspace without touching the stream.
+func (w *workspace) discard() error {
+	return os.RemoveAll(w.path)
+}
+
+// flushHead persists all samples of the head into blocks aligned to the block
+// duration, so no state is kept in the WAL between runs.
+func (a *App) flushHead(db *tsdb.DB) error {
+	if err := db.Compact(); err != nil {
+		return err
+	}
+
+	head := db.Head()
+	if head.NumSeries() == 0 {
+		return nil
+	}
+	dur := a.cfg.tsdbBlockDuration.Milliseconds()
+	mint, maxt := head.MinTime(), head.MaxTime()
+	for t := mint - mint%dur; t <= maxt; t += dur {
+		if err := db.CompactHead(tsdb.NewRangeHead(head, t, t+dur-1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactHeadBefore persists the complete block ranges of the head, which end
+// before t, so their memory is released. Samples before t can't be appended
+// afterwards.
+func (a *App) compactHeadBefore(db *tsdb.DB, t time.Time) error {
+	head := db.Head()
+	if head.NumSeries() == 0 {
+		return nil
+	}
+	dur := a.cfg.tsdbBlockDuration.Milliseconds()
+	mint, end := head.MinTime(), timestamp.FromTime(t)
+	var compacted int
+	for r := mint - mint%dur; r+dur <= end; r += dur {
+		if err := db.CompactHead(tsdb.NewRangeHead(head, r, r+dur-1)); err != nil {
+			return err
+		}
+		compacted++
+	}
+	_ = level.Debug(a.logger).Log("msg", "persisted head within memory budget", "blocks", compacted, "before", t)
+	return nil
+}
+
+// flushLegacyHead persists a head left behind in the stream itself, by
+// versions which imported into the stream directly.
+func (a *App) flushLegacyHead(path string) error {
+	walDir := filepath.Join(path, "wal")
+	if _, err := os.Stat(walDir); os.IsNotExist(err) {
+		return nil
+	}
+	_ = level.Info(a.logger).Log("msg", "persisting head left in TSDB by a previous version", "path", path)
+
+	options := tsdb.DefaultOptions()
+	// only persist the head, the retention is up to the runs
+	options.RetentionDuration = 0
+	options.MinBlockDuration = a.cfg.tsdbBlockDuration.Milliseconds()
+	options.MaxBlockDuration = a.cfg.tsdbBlockDuration.Milliseconds()
+	db, err := tsdb.Open(path, &logLevelOverride{next: a.logger, level: level.DebugValue()}, nil, options, nil)
+	if err != nil {
+		return err
+	}
+	if err := a.flushHead(db); err != nil {
+		_ = db.Close()
+		return fmt.Errorf("error persisting head of %s: %w", path, err)
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(filepath.Join(path, "chunks_head")); err != nil {
+		return err
+	}
+	return os.RemoveAll(walDir)
+}
+
+// linkDir recreates the directory tree of src at dst using hard links.
+func linkDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0o755)
+		}
+		return os.Link(path, filepath.Join(dst, rel))
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/simonswine/thames-water-importer/app"
+)
+
+func backfillCommand(newApp appFactory) *cli.Command {
+	return &cli.Command{
+		Name:  "backfill",
+		Usage: "Import the available history at a throttled request rate, including the days before the stored data, committing the progress every few days, so it resumes where it stopped when started again",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Backfill all days with readings available.",
+			},
+			&cli.StringFlag{
+				Name:  "from",
+				Usage: "Backfill the days starting at this date, e.g. 2023-01-01.",
+			},
+			&cli.StringFlag{
+				Name:  "to",
+				Usage: "Backfill the days up to this date, e.g. 2023-12-31.",
+			},
+			&cli.Float64Flag{
+				Name:  "rps",
+				Usage: "Requests per second sent to the provider. 0 disables the throttling.",
+				Value: 0.2,
+			},
+			&cli.IntFlag{
+				Name:  "checkpoint-days",
+				Usage: "Number of days imported by a single run, after which the progress is committed to the local TSDB.",
+				Value: app.DefaultBackfillCheckpointDays,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.IsSet("households-config-file") {
+				return fmt.Errorf("backfill doesn't support households")
+			}
+			if c.Bool("all") == (c.IsSet("from") || c.IsSet("to")) {
+				return fmt.Errorf("either --all or a range with --from and --to is required")
+			}
+			if c.Float64("rps") < 0 {
+				return fmt.Errorf("invalid request rate %v, can't be negative", c.Float64("rps"))
+			}
+			if err := requireRunFlags(c); err != nil {
+				return err
+			}
+
+			from, err := parseTime(c.String("from"))
+			if err != nil {
+				return err
+			}
+			to, err := parseTime(c.String("to"))
+			if err != nil {
+				return err
+			}
+			if !from.IsZero() && !to.IsZero() && to.Before(from) {
+				return fmt.Errorf("--to %s is before --from %s", c.String("to"), c.String("from"))
+			}
+
+			a, err := newApp(c)
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			return a.Backfill(ctx, app.BackfillConfig{
+				Start:             from,
+				End:               to,
+				RequestsPerSecond: c.Float64("rps"),
+				CheckpointDays:    c.Int("checkpoint-days"),
+			})
+		},
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/simonswine/thames-water-importer/app"
+	"github.com/urfave/cli/v2"
+)
+
+// householdsFactory creates the households of the households config file.
+type householdsFactory func(c *cli.Context) (*app.Households, error)
+
+func daemonCommand(newApp appFactory, newHouseholds householdsFactory) *cli.Command {
+	return &cli.Command{
+		Name:  "daemon",
+		Usage: "Import new data periodically and expose the importer's own metrics on /metrics",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "listen-address",
+				Usage: "Address to listen on for scrapes of the importer's own metrics.",
+				Value: ":9855",
+			},
+			&cli.DurationFlag{
+				Name:  "interval",
+				Usage: "Time between two imports.",
+				Value: 6 * time.Hour,
+			},
+			&cli.DurationFlag{
+				Name:  "ready-max-age",
+				Usage: "Maximum age of the last successful run, for /readyz to report ready.",
+				Value: 25 * time.Hour,
+			},
+			&cli.BoolFlag{
+				Name:  "enable-pprof",
+				Usage: "Serve the Go pprof handlers under /debug/pprof/, e.g. to profile the memory usage of large backfills. Only enable this on trusted networks.",
+			},
+			&cli.BoolFlag{
+				Name:  "disable-web-ui",
+				Usage: "Don't serve the web UI on /, which shows the recent runs, the last errors, the freshness of the data per meter and the daily consumption. The web UI requires no token, like /metrics.",
+			},
+			&cli.StringFlag{
+				Name:    "api-token",
+				Usage:   "Enable the API to trigger runs with POST /api/v1/run and poll their status with GET /api/v1/runs/{id} and list the run history with GET /api/v1/runs, which requires this token as bearer token.",
+				EnvVars: []string{"API_TOKEN"},
+			},
+			&cli.StringFlag{
+				Name:  "grpc-listen-address",
+				Usage: "Serve the gRPC control API, to trigger and list runs and read the newest readings, on this address, e.g. :9856. Requires --api-token.",
+			},
+			&cli.DurationFlag{
+				Name:  "config-reload-interval",
+				Usage: "Interval to check the config files for changes. Tariffs, labels, notification and sink settings are reloaded on changes or SIGHUP and apply from the next run on. Set to 0 to only reload on SIGHUP.",
+				Value: 30 * time.Second,
+			},
+			&cli.BoolFlag{
+				Name:  "leader-election",
+				Usage: "Elect a leader among multiple replicas via a Kubernetes Lease. Only the leader imports and uploads, the other replicas stand by. The service account needs to be allowed to get, create and update Leases.",
+			},
+			&cli.StringFlag{
+				Name:    "leader-election-namespace",
+				Usage:   "Namespace of the Lease, defaults to the namespace of the pod.",
+				EnvVars: []string{"POD_NAMESPACE"},
+			},
+			&cli.StringFlag{
+				Name:  "leader-election-lease-name",
+				Usage: "Name of the Lease.",
+				Value: "thames-water-importer",
+			},
+			&cli.StringFlag{
+				Name:    "leader-election-identity",
+				Usage:   "Identity of the replica, defaults to the hostname.",
+				EnvVars: []string{"POD_NAME"},
+			},
+			&cli.DurationFlag{
+				Name:  "leader-election-lease-duration",
+				Usage: "Time the other replicas wait, before taking over from a leader, which stopped renewing the Lease.",
+				Value: 15 * time.Second,
+			},
+			&cli.PathFlag{
+				Name:    "kubeconfig",
+				Usage:   "Kubeconfig used for the leader election outside of a cluster.",
+				EnvVars: []string{"KUBECONFIG"},
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if err := requireRunFlags(c); err != nil {
+				return err
+			}
+
+			var (
+				a   daemonRunner
+				err error
+			)
+			if c.IsSet("households-config-file") {
+				a, err = newHouseholds(c)
+			} else {
+				a, err = newApp(c)
+			}
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			defer signal.Stop(hup)
+			go func() {
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-hup:
+						_ = a.Reload()
+					}
+				}
+			}()
+
+			cfg := app.DaemonConfig{
+				ListenAddress: c.String("listen-address"),
+				Interval:      c.Duration("interval"),
+				ReadyMaxAge:   c.Duration("ready-max-age"),
+				EnablePprof:   c.Bool("enable-pprof"),
+				DisableWebUI:  c.Bool("disable-web-ui"),
+				APIToken:      c.String("api-token"),
+
+				GRPCListenAddress:    c.String("grpc-listen-address"),
+				ConfigReloadInterval: c.Duration("config-reload-interval"),
+			}
+			if c.Bool("leader-election") {
+				if d := c.Duration("leader-election-lease-duration"); d < 3*time.Second {
+					return fmt.Errorf("invalid leader election lease duration %s, must be at least 3s", d)
+				}
+				cfg.LeaderElection = &app.LeaderElection{
+					Namespace:     c.String("leader-election-namespace"),
+					LeaseName:     c.String("leader-election-lease-name"),
+					Identity:      c.String("leader-election-identity"),
+					Kubeconfig:    c.Path("kubeconfig"),
+					LeaseDuration: c.Duration("leader-election-lease-duration"),
+				}
+			}
+
+			return a.Daemon(ctx, cfg)
+		},
+	}
+}
+
+// daemonRunner is either a single App or the households.
+type daemonRunner interface {
+	Daemon(ctx context.Context, cfg app.DaemonConfig) error
+	Reload() error
+}
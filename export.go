@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/simonswine/thames-water-importer/app"
+)
+
+func exportCommand(newApp appFactory) *cli.Command {
+	return &cli.Command{
+		Name:  "export",
+		Usage: "Export the data stored in the local TSDB",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Export format, either openmetrics, csv or parquet. openmetrics produces a file for `promtool tsdb create-blocks-from openmetrics`, parquet writes files partitioned by month into --output-dir.",
+				Value: "openmetrics",
+			},
+			&cli.StringFlag{
+				Name:  "source",
+				Usage: "Source of the readings, either tsdb for the local TSDB or api for a fresh fetch from Thames Water. Only the api provides usage and estimated flags.",
+				Value: "tsdb",
+			},
+			&cli.StringFlag{
+				Name:  "start",
+				Usage: "Start of the exported time range (RFC3339 or YYYY-MM-DD). Defaults to the earliest sample.",
+			},
+			&cli.StringFlag{
+				Name:  "end",
+				Usage: "End of the exported time range (RFC3339 or YYYY-MM-DD). Defaults to now.",
+			},
+			&cli.PathFlag{
+				Name:  "output-file",
+				Usage: "File to write the export to. Defaults to stdout.",
+			},
+			&cli.PathFlag{
+				Name:  "output-dir",
+				Usage: "Directory to write partitioned exports to.",
+				Value: "./export",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			start, err := parseTime(c.String("start"))
+			if err != nil {
+				return err
+			}
+			end, err := parseTime(c.String("end"))
+			if err != nil {
+				return err
+			}
+			if start.IsZero() {
+				start = time.Unix(0, 0)
+			}
+			if end.IsZero() {
+				end = time.Now()
+			}
+
+			a, err := newApp(c)
+			if err != nil {
+				return err
+			}
+
+			source := c.String("source")
+			if source != "tsdb" && source != "api" {
+				return fmt.Errorf("unknown source '%s'", source)
+			}
+			readings := func() ([]app.Reading, error) {
+				if source == "api" {
+					if err := requireLoginFlags(c); err != nil {
+						return nil, err
+					}
+					return a.FetchReadings(c.Context, start, end)
+				}
+				return a.StoredReadings(c.Context, start, end)
+			}
+
+			if c.String("format") == "parquet" {
+				rs, err := readings()
+				if err != nil {
+					return err
+				}
+				return a.ExportParquet(c.Path("output-dir"), rs, source == "tsdb")
+			}
+
+			var w io.Writer = os.Stdout
+			if path := c.Path("output-file"); path != "" {
+				f, err := os.Create(path)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+
+			switch c.String("format") {
+			case "csv":
+				rs, err := readings()
+				if err != nil {
+					return err
+				}
+				return a.ExportCSV(w, rs, source == "tsdb")
+			case "openmetrics":
+				if source != "tsdb" {
+					return fmt.Errorf("the openmetrics format only supports the tsdb source")
+				}
+				return a.ExportOpenMetrics(c.Context, w, start, end)
+			default:
+				return fmt.Errorf("unknown export format '%s'", c.String("format"))
+			}
+		},
+	}
+}
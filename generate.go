@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/simonswine/thames-water-importer/app"
+)
+
+var generateOutputFileFlag = &cli.PathFlag{
+	Name:  "output-file",
+	Usage: "Write to this file instead of stdout.",
+}
+
+// writeGenerated writes the generated file to the output file or stdout.
+func writeGenerated(c *cli.Context, data []byte) error {
+	data = append(data, '\n')
+	if path := c.Path("output-file"); path != "" {
+		return os.WriteFile(path, data, 0o644)
+	}
+	_, err := os.Stdout.Write(data)
+	return err
+}
+
+func generateCommand(newApp appFactory) *cli.Command {
+	return &cli.Command{
+		Name:  "generate",
+		Usage: "Generate files matching the configured metric names and labels",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "dashboard",
+				Usage: "Generate a Grafana dashboard of the consumption, costs, emissions and anomalies, ready to import",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "title",
+						Usage: "Title of the dashboard.",
+						Value: "Water consumption",
+					},
+					&cli.StringFlag{
+						Name:  "uid",
+						Usage: "UID of the dashboard, generated by Grafana on import if empty.",
+					},
+					generateOutputFileFlag,
+				},
+				Action: func(c *cli.Context) error {
+					a, err := newApp(c)
+					if err != nil {
+						return err
+					}
+
+					data, err := a.GenerateDashboard(app.DashboardConfig{
+						Title: c.String("title"),
+						UID:   c.String("uid"),
+					})
+					if err != nil {
+						return err
+					}
+					return writeGenerated(c, data)
+				},
+			},
+			{
+				Name:  "rules",
+				Usage: "Generate Prometheus recording rules of the daily and weekly totals and alerting rules for stale data, leaks and failing imports",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "lag-days",
+						Usage: "Number of days the imported readings lag behind, which the totals and the leak alert look back.",
+						Value: 2,
+					},
+					&cli.DurationFlag{
+						Name:  "stale-after",
+						Usage: "Age of the newest imported reading, after which the data is alerted on as stale.",
+						Value: 72 * time.Hour,
+					},
+					&cli.DurationFlag{
+						Name:  "failing-for",
+						Usage: "Time the last run needs to have failed for, before it is alerted on.",
+						Value: 6 * time.Hour,
+					},
+					generateOutputFileFlag,
+				},
+				Action: func(c *cli.Context) error {
+					a, err := newApp(c)
+					if err != nil {
+						return err
+					}
+
+					// the leak alert searches the same night as the leak
+					// detection of the runs
+					data, err := a.GenerateRules(app.RulesConfig{
+						LagDays:    c.Int("lag-days"),
+						StaleAfter: c.Duration("stale-after"),
+						FailingFor: c.Duration("failing-for"),
+						Leak: app.LeakDetection{
+							StartHour: c.Int("leak-detection-start-hour"),
+							EndHour:   c.Int("leak-detection-end-hour"),
+							MinLiters: c.Float64("leak-detection-min-liters"),
+						},
+					})
+					if err != nil {
+						return err
+					}
+					return writeGenerated(c, data)
+				},
+			},
+		},
+	}
+}
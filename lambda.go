@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/urfave/cli/v2"
+)
+
+func lambdaCommand(newApp appFactory) *cli.Command {
+	return &cli.Command{
+		Name:  "lambda",
+		Usage: "Serve AWS Lambda invocations, e.g. by an EventBridge schedule, with an import and upload per invocation. Run it from the bootstrap of a custom runtime, with --chrome-remote-url pointing to a remote browser and --tsdb-path to an EFS mount, as /tmp doesn't survive cold starts.",
+		Action: func(c *cli.Context) error {
+			if err := requireRunFlags(c); err != nil {
+				return err
+			}
+
+			a, err := newApp(c)
+			if err != nil {
+				return err
+			}
+
+			// a failed run fails the invocation, so it shows up in the
+			// Lambda metrics and the event can be retried
+			lambda.StartWithContext(c.Context, func(ctx context.Context) error {
+				return a.Run(ctx)
+			})
+			return nil
+		},
+	}
+}
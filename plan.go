@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/simonswine/thames-water-importer/app"
+)
+
+// printPlan prints the days of the plan per meter, followed by the number of
+// days to fetch.
+func printPlan(plan *app.Plan) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METER\tDAY\tACTION\tREASON")
+	for _, d := range plan.Days {
+		action := "skip"
+		if d.Fetch {
+			action = "fetch"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Meter, d.Day.Format("2006-01-02"), action, d.Reason)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Printf("\nPlan: %d of %d days of %d meters to fetch.\n", plan.Fetches(), len(plan.Days), len(plan.Meters))
+	return err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/urfave/cli/v2"
+
+	"github.com/simonswine/thames-water-importer/app"
+)
+
+// parseLast returns the time range covering the given duration until now. The
+// start is aligned to full days.
+func parseLast(s string) (time.Time, time.Time, error) {
+	d, err := model.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid duration '%s': %w", s, err)
+	}
+	to := time.Now().UTC()
+	return to.Add(-time.Duration(d)).Truncate(24*time.Hour).AddDate(0, 0, 1), to, nil
+}
+
+var outputFlag = &cli.StringFlag{
+	Name:  "output",
+	Usage: "Output format, either table or json.",
+	Value: "table",
+}
+
+func writeJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func summaryCommand(newApp appFactory) *cli.Command {
+	return &cli.Command{
+		Name:  "summary",
+		Usage: "Print the daily and total consumption stored in the local TSDB",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "last",
+				Usage: "Time range to summarize, e.g. 7d or 4w.",
+				Value: "30d",
+			},
+			outputFlag,
+		},
+		Action: func(c *cli.Context) error {
+			from, to, err := parseLast(c.String("last"))
+			if err != nil {
+				return err
+			}
+
+			a, err := newApp(c)
+			if err != nil {
+				return err
+			}
+
+			s, err := a.Summary(c.Context, from, to)
+			if err != nil {
+				return err
+			}
+
+			switch c.String("output") {
+			case "json":
+				return writeJSON(s)
+			case "table":
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+				// the cost is only known, if tariffs are configured
+				if s.TotalCostGBP == 0 {
+					fmt.Fprintln(w, "DATE\tMETER\tLITERS\t")
+					for _, d := range s.Days {
+						fmt.Fprintf(w, "%s\t%s\t%.0f\t\n", d.Date.Format("2006-01-02"), d.Meter, d.Liters)
+					}
+					fmt.Fprintf(w, "TOTAL\t\t%.0f\t\n", s.TotalLiters)
+					return w.Flush()
+				}
+				fmt.Fprintln(w, "DATE\tMETER\tLITERS\tGBP\t")
+				for _, d := range s.Days {
+					fmt.Fprintf(w, "%s\t%s\t%.0f\t%.2f\t\n", d.Date.Format("2006-01-02"), d.Meter, d.Liters, d.CostGBP)
+				}
+				fmt.Fprintf(w, "TOTAL\t\t%.0f\t%.2f\t\n", s.TotalLiters, s.TotalCostGBP)
+				return w.Flush()
+			default:
+				return fmt.Errorf("unknown output format '%s'", c.String("output"))
+			}
+		},
+	}
+}
+
+func compareCommand(newApp appFactory) *cli.Command {
+	return &cli.Command{
+		Name:  "compare",
+		Usage: "Compare the consumption of the current week or billing month with the previous period and the same period last year",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "period",
+				Usage: "Period to compare, either week or month. Months are aligned to --billing-anchor-day.",
+				Value: "week",
+			},
+			&cli.Float64Flag{
+				Name:  "threshold",
+				Usage: "Increase in percent, above which a change is flagged as significant.",
+				Value: 20,
+			},
+			outputFlag,
+		},
+		Action: func(c *cli.Context) error {
+			a, err := newApp(c)
+			if err != nil {
+				return err
+			}
+
+			cmp, err := a.Compare(c.Context, c.String("period"), time.Now().UTC(), c.Float64("threshold"))
+			if err != nil {
+				return err
+			}
+
+			switch c.String("output") {
+			case "json":
+				return writeJSON(cmp)
+			case "table":
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "PERIOD\tFROM\tTO\tLITERS\tCHANGE")
+				for _, row := range []struct {
+					name string
+					p    app.PeriodConsumption
+				}{
+					{"current " + cmp.Period, cmp.Current},
+					{"previous " + cmp.Period, cmp.Previous},
+					{"last year", cmp.LastYear},
+				} {
+					change := ""
+					if row.p.ChangePercent != nil {
+						change = fmt.Sprintf("%+.1f%%", *row.p.ChangePercent)
+					}
+					if row.p.Significant {
+						change += " (significant increase)"
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%.0f\t%s\n", row.name, row.p.From.Format("2006-01-02 15:04"), row.p.To.Format("2006-01-02 15:04"), row.p.Liters, change)
+				}
+				return w.Flush()
+			default:
+				return fmt.Errorf("unknown output format '%s'", c.String("output"))
+			}
+		},
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+func runsCommand(newApp appFactory) *cli.Command {
+	return &cli.Command{
+		Name:  "runs",
+		Usage: "Inspect the run history, recorded with --run-history",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "List the most recent runs, newest first",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "limit",
+						Usage: "Maximum number of runs to list.",
+						Value: 20,
+					},
+					outputFlag,
+				},
+				Action: func(c *cli.Context) error {
+					a, err := newApp(c)
+					if err != nil {
+						return err
+					}
+
+					records, err := a.RunHistory(c.Context, c.Int("limit"))
+					if err != nil {
+						return err
+					}
+
+					switch c.String("output") {
+					case "json":
+						return writeJSON(records)
+					case "table":
+						w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+						fmt.Fprintln(w, "ID\tTRIGGER\tSTATUS\tSTART\tDURATION\tDAYS\tSAMPLES\tERROR")
+						for _, r := range records {
+							fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
+								r.ID,
+								r.Trigger,
+								r.Status,
+								r.Start.Format(time.RFC3339),
+								r.End.Sub(r.Start),
+								r.DaysImported,
+								r.Samples,
+								r.Error,
+							)
+						}
+						return w.Flush()
+					default:
+						return fmt.Errorf("unknown output format '%s'", c.String("output"))
+					}
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+)
+
+func runServerCommand(newApp appFactory) *cli.Command {
+	return &cli.Command{
+		Name:  "run-server",
+		Usage: "Run an import per POST request to / and respond with the JSON summary, e.g. on Google Cloud Run or Cloud Functions triggered by Cloud Scheduler",
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:    "port",
+				Usage:   "Port to listen on, set by Cloud Run.",
+				Value:   8080,
+				EnvVars: []string{"PORT"},
+			},
+			&cli.DurationFlag{
+				Name:  "run-timeout",
+				Usage: "Cancel runs after this duration. Set it below the platform's request timeout, to still respond with the summary. By default runs are only cancelled together with the request.",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if err := requireRunFlags(c); err != nil {
+				return err
+			}
+
+			a, err := newApp(c)
+			if err != nil {
+				return err
+			}
+
+			// Cloud Run sends SIGTERM before shutting down an instance
+			ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			return a.ServeRuns(ctx, fmt.Sprintf(":%d", c.Int("port")), c.Duration("run-timeout"))
+		},
+	}
+}
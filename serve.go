@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+func serveCommand(newApp appFactory) *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "Expose the newest consumption on /metrics and import new data when Prometheus scrapes",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "listen-address",
+				Usage: "Address to listen on for scrapes.",
+				Value: ":9855",
+			},
+			&cli.DurationFlag{
+				Name:  "min-refresh-interval",
+				Usage: "Minimum time between two imports triggered by scrapes. Scrapes in between are answered from the cache.",
+				Value: time.Hour,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if err := requireRunFlags(c); err != nil {
+				return err
+			}
+
+			a, err := newApp(c)
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			return a.Serve(ctx, c.String("listen-address"), c.Duration("min-refresh-interval"))
+		},
+	}
+}
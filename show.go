@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/simonswine/thames-water-importer/app"
+)
+
+const showBarWidth = 40
+
+var sparklineBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws the values as a line of block characters, scaled between
+// zero and the maximum.
+func sparkline(values []float64) string {
+	var max float64
+	for _, v := range values {
+		max = math.Max(max, v)
+	}
+	var sb strings.Builder
+	for _, v := range values {
+		pos := 0
+		if max > 0 {
+			pos = int(v / max * float64(len(sparklineBlocks)-1))
+		}
+		sb.WriteRune(sparklineBlocks[pos])
+	}
+	return sb.String()
+}
+
+func showCommand(newApp appFactory) *cli.Command {
+	return &cli.Command{
+		Name:  "show",
+		Usage: "Print the recent daily or hourly consumption as a table with bars or as sparklines",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "last",
+				Usage: "Time range to show, e.g. 7d or 4w.",
+				Value: "7d",
+			},
+			&cli.StringFlag{
+				Name:  "resolution",
+				Usage: "Resolution of the consumption, either daily or hourly.",
+				Value: "daily",
+			},
+			&cli.StringFlag{
+				Name:  "source",
+				Usage: "Source of the readings, either tsdb for the local TSDB or api for a fresh fetch from Thames Water.",
+				Value: "tsdb",
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "Output format, either table, sparkline or json.",
+				Value: "table",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			from, to, err := parseLast(c.String("last"))
+			if err != nil {
+				return err
+			}
+
+			var (
+				period time.Duration
+				layout string
+			)
+			switch c.String("resolution") {
+			case "daily":
+				period, layout = 24*time.Hour, "Mon 2006-01-02"
+			case "hourly":
+				period, layout = time.Hour, "Mon 2006-01-02 15:04"
+			default:
+				return fmt.Errorf("unknown resolution '%s'", c.String("resolution"))
+			}
+
+			a, err := newApp(c)
+			if err != nil {
+				return err
+			}
+
+			var readings []app.Reading
+			switch c.String("source") {
+			case "api":
+				if err := requireLoginFlags(c); err != nil {
+					return err
+				}
+				readings, err = a.FetchReadings(c.Context, from, to)
+			case "tsdb":
+				readings, err = a.StoredReadings(c.Context, from, to)
+			default:
+				return fmt.Errorf("unknown source '%s'", c.String("source"))
+			}
+			if err != nil {
+				return err
+			}
+			periods := app.GroupReadings(readings, period)
+
+			switch c.String("output") {
+			case "json":
+				return writeJSON(periods)
+			case "sparkline":
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "METER\tFROM\tTO\tUSAGE\tMAX\tTOTAL")
+				for start := 0; start < len(periods); {
+					end := start
+					var values []float64
+					var max, total float64
+					for ; end < len(periods) && periods[end].Meter == periods[start].Meter; end++ {
+						values = append(values, periods[end].Liters)
+						max = math.Max(max, periods[end].Liters)
+						total += periods[end].Liters
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.0f\t%.0f\n",
+						periods[start].Meter,
+						periods[start].Start.Format(layout),
+						periods[end-1].Start.Format(layout),
+						sparkline(values),
+						max,
+						total,
+					)
+					start = end
+				}
+				return w.Flush()
+			case "table":
+				var max float64
+				for _, p := range periods {
+					max = math.Max(max, p.Liters)
+				}
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "METER\tPERIOD\tLITERS\t")
+				for _, p := range periods {
+					bar := ""
+					if max > 0 {
+						bar = strings.Repeat("█", int(math.Round(p.Liters/max*showBarWidth)))
+					}
+					if p.Estimated {
+						bar += " (estimated)"
+					}
+					fmt.Fprintf(w, "%s\t%s\t%8.0f\t%s\n", p.Meter, p.Start.Format(layout), p.Liters, bar)
+				}
+				return w.Flush()
+			default:
+				return fmt.Errorf("unknown output format '%s'", c.String("output"))
+			}
+		},
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/urfave/cli/v2"
+
+	"github.com/simonswine/thames-water-importer/app"
+)
+
+type appFactory func(c *cli.Context) (*app.App, error)
+
+// parseTime accepts either RFC3339 timestamps or dates.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time '%s', expected RFC3339 or YYYY-MM-DD", s)
+	}
+	return t, nil
+}
+
+// parseLabelPairs parses name=value pairs into a flat list of names and
+// values.
+func parseLabelPairs(strs []string) ([]string, error) {
+	var result []string
+	for _, lbl := range strs {
+		parts := strings.Split(lbl, "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid label '%s'", lbl)
+		}
+		result = append(result, parts[0], parts[1])
+	}
+	return result, nil
+}
+
+func migrateBlocksCommand(newApp appFactory) *cli.Command {
+	return &cli.Command{
+		Name:  "migrate-blocks",
+		Usage: "Rewrite blocks with a new label set or metric name into new blocks with new ULIDs. Data still in the TSDB head is not migrated.",
+		Flags: []cli.Flag{
+			&cli.PathFlag{
+				Name:  "source-dir",
+				Usage: "Directory containing the blocks to migrate, e.g. downloaded from the bucket. Defaults to the local TSDB.",
+			},
+			&cli.PathFlag{
+				Name:  "output-dir",
+				Usage: "Directory the migrated blocks are written to, the blocks of every local stream into a subdirectory named like the stream. Defaults to the directory of the source blocks.",
+			},
+			&cli.BoolFlag{
+				Name:  "keep-source",
+				Usage: "Keep the source blocks, instead of removing them after migration. Requires --output-dir.",
+			},
+			&cli.StringSliceFlag{
+				Name:  "set-label",
+				Usage: "Label to add or override on all series, in the form <name>=<value>.",
+			},
+			&cli.StringSliceFlag{
+				Name:  "drop-label",
+				Usage: "Label to remove from all series.",
+			},
+			&cli.StringSliceFlag{
+				Name:  "rename-metric",
+				Usage: "Metric to rename, in the form <old>=<new>.",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			setLabels, err := parseLabelPairs(c.StringSlice("set-label"))
+			if err != nil {
+				return err
+			}
+			renames, err := parseLabelPairs(c.StringSlice("rename-metric"))
+			if err != nil {
+				return err
+			}
+
+			rewrite := app.LabelRewrite{
+				Set:          labels.FromStrings(setLabels...),
+				Drop:         c.StringSlice("drop-label"),
+				RenameMetric: make(map[string]string),
+			}
+			for i := 0; i < len(renames); i += 2 {
+				rewrite.RenameMetric[renames[i]] = renames[i+1]
+			}
+
+			a, err := newApp(c)
+			if err != nil {
+				return err
+			}
+
+			return a.MigrateBlocks(c.Context, c.Path("source-dir"), c.Path("output-dir"), c.Bool("keep-source"), rewrite)
+		},
+	}
+}
+
+func tsdbCommand(newApp appFactory) *cli.Command {
+	return &cli.Command{
+		Name:  "tsdb",
+		Usage: "Maintain the local TSDB",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "blocks",
+				Usage: "List the blocks of the local TSDB and whether they have been uploaded",
+				Action: func(c *cli.Context) error {
+					a, err := newApp(c)
+					if err != nil {
+						return err
+					}
+
+					blocks, err := a.Blocks(c.Context)
+					if err != nil {
+						return err
+					}
+
+					w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(w, "ULID\tMIN TIME\tMAX TIME\tDURATION\tNUM SAMPLES\tNUM SERIES\tNUM CHUNKS\tUPLOADED\tPATH")
+					for _, b := range blocks {
+						fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%t\t%s\n",
+							b.ULID,
+							b.MinTime.UTC().Format(time.RFC3339),
+							b.MaxTime.UTC().Format(time.RFC3339),
+							b.MaxTime.Sub(b.MinTime),
+							b.NumSamples,
+							b.NumSeries,
+							b.NumChunks,
+							b.Uploaded,
+							b.Path,
+						)
+					}
+					return w.Flush()
+				},
+			},
+			{
+				Name:  "verify",
+				Usage: "Verify the index and chunk integrity of all local blocks",
+				Action: func(c *cli.Context) error {
+					a, err := newApp(c)
+					if err != nil {
+						return err
+					}
+
+					results, err := a.VerifyBlocks(c.Context)
+					if err != nil {
+						return err
+					}
+
+					var failed int
+					for _, r := range results {
+						if r.Err != nil {
+							failed++
+							fmt.Printf("%s\tFAILED\t%v\n", r.Path, r.Err)
+							continue
+						}
+						fmt.Printf("%s\tOK\n", r.Path)
+					}
+
+					if failed > 0 {
+						return fmt.Errorf("%d of %d blocks failed verification", failed, len(results))
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "dedupe",
+				Usage: "Merge overlapping local blocks into single blocks, resolving duplicate samples in favour of actual over estimated readings and then of the most recently created block. Blocks uploaded already are skipped, their overlaps need to be resolved in the bucket.",
+				Action: func(c *cli.Context) error {
+					a, err := newApp(c)
+					if err != nil {
+						return err
+					}
+
+					n, err := a.DeduplicateBlocks(c.Context)
+					if err != nil {
+						return err
+					}
+
+					fmt.Printf("merged %d groups of overlapping blocks\n", n)
+					return nil
+				},
+			},
+			{
+				Name:  "delete",
+				Usage: "Delete series matching the matchers from the local TSDB. The blocks containing them are rewritten under new ULIDs, so it fails, if any of them has been uploaded already.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "matcher",
+						Usage:    "Series selector of the series to delete, e.g. 'water_consumption_liters{meter=\"X\"}'.",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "from",
+						Usage: "Start of the time range to delete (RFC3339 or YYYY-MM-DD). Defaults to the earliest sample.",
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "End of the time range to delete (RFC3339 or YYYY-MM-DD). Defaults to the latest sample.",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					from, err := parseTime(c.String("from"))
+					if err != nil {
+						return err
+					}
+					to, err := parseTime(c.String("to"))
+					if err != nil {
+						return err
+					}
+
+					matchers, err := parser.ParseMetricSelector(c.String("matcher"))
+					if err != nil {
+						return fmt.Errorf("invalid matcher '%s': %w", c.String("matcher"), err)
+					}
+
+					a, err := newApp(c)
+					if err != nil {
+						return err
+					}
+
+					return a.DeleteSeries(c.Context, matchers, from, to)
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/urfave/cli/v2"
+)
+
+func uploadCommand(newApp appFactory) *cli.Command {
+	return &cli.Command{
+		Name:  "upload",
+		Usage: "Upload the blocks of the local TSDB to the bucket, without importing new data",
+		Action: func(c *cli.Context) error {
+			if err := requireOneFlag(c, bucketFlags...); err != nil {
+				return err
+			}
+
+			a, err := newApp(c)
+			if err != nil {
+				return err
+			}
+
+			return a.Upload(c.Context)
+		},
+	}
+}
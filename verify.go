@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+func verifyDataCommand(newApp appFactory) *cli.Command {
+	return &cli.Command{
+		Name:  "verify-data",
+		Usage: "Fetch a sample of past days again and compare them with the stored readings, to find days corrected retroactively, which need to be imported again",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "last",
+				Usage: "Time range to sample the days from, e.g. 30d or 12w.",
+				Value: "90d",
+			},
+			&cli.IntFlag{
+				Name:  "sample",
+				Usage: "Number of days to verify, spread evenly across the time range. 0 verifies every day.",
+				Value: 7,
+			},
+			&cli.Float64Flag{
+				Name:  "tolerance",
+				Usage: "Difference in liters, up to which a stored reading still matches the API.",
+				Value: 0.001,
+			},
+			outputFlag,
+		},
+		Action: func(c *cli.Context) error {
+			if err := requireLoginFlags(c); err != nil {
+				return err
+			}
+			from, to, err := parseLast(c.String("last"))
+			if err != nil {
+				return err
+			}
+
+			a, err := newApp(c)
+			if err != nil {
+				return err
+			}
+
+			v, err := a.VerifyData(c.Context, from, to, c.Int("sample"), c.Float64("tolerance"))
+			if err != nil {
+				return err
+			}
+
+			switch c.String("output") {
+			case "json":
+				if err := writeJSON(v); err != nil {
+					return err
+				}
+			case "table":
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "METER\tDAY\tSTATUS\tSOURCE\tREMOTE\tSTORED\tCHANGED\tMISSING\tEXTRA")
+				for _, d := range v.Days {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.0f\t%.0f\t%d\t%d\t%d\n",
+						d.Meter,
+						d.Day.Format("2006-01-02"),
+						d.Status,
+						d.Source,
+						d.RemoteLiters,
+						d.StoredLiters,
+						d.Changed,
+						d.Missing,
+						d.Extra,
+					)
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unknown output format '%s'", c.String("output"))
+			}
+
+			// fail, so scheduled verifications notice the drift
+			if n := v.Drifted(); n > 0 {
+				return fmt.Errorf("%d of %d verified days drifted from the API, import them again", n, len(v.Days))
+			}
+			return nil
+		},
+	}
+}